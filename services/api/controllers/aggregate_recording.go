@@ -44,8 +44,11 @@ func AggregateTSFilesToMP4(tsFiles []string, outputDir, outputFile string) error
     fullOutputPath := filepath.Join(outputDir, outputFile)
 
     // Execute FFmpeg command to concatenate and convert .ts to .mp4
-    // Adding -y to overwrite existing files without asking
-    cmd := exec.Command("ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", listFile, "-c", "copy", fullOutputPath)
+    // Adding -y to overwrite existing files without asking.
+    // The video and the audio track, when there is one, are both kept.
+    // Opus audio in MP4 is still flagged as experimental by some FFmpeg versions.
+    cmd := exec.Command("ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", listFile,
+        "-map", "0:v", "-map", "0:a?", "-c", "copy", "-strict", "experimental", fullOutputPath)
     if err := cmd.Run(); err != nil {
         fmt.Println("ERROR: ", err)
         return err
@@ -64,6 +67,7 @@ func AggregateTSFilesToMP4(tsFiles []string, outputDir, outputFile string) error
 func ServeMp4(c *gin.Context)  {
 	startDateQuery := c.Query("start_date")
 	endDateQuery := c.Query("end_date")
+	cameraQuery := c.Query("camera")
 	// Extract the filepath from the URL
 
 	// Use the base path from the config
@@ -73,6 +77,10 @@ func ServeMp4(c *gin.Context)  {
 	query := models.DB.Model(&recorder.RecordedEvent{})
 
 
+	if cameraQuery != "" {
+		query = query.Where("camera_id = ?", cameraQuery)
+	}
+
 	if startDateQuery != "" || endDateQuery != "" {
 		startDate, err := time.Parse(time.RFC3339, startDateQuery)
 		if err != nil {
@@ -112,6 +120,10 @@ func ServeMp4(c *gin.Context)  {
 			log.Printf("File does not exist: %s\n", absPath)
 		}
     }
+	if len(validPaths) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no recordings found"})
+		return
+	}
 	startTime := recordings[0].StartTime;
 	endTime:= recordings[len(recordings) -1].EndTime;
 
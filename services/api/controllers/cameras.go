@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pedrohba1/SSCS/services/api/models"
+	"github.com/pedrohba1/SSCS/services/camera"
+)
+
+// GET /cameras
+// Gets all the cameras known by the indexer, so clients
+// can use their IDs to filter recordings and recognitions
+func FindCameras(c *gin.Context) {
+	var cameras []camera.Camera
+
+	err := models.DB.Order("id").Find(&cameras).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": cameras})
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pedrohba1/SSCS/services/api/models"
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/core"
+)
+
+// daemonHealthTimeout is how long the API waits for the health of the daemon.
+const daemonHealthTimeout = 5 * time.Second
+
+// HealthReporter is implemented by whatever tracks the
+// health of the SSCS components, such as the daemon core
+type HealthReporter interface {
+	Health() []core.ComponentHealth
+}
+
+// GET /health
+// Gets the state of each component. The overall status is "failed" when any
+// component failed, "degraded" when any is degraded or still starting, and
+// "ok" otherwise. A failed status is answered with 503, so it can be probed
+func Health(reporter HealthReporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reportHealth(c, reporter.Health())
+	}
+}
+
+// GET /health
+// Gets the state of each component of the daemon, read from its own /health,
+// along with the database of the API. The status is computed like the one of
+// the daemon, the daemon being failed when it can't be reached
+func APIHealth(c *gin.Context) {
+	components := []core.ComponentHealth{databaseHealth()}
+	components = append(components, daemonHealth(conf.CachedConfig.API.DaemonUrl)...)
+	reportHealth(c, components)
+}
+
+// reportHealth answers with the health of the given components, and their overall status.
+func reportHealth(c *gin.Context, components []core.ComponentHealth) {
+	status := "ok"
+	for _, component := range components {
+		switch component.State {
+		case core.StateFailed:
+			status = "failed"
+		case core.StateDegraded, core.StateStarting:
+			if status == "ok" {
+				status = "degraded"
+			}
+		}
+	}
+
+	code := http.StatusOK
+	if status == "failed" {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "data": components})
+}
+
+// databaseHealth returns the health of the database the API reads from.
+func databaseHealth() core.ComponentHealth {
+	h := core.ComponentHealth{Name: "api/database", State: core.StateRunning, Since: time.Now()}
+	sqlDB, err := models.DB.DB()
+	if err == nil {
+		err = sqlDB.Ping()
+	}
+	if err != nil {
+		h.State = core.StateFailed
+		h.Error = err.Error()
+	}
+	return h
+}
+
+// daemonHealth returns the health of the components of the daemon, or a
+// single failed component when the daemon can't be reached.
+func daemonHealth(daemonURL string) []core.ComponentHealth {
+	failed := func(err error) []core.ComponentHealth {
+		return []core.ComponentHealth{{Name: "daemon", State: core.StateFailed, Error: err.Error(), Since: time.Now()}}
+	}
+	if daemonURL == "" {
+		return failed(fmt.Errorf("the url of the daemon is not set"))
+	}
+
+	client := http.Client{Timeout: daemonHealthTimeout}
+	res, err := client.Get(strings.TrimRight(daemonURL, "/") + "/health")
+	if err != nil {
+		return failed(err)
+	}
+	defer res.Body.Close()
+
+	// the daemon answers with 503 when a component failed, along with their health
+	var body struct {
+		Data []core.ComponentHealth `json:"data"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return failed(fmt.Errorf("invalid health of the daemon: %w", err))
+	}
+	return body.Data
+}
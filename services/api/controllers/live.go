@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pedrohba1/SSCS/services/live"
+)
+
+// LiveStreamer is implemented by whatever holds the
+// live streams of the cameras, such as the daemon core
+type LiveStreamer interface {
+	LiveHLS(cameraID string) (*live.HLSMuxer, bool)
+	LiveWHEP(cameraID string) (*live.WHEPServer, bool)
+}
+
+// GET /live/:camera/*file
+// Serves the live HLS stream of a camera: its playlist at index.m3u8,
+// and the segments and the parts it lists. The playlist is answered with 404
+// until the first segment is ready, so players should retry. It is held back
+// until it lists the segment, or the part, asked by _HLS_msn and _HLS_part
+func LiveHLS(streamer LiveStreamer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		muxer, ok := streamer.LiveHLS(c.Param("camera"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "camera not found"})
+			return
+		}
+
+		file := strings.TrimPrefix(c.Param("file"), "/")
+		if file == "index.m3u8" {
+			var playlist []byte
+			if c.Query("_HLS_msn") != "" {
+				msn, err := strconv.ParseUint(c.Query("_HLS_msn"), 10, 64)
+				part := -1
+				if err == nil && c.Query("_HLS_part") != "" {
+					part, err = strconv.Atoi(c.Query("_HLS_part"))
+					if err == nil && part < 0 {
+						err = errors.New("_HLS_part can't be negative")
+					}
+				}
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+
+				playlist, ok, err = muxer.BlockingPlaylist(c.Request.Context(), msn, part)
+				if errors.Is(err, live.ErrTooFarAhead) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				if err != nil {
+					c.JSON(http.StatusServiceUnavailable, gin.H{"error": "playlist not updated in time"})
+					return
+				}
+			} else {
+				playlist, ok = muxer.Playlist()
+			}
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "stream not ready"})
+				return
+			}
+			c.Header("Cache-Control", "no-cache")
+			c.Data(http.StatusOK, "application/vnd.apple.mpegurl", playlist)
+			return
+		}
+
+		if strings.HasPrefix(file, "part") {
+			part, ok := muxer.Part(c.Request.Context(), file)
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "part not found"})
+				return
+			}
+			c.Data(http.StatusOK, "video/mp2t", part)
+			return
+		}
+
+		segment, ok := muxer.Segment(file)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "segment not found"})
+			return
+		}
+		c.Data(http.StatusOK, "video/mp2t", segment)
+	}
+}
+
+// POST /whep/:camera
+// Starts watching a camera through WebRTC, following WHEP: the body is the
+// SDP offer of the peer, and the SDP answer is sent back, along with the
+// location of the session, that is deleted to stop watching
+func WHEPOffer(streamer LiveStreamer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		server, ok := streamer.LiveWHEP(c.Param("camera"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "camera not found"})
+			return
+		}
+
+		if c.ContentType() != "application/sdp" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "the offer must be application/sdp"})
+			return
+		}
+		offer, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		id, answer, err := server.NewSession(string(offer))
+		if errors.Is(err, live.ErrStreamUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Header("Location", c.Request.URL.Path+"/"+id)
+		c.Data(http.StatusCreated, "application/sdp", []byte(answer))
+	}
+}
+
+// DELETE /whep/:camera/:session
+// Stops a WebRTC session
+func WHEPClose(streamer LiveStreamer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		server, ok := streamer.LiveWHEP(c.Param("camera"))
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "camera not found"})
+			return
+		}
+
+		err := server.CloseSession(c.Param("session"))
+		if errors.Is(err, live.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+}
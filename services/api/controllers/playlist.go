@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pedrohba1/SSCS/services/api/models"
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/recorder"
+)
+
+// maxSegmentGap is the longest time between two segments for them to be
+// considered contiguous. Longer gaps are marked as discontinuities.
+const maxSegmentGap = time.Second
+
+// GET /cameras/:camera/playlist.m3u8
+// Builds a HLS VOD playlist with the recordings of a camera
+// between the start and end query parameters, in RFC3339.
+// The recordings overlapping the range are listed whole.
+// By default, the keyframes-only recordings of the hybrid mode are left
+// out, but the kind parameter can select a single kind of recording.
+func ServePlaylist(c *gin.Context) {
+	cameraID := c.Param("camera")
+	kindQuery := c.Query("kind")
+
+	start, err := time.Parse(time.RFC3339, c.Query("start"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start format. Use RFC3339."})
+		return
+	}
+	end, err := time.Parse(time.RFC3339, c.Query("end"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end format. Use RFC3339."})
+		return
+	}
+
+	query := models.DB.Model(&recorder.RecordedEvent{}).
+		Where("camera_id = ?", cameraID).
+		Where("start_time < ? AND end_time > ?", end, start)
+
+	if kindQuery != "" {
+		query = query.Where("kind = ?", kindQuery)
+	} else {
+		query = query.Where("kind IS NULL OR kind <> ?", recorder.RecordingKeyframes)
+	}
+
+	var recordings []recorder.RecordedEvent
+	err = query.Order("start_time").Find(&recordings).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	playlist, err := buildPlaylist(recordings)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if playlist == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no recordings found"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(playlist))
+}
+
+// buildPlaylist writes the HLS playlist of the given recordings, sorted by
+// start time. Recordings that can't be served are skipped. It returns an
+// empty playlist when there is nothing to play.
+func buildPlaylist(recordings []recorder.RecordedEvent) (string, error) {
+	var body strings.Builder
+	var prev *recorder.RecordedEvent
+	targetDuration := 1
+	count := 0
+
+	for i := range recordings {
+		rec := &recordings[i]
+
+		url, ok := fileURL(rec.Path)
+		if !ok {
+			continue
+		}
+
+		info, err := os.Stat(rec.Path)
+		if err != nil {
+			continue
+		}
+
+		duration := rec.EndTime.Sub(rec.StartTime).Seconds()
+		if duration <= 0 {
+			continue
+		}
+
+		// every fMP4 recording starts with its own initialization block
+		var initSize int64
+		if filepath.Ext(rec.Path) == ".mp4" {
+			initSize, err = fmp4InitSize(rec.Path)
+			if err != nil {
+				continue
+			}
+		}
+
+		if d := int(math.Ceil(duration)); d > targetDuration {
+			targetDuration = d
+		}
+
+		// timestamps restart and codecs can change between recordings that
+		// are not contiguous, as they come from different recording sessions
+		if prev != nil && (rec.StartTime.Sub(prev.EndTime) > maxSegmentGap ||
+			filepath.Ext(rec.Path) != filepath.Ext(prev.Path)) {
+			body.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		body.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + rec.StartTime.Format("2006-01-02T15:04:05.000Z07:00") + "\n")
+
+		if filepath.Ext(rec.Path) == ".mp4" {
+			fmt.Fprintf(&body, "#EXT-X-MAP:URI=\"%s\",BYTERANGE=\"%d@0\"\n", url, initSize)
+			fmt.Fprintf(&body, "#EXT-X-BYTERANGE:%d@%d\n", info.Size()-initSize, initSize)
+		}
+
+		fmt.Fprintf(&body, "#EXTINF:%.3f,\n%s\n", duration, url)
+		prev = rec
+		count++
+	}
+
+	if count == 0 {
+		return "", nil
+	}
+
+	return "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", targetDuration) +
+		"#EXT-X-MEDIA-SEQUENCE:0\n" +
+		body.String() +
+		"#EXT-X-ENDLIST\n", nil
+}
+
+// fileURL returns the URL that ServeFile serves a recording at.
+func fileURL(path string) (string, bool) {
+	baseIndex := strings.Index(path, "recordings")
+	if baseIndex == -1 {
+		return "", false
+	}
+	return conf.CachedConfig.API.BaseUrl + "/file/" + path[baseIndex:], true
+}
+
+// fmp4InitSize returns the size of the initialization block of a fMP4
+// file, that is, of the boxes that precede its first fragment.
+func fmp4InitSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 8)
+
+	for {
+		_, err := f.ReadAt(header, offset)
+		if err != nil {
+			if err == io.EOF {
+				return 0, fmt.Errorf("%s has no fragments", path)
+			}
+			return 0, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		if string(header[4:]) == "moof" {
+			return offset, nil
+		}
+		if size < 8 {
+			return 0, fmt.Errorf("%s has an invalid box", path)
+		}
+		offset += size
+	}
+}
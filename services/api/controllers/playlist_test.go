@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/recorder"
+)
+
+// mp4Box returns a box of the given type, with size bytes of payload.
+func mp4Box(typ string, size int) []byte {
+	b := make([]byte, 8+size)
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], typ)
+	return b
+}
+
+// writeFile writes the boxes into a file of dir, returning its path.
+func writeFile(t *testing.T, dir string, name string, boxes ...[]byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var content []byte
+	for _, b := range boxes {
+		content = append(content, b...)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFmp4InitSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		boxes   [][]byte
+		want    int64
+		wantErr bool
+	}{
+		{"fragmented", [][]byte{mp4Box("ftyp", 8), mp4Box("moov", 16), mp4Box("moof", 8), mp4Box("mdat", 32)}, 40, false},
+		{"fragment first", [][]byte{mp4Box("moof", 8), mp4Box("mdat", 32)}, 0, false},
+		{"progressive", [][]byte{mp4Box("ftyp", 8), mp4Box("moov", 16), mp4Box("mdat", 32)}, 0, true},
+		{"invalid box", [][]byte{mp4Box("ftyp", 8), {0, 0, 0, 4, 'f', 'r', 'e', 'e'}}, 0, true},
+		{"empty", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, t.TempDir(), "rec.mp4", tt.boxes...)
+			got, err := fmp4InitSize(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("fmp4InitSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("fmp4InitSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := fmp4InitSize(filepath.Join(t.TempDir(), "missing.mp4")); err == nil {
+		t.Error("fmp4InitSize() of a missing file didn't fail")
+	}
+}
+
+func TestBuildPlaylist(t *testing.T) {
+	conf.CachedConfig = &conf.Config{API: conf.APIConfig{BaseUrl: "http://api"}}
+	defer func() { conf.CachedConfig = nil }()
+
+	dir := t.TempDir()
+	fragmented := [][]byte{mp4Box("ftyp", 8), mp4Box("moov", 16), mp4Box("moof", 8), mp4Box("mdat", 76)}
+	a := writeFile(t, dir, "recordings/cam/a.mp4", fragmented...)
+	b := writeFile(t, dir, "recordings/cam/b.mp4", fragmented...)
+	ts := writeFile(t, dir, "recordings/cam/c.ts", mp4Box("data", 92))
+	progressive := writeFile(t, dir, "recordings/cam/d.mp4", mp4Box("ftyp", 8), mp4Box("mdat", 92))
+	outside := writeFile(t, dir, "elsewhere/e.mp4", fragmented...)
+	missing := filepath.Join(dir, "recordings/cam/missing.mp4")
+
+	start := time.Date(2024, 4, 18, 18, 0, 0, 0, time.UTC)
+	rec := func(path string, from time.Duration, to time.Duration) recorder.RecordedEvent {
+		return recorder.RecordedEvent{Path: path, StartTime: start.Add(from), EndTime: start.Add(to)}
+	}
+	header := func(target int) string {
+		return "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-INDEPENDENT-SEGMENTS\n" +
+			fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", target) + "#EXT-X-MEDIA-SEQUENCE:0\n"
+	}
+
+	tests := []struct {
+		name       string
+		recordings []recorder.RecordedEvent
+		want       string
+	}{
+		{"nothing", nil, ""},
+		{
+			name:       "contiguous",
+			recordings: []recorder.RecordedEvent{rec(a, 0, 4*time.Second), rec(b, 4*time.Second, 8500*time.Millisecond)},
+			want: header(5) +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:00.000Z\n" +
+				"#EXT-X-MAP:URI=\"http://api/file/recordings/cam/a.mp4\",BYTERANGE=\"40@0\"\n" +
+				"#EXT-X-BYTERANGE:100@40\n" +
+				"#EXTINF:4.000,\nhttp://api/file/recordings/cam/a.mp4\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:04.000Z\n" +
+				"#EXT-X-MAP:URI=\"http://api/file/recordings/cam/b.mp4\",BYTERANGE=\"40@0\"\n" +
+				"#EXT-X-BYTERANGE:100@40\n" +
+				"#EXTINF:4.500,\nhttp://api/file/recordings/cam/b.mp4\n" +
+				"#EXT-X-ENDLIST\n",
+		},
+		{
+			name:       "gap",
+			recordings: []recorder.RecordedEvent{rec(a, 0, 4*time.Second), rec(b, 6*time.Second, 10*time.Second)},
+			want: header(4) +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:00.000Z\n" +
+				"#EXT-X-MAP:URI=\"http://api/file/recordings/cam/a.mp4\",BYTERANGE=\"40@0\"\n" +
+				"#EXT-X-BYTERANGE:100@40\n" +
+				"#EXTINF:4.000,\nhttp://api/file/recordings/cam/a.mp4\n" +
+				"#EXT-X-DISCONTINUITY\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:06.000Z\n" +
+				"#EXT-X-MAP:URI=\"http://api/file/recordings/cam/b.mp4\",BYTERANGE=\"40@0\"\n" +
+				"#EXT-X-BYTERANGE:100@40\n" +
+				"#EXTINF:4.000,\nhttp://api/file/recordings/cam/b.mp4\n" +
+				"#EXT-X-ENDLIST\n",
+		},
+		{
+			name:       "format change",
+			recordings: []recorder.RecordedEvent{rec(a, 0, 4*time.Second), rec(ts, 4*time.Second, 6*time.Second)},
+			want: header(4) +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:00.000Z\n" +
+				"#EXT-X-MAP:URI=\"http://api/file/recordings/cam/a.mp4\",BYTERANGE=\"40@0\"\n" +
+				"#EXT-X-BYTERANGE:100@40\n" +
+				"#EXTINF:4.000,\nhttp://api/file/recordings/cam/a.mp4\n" +
+				"#EXT-X-DISCONTINUITY\n" +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:04.000Z\n" +
+				"#EXTINF:2.000,\nhttp://api/file/recordings/cam/c.ts\n" +
+				"#EXT-X-ENDLIST\n",
+		},
+		{
+			name: "unplayable recordings are skipped",
+			recordings: []recorder.RecordedEvent{
+				rec(outside, 0, time.Second),
+				rec(missing, time.Second, 2*time.Second),
+				rec(progressive, 2*time.Second, 3*time.Second),
+				rec(a, 3*time.Second, 3*time.Second),
+				rec(ts, 3*time.Second, 5*time.Second),
+			},
+			want: header(2) +
+				"#EXT-X-PROGRAM-DATE-TIME:2024-04-18T18:00:03.000Z\n" +
+				"#EXTINF:2.000,\nhttp://api/file/recordings/cam/c.ts\n" +
+				"#EXT-X-ENDLIST\n",
+		},
+		{
+			name:       "only unplayable recordings",
+			recordings: []recorder.RecordedEvent{rec(outside, 0, time.Second), rec(missing, time.Second, 2*time.Second)},
+			want:       "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildPlaylist(tt.recordings)
+			if err != nil {
+				t.Fatalf("buildPlaylist() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("buildPlaylist() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package controllers
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,11 +14,17 @@ import (
 )
 
 // GET /recognitions
-// Get all recognition events. It is capable to filter by
-// dates in unix timestamp, given in as query params
+// Get all recognition events, along with their detections. It is capable to filter by
+// dates in unix timestamp and by camera ID, given in as query params, and by the
+// label of the tracked objects, with the minimum count of the objects of their label
+// that were detected in the frame the event started at, such as label=person&min_count=4
+// for the events that started with more than 3 people in view
 func FindRecogs(c *gin.Context) {
 	startDateQuery := c.Query("start_date")
 	endDateQuery := c.Query("end_date")
+	cameraQuery := c.Query("camera")
+	labelQuery := c.Query("label")
+	minCountQuery := c.Query("min_count")
 
 	var recognitions []recognizer.RecognizedEvent
 	var err error
@@ -25,6 +32,28 @@ func FindRecogs(c *gin.Context) {
 	// Create a base query to add conditions dynamically
 	query := models.DB.Model(&recognizer.RecognizedEvent{})
 
+	if cameraQuery != "" {
+		query = query.Where("camera_id = ?", cameraQuery)
+	}
+
+	if labelQuery != "" || minCountQuery != "" {
+		minCount := 1
+		if minCountQuery != "" {
+			minCount, err = strconv.Atoi(minCountQuery)
+			if err != nil || minCount < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min count. Use a positive integer."})
+				return
+			}
+		}
+
+		// each event holds the count by label of the objects detected in the frame it
+		// started at, so the events are filtered by the count of their own label
+		if labelQuery != "" {
+			query = query.Where("recognized_events.label = ?", labelQuery)
+		}
+		query = query.Where("(recognized_events.in_view ->> recognized_events.label)::int >= ?", minCount)
+	}
+
 	// Check if both start and end dates are provided
 	if startDateQuery != "" || endDateQuery != "" {
 		startDate, err := time.Parse(time.RFC3339, startDateQuery)
@@ -42,7 +71,7 @@ func FindRecogs(c *gin.Context) {
 		query = query.Where("created_at BETWEEN ? AND ?", startDate, endDate)
 	}
 
-	err = query.Find(&recognitions).Error
+	err = query.Preload("Detections").Find(&recognitions).Error
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
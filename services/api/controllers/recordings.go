@@ -14,16 +14,28 @@ import (
 
 // GET /recordings
 // Gets all recordings files between two dates
-// dates have to be passed in Unix timestamp
+// dates have to be passed in Unix timestamp.
+// It can also be filtered by camera ID and by kind of
+// recording: continuous, keyframes or event
 func FindRecordings(c *gin.Context) {
 	startDateQuery := c.Query("start_date")
 	endDateQuery := c.Query("end_date")
+	cameraQuery := c.Query("camera")
+	kindQuery := c.Query("kind")
 
 	var recordings []recorder.RecordedEvent
 	
 	query := models.DB.Model(&recorder.RecordedEvent{})
 
 	
+	if cameraQuery != "" {
+		query = query.Where("camera_id = ?", cameraQuery)
+	}
+
+	if kindQuery != "" {
+		query = query.Where("kind = ?", kindQuery)
+	}
+
 	if startDateQuery != "" || endDateQuery != "" {
 		startDate, err := time.Parse(time.RFC3339, startDateQuery)
 		if err != nil {
@@ -3,6 +3,7 @@
 package models
 
 import (
+	"github.com/pedrohba1/SSCS/services/camera"
 	"github.com/pedrohba1/SSCS/services/conf"
 	"github.com/pedrohba1/SSCS/services/recognizer"
 	"github.com/pedrohba1/SSCS/services/recorder"
@@ -25,8 +26,11 @@ func ConnectDatabase() {
 	if err != nil {
 		panic("error connecting to database")
 	}
+	db.AutoMigrate(&camera.Camera{})
 	db.AutoMigrate(&recorder.RecordedEvent{})
 	db.AutoMigrate(&recognizer.RecognizedEvent{})
+	db.AutoMigrate(&recognizer.Detection{})
 	db.AutoMigrate(&storer.CleanedEvent{})
+	db.AutoMigrate(&recorder.FeedEvent{})
 	DB = db
 }
@@ -0,0 +1,37 @@
+// Package camera defines the identity of the cameras recorded by SSCS,
+// as stored by the indexer and referenced by recordings and recognitions.
+package camera
+
+import (
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+// Camera is the stored identity of a configured camera. Credentials
+// and stream URLs are deliberately left out of the database.
+type Camera struct {
+	ID        string `gorm:"primaryKey;type:text"`
+	Name      string `gorm:"type:text"`
+	Timezone  string `gorm:"type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FromConfig builds the Camera that identifies a configured camera.
+func FromConfig(cfg conf.CameraConfig) Camera {
+	return Camera{
+		ID:       cfg.ID,
+		Name:     cfg.Name,
+		Timezone: cfg.Timezone,
+	}
+}
+
+// FromConfigList builds the Cameras of all the given configured cameras.
+func FromConfigList(cfgs []conf.CameraConfig) []Camera {
+	cameras := make([]Camera, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		cameras = append(cameras, FromConfig(cfg))
+	}
+	return cameras
+}
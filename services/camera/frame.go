@@ -0,0 +1,55 @@
+package camera
+
+import (
+	"image"
+	"time"
+)
+
+// Frame is a decoded frame of a camera, as sent by the recorder to the recognizers,
+// along with when it was captured and where it can be found in the recordings.
+//
+// The image of a frame can belong to a FramePool: every consumer of the frame must
+// call Release once it doesn't read the image anymore, and a consumer that passes
+// the frame to several others must call Retain for each additional one.
+type Frame struct {
+	Image    image.Image
+	Pool     *FramePool // pool the image belongs to, nil when it doesn't need to be released
+	CameraID string
+	PTS      time.Duration // presentation timestamp, relative to the start of the feed
+	Time     time.Time     // wall-clock time the frame was captured at
+	Keyframe bool          // tells if the frame was decoded from a keyframe
+	Segment  SegmentRef
+
+	// Main is the last keyframe of the main stream, when the frame was decoded from the
+	// substream of the camera. Thumbnails are taken from it, at full resolution.
+	// It is retained and released along with the frame.
+	Main *Frame
+}
+
+// SegmentRef locates a frame in a recording.
+type SegmentRef struct {
+	Path   string        // path of the segment, empty when the frame is not recorded
+	Offset time.Duration // offset of the frame from the start of the segment
+}
+
+// Retain adds a reference to the image of the frame, that must be
+// released as well, before handing the frame to another consumer.
+func (f Frame) Retain() {
+	if img, ok := f.Image.(*image.RGBA); ok && f.Pool != nil {
+		f.Pool.retain(img)
+	}
+	if f.Main != nil {
+		f.Main.Retain()
+	}
+}
+
+// Release gives the image of the frame back to its pool, once every reference to it is
+// released. The image must not be read after. It does nothing if the image has no pool.
+func (f Frame) Release() {
+	if img, ok := f.Image.(*image.RGBA); ok && f.Pool != nil {
+		f.Pool.release(img)
+	}
+	if f.Main != nil {
+		f.Main.Release()
+	}
+}
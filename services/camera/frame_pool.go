@@ -0,0 +1,90 @@
+package camera
+
+import (
+	"image"
+	"sync"
+)
+
+// FramePool recycles the RGBA images that frames are decoded into. Decoders take
+// an image from the pool for each frame, that owns it until it is released by every
+// consumer, so that a frame is never overwritten while it is being read, and
+// decoding doesn't allocate a new image for every frame.
+//
+// A pool holds up to max images, in use or released, so that consumers that fall
+// behind can't make it allocate without bound. Decoders drop the frames decoded
+// while every image is in use.
+type FramePool struct {
+	mu sync.Mutex
+
+	// free holds the released images
+	free []*image.RGBA
+	max  int
+
+	// refs counts the references to the images in use
+	refs map[*image.RGBA]int
+}
+
+// NewFramePool allocates a FramePool of up to max images.
+func NewFramePool(max int) *FramePool {
+	return &FramePool{
+		max:  max,
+		refs: make(map[*image.RGBA]int),
+	}
+}
+
+// Get returns an image of the given size, that is recycled when possible.
+// Its content is undefined. The frame it is sent into must be released
+// once it is not used anymore. It returns nil when every image is in use.
+func (p *FramePool) Get(width int, height int) *image.RGBA {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var img *image.RGBA
+	for i := len(p.free) - 1; i >= 0; i-- {
+		if b := p.free[i].Bounds(); b.Dx() == width && b.Dy() == height {
+			img = p.free[i]
+			p.free = append(p.free[:i], p.free[i+1:]...)
+			break
+		}
+	}
+	if img == nil {
+		if len(p.refs) >= p.max {
+			return nil
+		}
+
+		// a released image of another size makes room for the new one
+		if len(p.refs)+len(p.free) >= p.max {
+			p.free = p.free[1:]
+		}
+		img = image.NewRGBA(image.Rect(0, 0, width, height))
+	}
+
+	p.refs[img] = 1
+	return img
+}
+
+func (p *FramePool) retain(img *image.RGBA) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.refs[img]; ok {
+		p.refs[img]++
+	}
+}
+
+func (p *FramePool) release(img *image.RGBA) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	refs, ok := p.refs[img]
+	if !ok {
+		return
+	}
+	if refs > 1 {
+		p.refs[img] = refs - 1
+		return
+	}
+
+	delete(p.refs, img)
+	p.free = append(p.free, img)
+}
@@ -0,0 +1,160 @@
+package camera
+
+import (
+	"image"
+	"testing"
+)
+
+// poolOp is an operation on a FramePool. A "get" names the image it returns,
+// and tells which previous image it must recycle, or none when it is new,
+// unless the pool is full.
+type poolOp struct {
+	op      string // "get", "retain" or "release"
+	img     string
+	size    int
+	recycle string
+	full    bool
+}
+
+func TestFramePool(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		ops  []poolOp
+	}{
+		{
+			name: "released image is recycled",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "release", img: "a"},
+				{op: "get", img: "b", size: 4, recycle: "a"},
+			},
+		},
+		{
+			name: "image is kept until every reference is released",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "retain", img: "a"},
+				{op: "release", img: "a"},
+				{op: "get", img: "b", size: 4},
+				{op: "release", img: "a"},
+				{op: "get", img: "c", size: 4, recycle: "a"},
+			},
+		},
+		{
+			name: "image of another size is not recycled",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "release", img: "a"},
+				{op: "get", img: "b", size: 8},
+				{op: "get", img: "c", size: 4, recycle: "a"},
+			},
+		},
+		{
+			name: "holds up to max images",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "get", img: "b", size: 4},
+				{op: "get", img: "c", size: 4, full: true},
+				{op: "retain", img: "a"},
+				{op: "release", img: "a"},
+				{op: "get", img: "d", size: 4, full: true},
+				{op: "release", img: "a"},
+				{op: "get", img: "e", size: 4, recycle: "a"},
+			},
+		},
+		{
+			name: "released image of another size makes room",
+			max:  1,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "release", img: "a"},
+				{op: "get", img: "b", size: 8},
+				{op: "release", img: "b"},
+				{op: "get", img: "c", size: 4},
+				{op: "get", img: "d", size: 4, full: true},
+			},
+		},
+		{
+			name: "released twice is recycled once",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "release", img: "a"},
+				{op: "release", img: "a"},
+				{op: "get", img: "b", size: 4, recycle: "a"},
+				{op: "get", img: "c", size: 4},
+			},
+		},
+		{
+			name: "retain of a released image is ignored",
+			max:  2,
+			ops: []poolOp{
+				{op: "get", img: "a", size: 4},
+				{op: "release", img: "a"},
+				{op: "retain", img: "a"},
+				{op: "get", img: "b", size: 4, recycle: "a"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewFramePool(tt.max)
+			images := make(map[string]*image.RGBA)
+			for i, op := range tt.ops {
+				switch op.op {
+				case "get":
+					img := p.Get(op.size, op.size)
+					if (img == nil) != op.full {
+						t.Fatalf("op %d: get %s returned %v, want full %v", i, op.img, img, op.full)
+					}
+					if img == nil {
+						continue
+					}
+					if b := img.Bounds(); b.Dx() != op.size || b.Dy() != op.size {
+						t.Fatalf("op %d: got a %v image, want %dx%d", i, b, op.size, op.size)
+					}
+					for name, prev := range images {
+						if (prev == img) != (name == op.recycle) {
+							t.Fatalf("op %d: get %s recycled %s: %v, want %q", i, op.img, name, prev == img, op.recycle)
+						}
+					}
+					images[op.img] = img
+				case "retain":
+					p.retain(images[op.img])
+				case "release":
+					p.release(images[op.img])
+				}
+			}
+		})
+	}
+}
+
+func TestFrameReleasesMain(t *testing.T) {
+	p := NewFramePool(4)
+	main := &Frame{Image: p.Get(8, 8), Pool: p}
+	frame := Frame{Image: p.Get(4, 4), Pool: p, Main: main}
+
+	// the frame is handed to two consumers
+	frame.Retain()
+	frame.Release()
+	if img := p.Get(8, 8); img == main.Image {
+		t.Fatal("main stream image recycled while the frame is still used")
+	}
+
+	frame.Release()
+	if img := p.Get(8, 8); img != main.Image {
+		t.Error("main stream image not recycled once the frame is released")
+	}
+	if img := p.Get(4, 4); img != frame.Image {
+		t.Error("image not recycled once the frame is released")
+	}
+
+	// frames without a pool are left alone
+	Frame{Image: image.NewRGBA(image.Rect(0, 0, 4, 4))}.Release()
+}
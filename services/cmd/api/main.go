@@ -18,6 +18,9 @@ func main() {
 
 	models.ConnectDatabase() // new
 
+	r.GET("/health", controllers.APIHealth)
+	r.GET("/cameras", controllers.FindCameras)
+	r.GET("/cameras/:camera/playlist.m3u8", controllers.ServePlaylist)
 	r.GET("/recognitions", controllers.FindRecogs)
 	r.GET("/recordings", controllers.FindRecordings)
 	r.GET("/file/*filepath", controllers.ServeFile)
@@ -4,11 +4,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	"github.com/pedrohba1/SSCS/services/api/controllers"
 	"github.com/pedrohba1/SSCS/services/core"
 	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
 
@@ -22,7 +28,9 @@ const (
 	name        = "sscs"
 	description = "Self-sovereign camera system"
 
-	// port which daemon should be listen
+	// port which daemon should be listen. It serves
+	// the state of the daemon components at /health,
+	// and the live streams of the cameras at /live
 	port = ":9977"
 )
 
@@ -73,14 +81,19 @@ func (service *Service) Manage() (string, error) {
 
 	// Initialize the Core application
 	args := []string{""}
-	service.core = core.NewBasic(args)
+	service.core = core.NewComposite(args)
 	service.core.Logger.Info("I'm completely operational, and all my circuits are functioning perfectly")
+
+	server := service.serve()
 	// loop work cycle with accept connections or interrupt
 	// by system signal
 	for {
 		select {
 		case killSignal := <-interrupt:
 			logger.Info("Got signal:", killSignal)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			server.Shutdown(ctx)
+			cancel()
 			service.core.Close()
 			if killSignal == os.Interrupt {
 				return "Daemon was interrupted by system signal", nil
@@ -91,6 +104,25 @@ func (service *Service) Manage() (string, error) {
 
 }
 
+// serve starts the HTTP server of the daemon, which reports
+// the health of the core components and serves the live streams
+func (service *Service) serve() *http.Server {
+	router := gin.Default()
+	router.GET("/health", controllers.Health(service.core))
+	router.GET("/live/:camera/*file", controllers.LiveHLS(service.core))
+	router.POST("/whep/:camera", controllers.WHEPOffer(service.core))
+	router.DELETE("/whep/:camera/:session", controllers.WHEPClose(service.core))
+
+	server := &http.Server{Addr: port, Handler: router}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("HTTP server stopped: ", err)
+		}
+	}()
+	return server
+}
+
 func init() {
 	logger = BaseLogger.BaseLogger.WithField("package", "main")
 }
@@ -0,0 +1,181 @@
+// This command checks the WebRTC live view of a camera without a browser.
+// It connects to the WHEP endpoint of the daemon, receives the video for a while,
+// and reports what it got. It exits with an error when no keyframe was received.
+//
+//	go run ./cmd/whep-client -url http://localhost:9977/whep/mystream -duration 10s
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/pion/webrtc/v4"
+)
+
+// stats is what was received from the daemon.
+type stats struct {
+	mu        sync.Mutex
+	codec     string
+	packets   int
+	bytes     int
+	frames    int
+	keyframes int
+}
+
+func main() {
+	endpoint := flag.String("url", "http://localhost:9977/whep/mystream", "WHEP endpoint of the camera")
+	duration := flag.Duration("duration", 10*time.Second, "how long to receive the video")
+	flag.Parse()
+
+	err := run(*endpoint, *duration)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(endpoint string, duration time.Duration) error {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	_, err = pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		return err
+	}
+
+	var st stats
+	pc.OnTrack(func(track *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		st.mu.Lock()
+		st.codec = track.Codec().MimeType
+		st.mu.Unlock()
+
+		dec := &rtph264.Decoder{}
+		err := dec.Init()
+		if err != nil {
+			return
+		}
+
+		for {
+			pkt, _, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+
+			st.mu.Lock()
+			st.packets++
+			st.bytes += len(pkt.Payload)
+			au, err := dec.Decode(pkt)
+			if err == nil {
+				st.frames++
+				if h264.IDRPresent(au) {
+					st.keyframes++
+				}
+			}
+			st.mu.Unlock()
+		}
+	})
+
+	connected := make(chan struct{})
+	var connectedOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		fmt.Println("connection:", state)
+		if state == webrtc.PeerConnectionStateConnected {
+			connectedOnce.Do(func() { close(connected) })
+		}
+	})
+
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	err = pc.SetLocalDescription(offer)
+	if err != nil {
+		return err
+	}
+	<-gatherComplete
+
+	answer, session, err := postOffer(endpoint, pc.LocalDescription().SDP)
+	if err != nil {
+		return err
+	}
+	defer deleteSession(session)
+
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		return fmt.Errorf("the connection wasn't established")
+	}
+
+	time.Sleep(duration)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	fmt.Printf("codec: %s\npackets: %d (%d bytes)\nframes: %d\nkeyframes: %d\n",
+		st.codec, st.packets, st.bytes, st.frames, st.keyframes)
+
+	if st.keyframes == 0 {
+		return fmt.Errorf("no keyframe was received")
+	}
+	return nil
+}
+
+// postOffer sends the SDP offer to the endpoint, and returns
+// the SDP answer and the URL of the session.
+func postOffer(endpoint string, offer string) (string, string, error) {
+	res, err := http.Post(endpoint, "application/sdp", bytes.NewBufferString(offer))
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return "", "", fmt.Errorf("bad status code: %d: %s", res.StatusCode, body)
+	}
+
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", err
+	}
+	location, err := base.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(body), location.String(), nil
+}
+
+func deleteSession(session string) {
+	req, err := http.NewRequest(http.MethodDelete, session, nil)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+}
@@ -4,8 +4,13 @@
 package conf
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -20,13 +25,44 @@ type Config struct {
 	Recognizer RecognizerConfig `yaml:"recognizer"`
 	Storer     StorerConfig     `yaml:"storer"`
 	API  APIConfig  `yaml:"api"`
+	Cameras    []CameraConfig   `yaml:"cameras"`
+	Supervisor SupervisorConfig `yaml:"supervisor"`
+	Live       LiveConfig       `yaml:"live"`
 }
 
 // RecorderConfig contains configuration necessary for setting up the recording component,
 // including RTSP feed details and the directory for storing recordings.
 type RecorderConfig struct {
-	RTSP          RTSPConfig `yaml:"rtsp"`
-	RecordingsDir string     `yaml:"recordingsDir"`
+	RTSP          RTSPConfig      `yaml:"rtsp"`
+	RecordingsDir string          `yaml:"recordingsDir"`
+	Reconnect     ReconnectConfig `yaml:"reconnect"`
+	Segments      SegmentConfig   `yaml:"segments"`
+}
+
+// SegmentConfig defines how recordings are split into segments. A new segment is
+// started at the first keyframe after Duration, or after the segment reaches MaxSize.
+// FileName is the template of the segment names, without extension, where:
+//   - %camera is the camera ID
+//   - %kind is the kind of recording: continuous, keyframes or event
+//   - %Y, %m, %d, %H, %M and %S are the year, month, day, hour, minute and second the segment starts at
+//   - %f is the microsecond the segment starts at
+//
+// It can contain slashes to store the segments into subdirectories.
+type SegmentConfig struct {
+	Duration int    `yaml:"duration"` // in seconds
+	MaxSize  int    `yaml:"maxSize"`  // in bytes, zero means no limit
+	FileName string `yaml:"fileName"`
+}
+
+// ReconnectConfig defines how the recorder retries a feed after it drops.
+// The delay between attempts starts at InitialDelay and is multiplied by
+// Multiplier after each failed attempt, up to MaxDelay. Jitter randomizes
+// each delay by up to that fraction of it.
+type ReconnectConfig struct {
+	InitialDelay int     `yaml:"initialDelay"` // in seconds
+	MaxDelay     int     `yaml:"maxDelay"`     // in seconds
+	Multiplier   float64 `yaml:"multiplier"`
+	Jitter       float64 `yaml:"jitter"`
 }
 
 // IndexerConfig specifies the database connection URL for the indexing component
@@ -42,6 +78,44 @@ type RecognizerConfig struct {
 	ThumbsDir    string `yaml:"thumbsDir"`
 	EventName string `yaml:"eventName"`
 	FrameLabel string `yaml:"frameLabel"`
+	Detectors  []DetectorConfig `yaml:"detectors"`
+}
+
+// Detector types.
+const (
+	DetectorHaar   = "haar"
+	DetectorMotion = "motion"
+	DetectorDNN    = "dnn"
+)
+
+// DetectorConfig defines a detector that cameras can run, by its name. Model is the
+// path of the model it loads, if any, such as the Haar cascade file, and Label is
+// drawn on its detections and names its events. Params holds the settings
+// specific to its type, such as the minimum area of the motion detector ("minArea").
+//
+// DNN detectors also load the configuration of their model, for Caffe and TensorFlow
+// models, and Labels, a file with the name of a class per line. They only report
+// the Classes listed, or all of them when none is, whose confidence reaches
+// their threshold in Thresholds, or the "confidence" parameter otherwise.
+type DetectorConfig struct {
+	Name   string             `yaml:"name"`
+	Type   string             `yaml:"type"`
+	Model  string             `yaml:"model"`
+	Label  string             `yaml:"label"`
+	Params map[string]float64 `yaml:"params"`
+
+	Config     string             `yaml:"config"`
+	Labels     string             `yaml:"labels"`
+	Classes    []string           `yaml:"classes"`
+	Thresholds map[string]float64 `yaml:"thresholds"`
+}
+
+// Param returns a parameter of the detector, or def when it is not set.
+func (d DetectorConfig) Param(name string, def float64) float64 {
+	if v, ok := d.Params[name]; ok {
+		return v
+	}
+	return def
 }
 
 // StorerConfig defines the configuration for the storage manager, which handles
@@ -54,16 +128,469 @@ type StorerConfig struct {
 }
 
 // RTSPConfig holds the configuration for RTSP feeds, which are used by the recorder
-// to capture video streams.
+// to capture video streams. Feeds are only used when no cameras are configured.
 type RTSPConfig struct {
 	Feeds []string `yaml:"feeds"`
 }
 
+// CameraConfig identifies a single camera and holds the settings that apply only to it.
+// The ID is what recordings and recognitions reference, so it should not change once
+// the camera has been recorded. Credentials are kept in Username and Password, out of
+// the URL, so that the URL can be logged. The substream has credentials of its own,
+// SubstreamUsername and SubstreamPassword, falling back to the camera ones when unset.
+type CameraConfig struct {
+	ID                string           `yaml:"id"`
+	Name              string           `yaml:"name"`
+	URL               string           `yaml:"url"`
+	Substream         string           `yaml:"substream"` // RTSP URL of the low resolution stream analyzed instead of URL
+	Username          string           `yaml:"username"`
+	Password          string           `yaml:"password"`
+	SubstreamUsername string           `yaml:"substreamUsername"`
+	SubstreamPassword string           `yaml:"substreamPassword"`
+	RecordingsDir     string           `yaml:"recordingsDir"`
+	Detectors         []string         `yaml:"detectors"`
+	Retention         RetentionConfig  `yaml:"retention"`
+	Timezone          string           `yaml:"timezone"`
+	Recording         RecordingConfig  `yaml:"recording"`
+	Replay            ReplayConfig     `yaml:"replay"`
+	RTSP              RTSPClientConfig `yaml:"rtsp"`
+	Analytics         AnalyticsConfig  `yaml:"analytics"`
+}
+
+// AnalyticsConfig defines which frames of a camera are decoded and sent to the recognizers,
+// and at which size, so that analyzing a high resolution camera doesn't use all the CPU.
+// The recordings always keep the full stream. Zero values disable the limits.
+// KeyframesOnly decodes only the keyframes, which is the cheapest, as the other
+// frames are not decoded at all.
+type AnalyticsConfig struct {
+	FPS           float64 `yaml:"fps"`           // maximum frames per second sent to the recognizers
+	MaxWidth      int     `yaml:"maxWidth"`      // frames are scaled down to fit into MaxWidth and MaxHeight
+	MaxHeight     int     `yaml:"maxHeight"`
+	KeyframesOnly bool    `yaml:"keyframesOnly"`
+}
+
+// RTSP transports a camera can be read with.
+const (
+	TransportUDP       = "udp"
+	TransportTCP       = "tcp"
+	TransportMulticast = "multicast"
+)
+
+// RTSPClientConfig defines how the recorder connects to a RTSP or RTSPS (rtsps://) camera.
+// Transport is "udp", "tcp" or "multicast". When it is not set, UDP is tried first, then TCP.
+type RTSPClientConfig struct {
+	Transport    string    `yaml:"transport"`
+	ReadTimeout  int       `yaml:"readTimeout"`  // in seconds, defaults to 10
+	WriteTimeout int       `yaml:"writeTimeout"` // in seconds, defaults to 10
+	TLS          TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig defines how the certificate of a RTSPS camera is verified. By default it must
+// be signed by a trusted authority, or by the one in CAFile. Fingerprint pins the certificate
+// instead, by its SHA-256 fingerprint in hex, which suits the self-signed certificates of
+// cameras. InsecureSkipVerify disables any verification.
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile"`
+	Fingerprint        string `yaml:"fingerprint"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify"`
+}
+
+// RetentionConfig is the retention policy of a single camera. A zero value
+// means that the limit is not applied, leaving only the global storer limits.
+type RetentionConfig struct {
+	SizeLimit int `yaml:"sizeLimit"` // in bytes
+	MaxAge    int `yaml:"maxAge"`    // in hours
+}
+
+// Recording modes of a camera.
+const (
+	RecordContinuous = "continuous" // The whole feed is recorded, in back to back segments
+	RecordEvent      = "event"      // Only the events are recorded, along with their pre-roll and post-roll
+	RecordHybrid     = "hybrid"     // Only keyframes are recorded continuously, while the events are recorded in full
+)
+
+// Containers the recordings of a camera can be saved into.
+const (
+	ContainerMPEGTS = "mpegts" // MPEG-TS segments (.ts)
+	ContainerFMP4   = "fmp4"   // Fragmented MP4 segments (.mp4), that browsers can play directly
+)
+
+// RecordingConfig defines when and how a camera is recorded. In the event and hybrid
+// modes, an event recording starts PreRoll seconds before a recognition, from the
+// keyframe before that, and ends PostRoll seconds after the last recognition.
+type RecordingConfig struct {
+	Mode      string `yaml:"mode"`
+	PreRoll   int    `yaml:"preRoll"`  // in seconds
+	PostRoll  int    `yaml:"postRoll"` // in seconds
+	Container string `yaml:"container"`
+}
+
+// MinEventPostRoll is the shortest PostRoll of the event and hybrid modes, in seconds.
+// The recognizers extend the events that last every 2 seconds, so a shorter
+// post-roll would end the recording between two of them.
+const MinEventPostRoll = 3
+
+// Paces the recorded files can be replayed at.
+const (
+	ReplayRealtime = "realtime" // As they were recorded, dropping the frames the recognizers don't keep up with
+	ReplayFast     = "fast"     // As fast as possible, waiting for the recognizers to take every frame
+)
+
+// ReplayConfig defines how a camera whose URL is a file:// URL replays its files.
+// Replayed files are only recorded again when Record is set, as they usually
+// are recordings already.
+type ReplayConfig struct {
+	Pace   string `yaml:"pace"`
+	Loop   bool   `yaml:"loop"`
+	Record bool   `yaml:"record"`
+}
+
+// StreamURL returns the camera URL with its credentials, if any, embedded into it.
+// It must not be logged: Redact hides the credentials of the texts that are.
+func (c CameraConfig) StreamURL() (string, error) {
+	return withCredentials(c.URL, c.Username, c.Password)
+}
+
+// SubstreamURL returns the substream URL with its credentials, like StreamURL. They are
+// the camera credentials, unless the substream has its own. The camera must have a substream.
+func (c CameraConfig) SubstreamURL() (string, error) {
+	username, password := c.substreamCredentials()
+	return withCredentials(c.Substream, username, password)
+}
+
+func (c CameraConfig) substreamCredentials() (string, string) {
+	if c.SubstreamUsername != "" {
+		return c.SubstreamUsername, c.SubstreamPassword
+	}
+	return c.Username, c.Password
+}
+
+func withCredentials(rawURL string, username string, password string) (string, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	return u.String(), nil
+}
+
+// Redact hides the passwords of the camera and of its substream from a text about
+// to be logged or stored, such as an error that contains the stream URL.
+func (c CameraConfig) Redact(s string) string {
+	s = redact(s, c.Username, c.Password)
+	return redact(s, c.SubstreamUsername, c.SubstreamPassword)
+}
+
+func redact(s string, username string, password string) string {
+	if password == "" {
+		return s
+	}
+	redacted := url.UserPassword(username, "xxxxx").String() + "@"
+	s = strings.ReplaceAll(s, url.UserPassword(username, password).String()+"@", redacted)
+	return strings.ReplaceAll(s, username+":"+password+"@", redacted)
+}
+
+// parseURL parses a camera URL. Unlike url.Parse, its errors don't contain the URL,
+// that can hold credentials.
+func parseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return nil, fmt.Errorf("invalid url: %w", urlErr.Err)
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// FilePath returns the file or directory the camera replays, when its URL is a
+// file:// URL, such as file:///var/footage/front-door.
+func (c CameraConfig) FilePath() (string, bool) {
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return filepath.FromSlash(u.Host + u.Path), true
+}
+
+// RecordingsPath returns the directory where the recordings of the camera are stored,
+// as a subdirectory of the recorder recordingsDir.
+func (c CameraConfig) RecordingsPath(recordingsDir string) string {
+	return filepath.Join(recordingsDir, c.RecordingsDir)
+}
+
+// Location returns the time zone of the camera, falling back to the local
+// time zone when it is not set or can't be loaded.
+func (c CameraConfig) Location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// CameraList returns the configured cameras with their defaults filled in.
+// When no cameras are configured, one camera is created for each of the
+// recorder.rtsp.feeds, named after its position in the list.
+func (c Config) CameraList() []CameraConfig {
+	cameras := c.Cameras
+	if len(cameras) == 0 {
+		for i, feed := range c.Recorder.RTSP.Feeds {
+			cameras = append(cameras, CameraConfig{
+				ID:  fmt.Sprintf("feed-%d", i),
+				URL: feed,
+			})
+		}
+	}
+
+	list := make([]CameraConfig, 0, len(cameras))
+	for _, cam := range cameras {
+		// credentials are moved out of the URL, so that it can be logged
+		if u, err := parseURL(cam.URL); err == nil && u.User != nil {
+			if cam.Username == "" {
+				cam.Username = u.User.Username()
+				cam.Password, _ = u.User.Password()
+			}
+			u.User = nil
+			cam.URL = u.String()
+		}
+		if u, err := parseURL(cam.Substream); err == nil && u.User != nil {
+			if cam.SubstreamUsername == "" {
+				cam.SubstreamUsername = u.User.Username()
+				cam.SubstreamPassword, _ = u.User.Password()
+			}
+			u.User = nil
+			cam.Substream = u.String()
+		}
+		if cam.Name == "" {
+			cam.Name = cam.ID
+		}
+		if cam.RecordingsDir == "" {
+			cam.RecordingsDir = cam.ID
+		}
+		if cam.Recording.Mode == "" {
+			cam.Recording.Mode = RecordContinuous
+		}
+		if cam.Recording.Container == "" {
+			cam.Recording.Container = ContainerMPEGTS
+		}
+		if cam.Recording.PreRoll == 0 {
+			cam.Recording.PreRoll = 5
+		}
+		if cam.Recording.PostRoll == 0 {
+			cam.Recording.PostRoll = 10
+		}
+		if cam.Replay.Pace == "" {
+			cam.Replay.Pace = ReplayRealtime
+		}
+		list = append(list, cam)
+	}
+	return list
+}
+
+// CameraDetectors returns the detectors a camera runs. The camera lists them by the
+// name of a recognizer.detectors entry, or by a detector type, that runs with the
+// default settings. A camera that lists none runs all the recognizer.detectors,
+// or a Haar detector when there are none.
+func (c Config) CameraDetectors(cam CameraConfig) []DetectorConfig {
+	if len(cam.Detectors) == 0 {
+		if len(c.Recognizer.Detectors) != 0 {
+			return c.Recognizer.Detectors
+		}
+		return []DetectorConfig{{Name: DetectorHaar, Type: DetectorHaar}}
+	}
+
+	detectors := make([]DetectorConfig, 0, len(cam.Detectors))
+	for _, name := range cam.Detectors {
+		detector, ok := c.detector(name)
+		if !ok {
+			detector = DetectorConfig{Name: name, Type: name}
+		}
+		detectors = append(detectors, detector)
+	}
+	return detectors
+}
+
+func (c Config) detector(name string) (DetectorConfig, bool) {
+	for _, d := range c.Recognizer.Detectors {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return DetectorConfig{}, false
+}
+
+func knownDetectorType(t string) bool {
+	switch t {
+	case DetectorHaar, DetectorMotion, DetectorDNN:
+		return true
+	}
+	return false
+}
+
+// Camera looks up a configured camera by its ID.
+func (c Config) Camera(id string) (CameraConfig, bool) {
+	for _, cam := range c.CameraList() {
+		if cam.ID == id {
+			return cam, true
+		}
+	}
+	return CameraConfig{}, false
+}
+
+// validate checks the settings that can't be fixed by defaults.
+func (c Config) validate() error {
+	// segment names must be unique across cameras, even if they share a directory
+	if c.Recorder.Segments.FileName != "" && !strings.Contains(c.Recorder.Segments.FileName, "%camera") {
+		return fmt.Errorf("recorder.segments.fileName must contain %%camera")
+	}
+	if c.Recorder.Segments.Duration < 0 || c.Recorder.Segments.MaxSize < 0 {
+		return fmt.Errorf("recorder.segments duration and maxSize can't be negative")
+	}
+	names := make(map[string]bool, len(c.Recognizer.Detectors))
+	for _, d := range c.Recognizer.Detectors {
+		if d.Name == "" {
+			return fmt.Errorf("recognizer.detectors: every detector needs a name")
+		}
+		if names[d.Name] {
+			return fmt.Errorf("recognizer.detectors: duplicate detector %q", d.Name)
+		}
+		names[d.Name] = true
+		if !knownDetectorType(d.Type) {
+			return fmt.Errorf("recognizer.detectors: detector %q has an unknown type %q", d.Name, d.Type)
+		}
+		if d.Type == DetectorDNN && d.Model == "" {
+			return fmt.Errorf("recognizer.detectors: detector %q needs a model", d.Name)
+		}
+	}
+	if c.Live.SegmentDuration < 0 || c.Live.SegmentCount < 0 || c.Live.PartDuration < 0 {
+		return fmt.Errorf("live segmentDuration, segmentCount and partDuration can't be negative")
+	}
+	if (c.Live.RTSP.UDPRTPAddress == "") != (c.Live.RTSP.UDPRTCPAddress == "") {
+		return fmt.Errorf("live.rtsp udpRTPAddress and udpRTCPAddress must be set together")
+	}
+
+	seen := map[string]bool{}
+	for _, cam := range c.Cameras {
+		if cam.ID == "" {
+			return fmt.Errorf("camera %q has no id", cam.Name)
+		}
+		if seen[cam.ID] {
+			return fmt.Errorf("camera id %q is used more than once", cam.ID)
+		}
+		seen[cam.ID] = true
+		if cam.URL == "" {
+			return fmt.Errorf("camera %q has no url", cam.ID)
+		}
+		if cam.Timezone != "" {
+			if _, err := time.LoadLocation(cam.Timezone); err != nil {
+				return fmt.Errorf("camera %q: %w", cam.ID, err)
+			}
+		}
+		switch cam.Recording.Mode {
+		case "", RecordContinuous, RecordEvent, RecordHybrid:
+		default:
+			return fmt.Errorf("camera %q: unknown recording mode %q", cam.ID, cam.Recording.Mode)
+		}
+		switch cam.Recording.Container {
+		case "", ContainerMPEGTS, ContainerFMP4:
+		default:
+			return fmt.Errorf("camera %q: unknown container %q", cam.ID, cam.Recording.Container)
+		}
+		if cam.Recording.PreRoll < 0 || cam.Recording.PostRoll < 0 {
+			return fmt.Errorf("camera %q: preRoll and postRoll can't be negative", cam.ID)
+		}
+		if (cam.Recording.Mode == RecordEvent || cam.Recording.Mode == RecordHybrid) &&
+			cam.Recording.PostRoll != 0 && cam.Recording.PostRoll < MinEventPostRoll {
+			return fmt.Errorf("camera %q: postRoll must be at least %d seconds in the %s mode", cam.ID, MinEventPostRoll, cam.Recording.Mode)
+		}
+		for _, name := range cam.Detectors {
+			if !names[name] && !knownDetectorType(name) {
+				return fmt.Errorf("camera %q: unknown detector %q", cam.ID, name)
+			}
+			if !names[name] && name == DetectorDNN {
+				return fmt.Errorf("camera %q: DNN detectors must be defined in recognizer.detectors, with their model", cam.ID)
+			}
+		}
+		if cam.Analytics.FPS < 0 || cam.Analytics.MaxWidth < 0 || cam.Analytics.MaxHeight < 0 {
+			return fmt.Errorf("camera %q: analytics limits can't be negative", cam.ID)
+		}
+		if cam.Substream != "" {
+			u, err := parseURL(cam.Substream)
+			if err != nil {
+				return fmt.Errorf("camera %q: substream: %w", cam.ID, err)
+			}
+			if u.Scheme != "rtsp" && u.Scheme != "rtsps" {
+				return fmt.Errorf("camera %q: the substream must be a RTSP stream", cam.ID)
+			}
+		}
+		switch cam.RTSP.Transport {
+		case "", TransportUDP, TransportTCP, TransportMulticast:
+		default:
+			return fmt.Errorf("camera %q: unknown RTSP transport %q", cam.ID, cam.RTSP.Transport)
+		}
+		if cam.RTSP.ReadTimeout < 0 || cam.RTSP.WriteTimeout < 0 {
+			return fmt.Errorf("camera %q: RTSP timeouts can't be negative", cam.ID)
+		}
+		if f := cam.RTSP.TLS.Fingerprint; f != "" && len(strings.ReplaceAll(f, ":", "")) != 64 {
+			return fmt.Errorf("camera %q: the TLS fingerprint must be a SHA-256 hash in hex", cam.ID)
+		}
+		switch cam.Replay.Pace {
+		case "", ReplayRealtime, ReplayFast:
+		default:
+			return fmt.Errorf("camera %q: unknown replay pace %q", cam.ID, cam.Replay.Pace)
+		}
+	}
+	return nil
+}
+
 // APIConfig provides the base URL and base path settings for the API server, defining
 // how the API is accessed externally.
 type APIConfig struct {
-	BaseUrl  string `yaml:"baseUrl"`
-	BasePath string `yaml:"basePath"`
+	BaseUrl   string `yaml:"baseUrl"`
+	BasePath  string `yaml:"basePath"`
+	DaemonUrl string `yaml:"daemonUrl"` // where the API reads the health of the daemon from
+}
+
+// LiveConfig defines the live streams of the cameras, served by the daemon.
+// The HLS streams are split into segments of about SegmentDuration, cut at keyframes,
+// and the playlists list the last SegmentCount of them. The segment being made is
+// served in low-latency parts of about PartDuration. ICEServers are the STUN
+// and TURN servers offered to the WebRTC peers.
+type LiveConfig struct {
+	SegmentDuration int              `yaml:"segmentDuration"` // in seconds
+	SegmentCount    int              `yaml:"segmentCount"`
+	PartDuration    int              `yaml:"partDuration"` // in milliseconds
+	ICEServers      []string         `yaml:"iceServers"`
+	RTSP            RTSPServerConfig `yaml:"rtsp"`
+}
+
+// RTSPServerConfig defines the RTSP server that republishes the cameras. UDP is
+// only offered when both UDP addresses are set, and readers must authenticate
+// when Username is set.
+type RTSPServerConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Address        string `yaml:"address"`
+	UDPRTPAddress  string `yaml:"udpRTPAddress"`
+	UDPRTCPAddress string `yaml:"udpRTCPAddress"`
+	Username       string `yaml:"username"`
+	Password       string `yaml:"password"`
+}
+
+// SupervisorConfig defines the restart policy the core applies to components that
+// fail to start. The delay before each restart starts at RestartDelay and doubles
+// after every failed restart, up to MaxRestartDelay.
+type SupervisorConfig struct {
+	MaxRestarts     int `yaml:"maxRestarts"`     // zero means no limit
+	RestartDelay    int `yaml:"restartDelay"`    // in seconds
+	MaxRestartDelay int `yaml:"maxRestartDelay"` // in seconds
+	CheckPeriod     int `yaml:"checkPeriod"`     // in seconds
 }
 
 // CachedConfig holds a globally available instance of Config once it is loaded.
@@ -113,6 +640,9 @@ func findConfig() (Config, error) {
 // ReadConf reads the  github.com/pedrohba1/SSCS/services.yml YAML file and unmarshals it into a Go structure.
 func ReadConf() (Config, error) {
 	cfg, err := findConfig()
+	if err == nil {
+		err = cfg.validate()
+	}
 	CachedConfig = &cfg
 	return cfg, err
 }
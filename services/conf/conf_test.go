@@ -0,0 +1,220 @@
+package conf
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// validConfig returns a config that validates, for the tests to break.
+func validConfig() Config {
+	return Config{
+		Recognizer: RecognizerConfig{
+			Detectors: []DetectorConfig{
+				{Name: "people", Type: DetectorDNN, Model: "yolov8n.onnx"},
+				{Name: "faces", Type: DetectorHaar},
+			},
+		},
+		Cameras: []CameraConfig{
+			{ID: "door", URL: "rtsp://10.0.0.2/main", Detectors: []string{"people", DetectorMotion}},
+			{ID: "yard", URL: "rtsp://10.0.0.3/main", Substream: "rtsp://10.0.0.3/sub", Timezone: "America/Sao_Paulo"},
+		},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string // empty when the config is valid
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"empty", func(c *Config) { *c = Config{} }, ""},
+		{"segment name with camera", func(c *Config) { c.Recorder.Segments.FileName = "%camera/%Y" }, ""},
+		{"segment name without camera", func(c *Config) { c.Recorder.Segments.FileName = "%Y-%m-%d" }, "%camera"},
+		{"negative segment duration", func(c *Config) { c.Recorder.Segments.Duration = -1 }, "can't be negative"},
+		{"detector without name", func(c *Config) { c.Recognizer.Detectors[1].Name = "" }, "needs a name"},
+		{"duplicate detector", func(c *Config) { c.Recognizer.Detectors[1].Name = "people" }, "duplicate detector"},
+		{"unknown detector type", func(c *Config) { c.Recognizer.Detectors[1].Type = "magic" }, "unknown type"},
+		{"dnn detector without model", func(c *Config) { c.Recognizer.Detectors[0].Model = "" }, "needs a model"},
+		{"negative live segments", func(c *Config) { c.Live.SegmentCount = -1 }, "can't be negative"},
+		{"negative live parts", func(c *Config) { c.Live.PartDuration = -1 }, "can't be negative"},
+		{"udp rtp address alone", func(c *Config) { c.Live.RTSP.UDPRTPAddress = ":8000" }, "set together"},
+		{"camera without id", func(c *Config) { c.Cameras[0].ID = "" }, "has no id"},
+		{"duplicate camera", func(c *Config) { c.Cameras[1].ID = "door" }, "more than once"},
+		{"camera without url", func(c *Config) { c.Cameras[0].URL = "" }, "has no url"},
+		{"unknown timezone", func(c *Config) { c.Cameras[1].Timezone = "Mars/Olympus" }, "yard"},
+		{"unknown recording mode", func(c *Config) { c.Cameras[0].Recording.Mode = "sometimes" }, "unknown recording mode"},
+		{"unknown container", func(c *Config) { c.Cameras[0].Recording.Container = "avi" }, "unknown container"},
+		{"negative pre-roll", func(c *Config) { c.Cameras[0].Recording.PreRoll = -1 }, "can't be negative"},
+		{"short event post-roll", func(c *Config) { c.Cameras[0].Recording = RecordingConfig{Mode: RecordEvent, PostRoll: 1} }, "at least 3 seconds"},
+		{"short hybrid post-roll", func(c *Config) { c.Cameras[0].Recording = RecordingConfig{Mode: RecordHybrid, PostRoll: 2} }, "at least 3 seconds"},
+		{"short continuous post-roll", func(c *Config) { c.Cameras[0].Recording = RecordingConfig{Mode: RecordContinuous, PostRoll: 1} }, ""},
+		{"default event post-roll", func(c *Config) { c.Cameras[0].Recording = RecordingConfig{Mode: RecordEvent} }, ""},
+		{"unknown camera detector", func(c *Config) { c.Cameras[0].Detectors = []string{"cars"} }, "unknown detector"},
+		{"camera dnn detector type", func(c *Config) { c.Cameras[0].Detectors = []string{DetectorDNN} }, "must be defined"},
+		{"negative analytics fps", func(c *Config) { c.Cameras[0].Analytics.FPS = -1 }, "can't be negative"},
+		{"non rtsp substream", func(c *Config) { c.Cameras[1].Substream = "http://10.0.0.3/sub" }, "RTSP stream"},
+		{"unknown transport", func(c *Config) { c.Cameras[0].RTSP.Transport = "carrier pigeon" }, "unknown RTSP transport"},
+		{"negative rtsp timeout", func(c *Config) { c.Cameras[0].RTSP.ReadTimeout = -1 }, "can't be negative"},
+		{"short fingerprint", func(c *Config) { c.Cameras[0].RTSP.TLS.Fingerprint = "ab:cd" }, "SHA-256"},
+		{"fingerprint", func(c *Config) { c.Cameras[0].RTSP.TLS.Fingerprint = strings.Repeat("ab:", 31) + "ab" }, ""},
+		{"unknown replay pace", func(c *Config) { c.Cameras[0].Replay.Pace = "slow" }, "unknown replay pace"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(&c)
+			err := c.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCameraList(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []CameraConfig
+	}{
+		{
+			name:   "feeds without cameras",
+			config: Config{Recorder: RecorderConfig{RTSP: RTSPConfig{Feeds: []string{"rtsp://a/1", "rtsp://b/2"}}}},
+			want: []CameraConfig{
+				{ID: "feed-0", Name: "feed-0", URL: "rtsp://a/1", RecordingsDir: "feed-0"},
+				{ID: "feed-1", Name: "feed-1", URL: "rtsp://b/2", RecordingsDir: "feed-1"},
+			},
+		},
+		{
+			name: "cameras take precedence over feeds",
+			config: Config{
+				Recorder: RecorderConfig{RTSP: RTSPConfig{Feeds: []string{"rtsp://a/1"}}},
+				Cameras:  []CameraConfig{{ID: "door", Name: "Front door", URL: "rtsp://c/3", RecordingsDir: "front"}},
+			},
+			want: []CameraConfig{{ID: "door", Name: "Front door", URL: "rtsp://c/3", RecordingsDir: "front"}},
+		},
+		{
+			name:   "credentials moved out of the urls",
+			config: Config{Cameras: []CameraConfig{{ID: "door", URL: "rtsp://admin:secret@c/main", Substream: "rtsp://viewer:other@c/sub"}}},
+			want: []CameraConfig{{
+				ID: "door", Name: "door", URL: "rtsp://c/main", Substream: "rtsp://c/sub", RecordingsDir: "door",
+				Username: "admin", Password: "secret", SubstreamUsername: "viewer", SubstreamPassword: "other",
+			}},
+		},
+		{
+			name: "configured credentials kept",
+			config: Config{Cameras: []CameraConfig{{
+				ID: "door", URL: "rtsp://admin:secret@c/main", Substream: "rtsp://viewer:other@c/sub",
+				Username: "user", Password: "pass", SubstreamUsername: "subuser", SubstreamPassword: "subpass",
+			}}},
+			want: []CameraConfig{{
+				ID: "door", Name: "door", URL: "rtsp://c/main", Substream: "rtsp://c/sub", RecordingsDir: "door",
+				Username: "user", Password: "pass", SubstreamUsername: "subuser", SubstreamPassword: "subpass",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.config.CameraList()
+			if len(got) != len(tt.want) {
+				t.Fatalf("CameraList() has %d cameras, want %d", len(got), len(tt.want))
+			}
+			for i, want := range tt.want {
+				// the defaults of the recording and the replay are checked apart
+				if got[i].Recording != (RecordingConfig{Mode: RecordContinuous, Container: ContainerMPEGTS, PreRoll: 5, PostRoll: 10}) {
+					t.Errorf("camera %d recording = %+v", i, got[i].Recording)
+				}
+				if got[i].Replay.Pace != ReplayRealtime {
+					t.Errorf("camera %d replay pace = %q", i, got[i].Replay.Pace)
+				}
+				got[i].Recording = RecordingConfig{}
+				got[i].Replay = ReplayConfig{}
+				if !reflect.DeepEqual(got[i], want) {
+					t.Errorf("camera %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamURLs(t *testing.T) {
+	tests := []struct {
+		name          string
+		camera        CameraConfig
+		wantURL       string
+		wantSubstream string
+	}{
+		{
+			name:          "no credentials",
+			camera:        CameraConfig{URL: "rtsp://c/main", Substream: "rtsp://c/sub"},
+			wantURL:       "rtsp://c/main",
+			wantSubstream: "rtsp://c/sub",
+		},
+		{
+			name:          "camera credentials",
+			camera:        CameraConfig{URL: "rtsp://c/main", Substream: "rtsp://c/sub", Username: "admin", Password: "secret"},
+			wantURL:       "rtsp://admin:secret@c/main",
+			wantSubstream: "rtsp://admin:secret@c/sub",
+		},
+		{
+			name: "substream credentials",
+			camera: CameraConfig{URL: "rtsp://c/main", Substream: "rtsp://c/sub", Username: "admin", Password: "secret",
+				SubstreamUsername: "viewer", SubstreamPassword: "other"},
+			wantURL:       "rtsp://admin:secret@c/main",
+			wantSubstream: "rtsp://viewer:other@c/sub",
+		},
+		{
+			name:          "escaped password",
+			camera:        CameraConfig{URL: "rtsp://c/main", Substream: "rtsp://c/sub", Username: "admin", Password: "p@ss:w/rd"},
+			wantURL:       "rtsp://admin:p%40ss%3Aw%2Frd@c/main",
+			wantSubstream: "rtsp://admin:p%40ss%3Aw%2Frd@c/sub",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.camera.StreamURL(); err != nil || got != tt.wantURL {
+				t.Errorf("StreamURL() = %q, %v, want %q", got, err, tt.wantURL)
+			}
+			if got, err := tt.camera.SubstreamURL(); err != nil || got != tt.wantSubstream {
+				t.Errorf("SubstreamURL() = %q, %v, want %q", got, err, tt.wantSubstream)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	camera := CameraConfig{Username: "admin", Password: "p@ss", SubstreamUsername: "viewer", SubstreamPassword: "other"}
+
+	tests := []struct {
+		name   string
+		camera CameraConfig
+		text   string
+		want   string
+	}{
+		{"escaped url", camera, "dial rtsp://admin:p%40ss@c/main: timeout", "dial rtsp://admin:xxxxx@c/main: timeout"},
+		{"raw url", camera, "dial rtsp://admin:p@ss@c/main: timeout", "dial rtsp://admin:xxxxx@c/main: timeout"},
+		{"substream url", camera, "dial rtsp://viewer:other@c/sub: timeout", "dial rtsp://viewer:xxxxx@c/sub: timeout"},
+		{"both urls", camera, "rtsp://admin:p%40ss@c/main rtsp://viewer:other@c/sub", "rtsp://admin:xxxxx@c/main rtsp://viewer:xxxxx@c/sub"},
+		{"nothing to hide", camera, "connection refused", "connection refused"},
+		{"no password", CameraConfig{Username: "admin"}, "rtsp://admin@c/main", "rtsp://admin@c/main"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.camera.Redact(tt.text); got != tt.want {
+				t.Errorf("Redact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
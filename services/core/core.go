@@ -5,11 +5,14 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 
+	"github.com/pedrohba1/SSCS/services/camera"
 	"github.com/pedrohba1/SSCS/services/conf"
 	"github.com/pedrohba1/SSCS/services/indexer"
+	"github.com/pedrohba1/SSCS/services/live"
 	"github.com/pedrohba1/SSCS/services/recognizer"
 	"github.com/pedrohba1/SSCS/services/recorder"
 	"github.com/pedrohba1/SSCS/services/storer"
@@ -26,10 +29,15 @@ type Core struct {
 	configPath string             // Path to the configuration file.
 	config     conf.Config        // Struct holding the loaded configuration.
 	Logger     *logrus.Entry      // Logger instance for logging throughout the application.
-	recorder   recorder.Recorder  // Component responsible for recording media.
-	indexer    indexer.Indexer    // Component responsible for indexing recorded media.
-	storer     storer.Storer      	  // Component responsible for storing media.
-	recognizer recognizer.Recognizer // Component responsible for recognizing elements in media.
+	recorders   []recorder.Recorder     // One recorder per configured camera.
+	indexer     indexer.Indexer         // Component responsible for indexing recorded media, shared by all cameras.
+	storer      storer.Storer           // Component responsible for storing media, shared by all cameras.
+	recognizers []recognizer.Recognizer // One recognizer chain per configured camera.
+	relays      []*triggerRelay         // Triggers the event recordings of the cameras that are recorded on events.
+	hlsMuxers   []*live.HLSMuxer        // One live HLS stream per configured camera.
+	whepServers []*live.WHEPServer      // One WebRTC live stream per configured camera.
+	rtspServer  *live.RTSPServer        // Republishes the cameras through RTSP, when enabled.
+	supervisor  *supervisor             // Starts, restarts and stops the components, tracking their health.
 	done       chan struct{}      	// Channel to signal the completion of Core operations.
 }
 
@@ -51,16 +59,47 @@ func (p *Core) Wait() {
 }
 
 
+// LiveHLS returns the live HLS stream of a camera.
+func (p *Core) LiveHLS(cameraID string) (*live.HLSMuxer, bool) {
+	i, ok := p.cameraIndex(cameraID)
+	if !ok {
+		return nil, false
+	}
+	return p.hlsMuxers[i], true
+}
+
+// LiveWHEP returns the WebRTC live stream of a camera.
+func (p *Core) LiveWHEP(cameraID string) (*live.WHEPServer, bool) {
+	i, ok := p.cameraIndex(cameraID)
+	if !ok {
+		return nil, false
+	}
+	return p.whepServers[i], true
+}
+
+// cameraIndex returns the position of a camera, that is also
+// the position of its components.
+func (p *Core) cameraIndex(cameraID string) (int, bool) {
+	for i, cam := range p.config.CameraList() {
+		if cam.ID == cameraID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Health returns the state of each component of the Core.
+func (p *Core) Health() []ComponentHealth {
+	return p.supervisor.health()
+}
+
 // Start begins the operation of all system components and monitors for interrupt
 // signals to initiate a graceful shutdown. This method is the main entry point for
 // running the Core's services and should be called after all configurations are set.
 func (p *Core) Start() {
 	defer close(p.done)
 	// Start your components
-	p.recorder.Start()
-	p.recognizer.Start()
-	p.storer.Start()
-	p.indexer.Start()
+	p.supervisor.start()
 
 	// Handle interrupts or context cancellation
 	interrupt := make(chan os.Signal, 1)
@@ -90,16 +129,108 @@ outer:
 }
 
 func (p *Core) closeResources() {
-	if p.recognizer != nil {
-		p.recognizer.Stop()
+	p.supervisor.stop()
+}
+
+// setupSupervisor registers the components in the order they must be started:
+// the indexer and the storer first, as they consume the events of the others,
+// then the trigger relays, each camera recognizer and live stream, and at last
+// the recorders that feed them.
+func (p *Core) setupSupervisor() {
+	p.supervisor = newSupervisor(p.config.Supervisor, p.Logger)
+	p.supervisor.add("indexer", p.indexer)
+	p.supervisor.add("storer", p.storer)
+
+	for _, t := range p.relays {
+		p.supervisor.add("trigger/"+t.cameraID, t)
+	}
+
+	cameras := p.config.CameraList()
+	for i, v := range p.recognizers {
+		p.supervisor.add("recognizer/"+cameras[i].ID, v)
+	}
+	for i, m := range p.hlsMuxers {
+		p.supervisor.add("live/"+cameras[i].ID, m)
 	}
-	if p.indexer != nil {
-		p.indexer.Stop()
+	for i, w := range p.whepServers {
+		p.supervisor.add("whep/"+cameras[i].ID, w)
 	}
-	if p.recorder != nil {
-		p.recorder.Stop()
+	if p.rtspServer != nil {
+		p.supervisor.add("rtsp", p.rtspServer)
 	}
-	if p.storer != nil {
-		p.storer.Stop()
+	for i, r := range p.recorders {
+		p.supervisor.add("recorder/"+cameras[i].ID, r)
 	}
 }
+
+// recognizerFactory builds the recognizer chain of a single camera.
+type recognizerFactory func(cam conf.CameraConfig, eChans recognizer.EventChannels) (recognizer.Recognizer, error)
+
+// cameraPipelines are the components of every camera, in the order of the cameras.
+type cameraPipelines struct {
+	recorders   []recorder.Recorder
+	recognizers []recognizer.Recognizer
+	relays      []*triggerRelay
+	hlsMuxers   []*live.HLSMuxer
+	whepServers []*live.WHEPServer
+	rtspServer  *live.RTSPServer
+}
+
+// newCameraPipelines creates one recorder, one recognizer chain and the live streams
+// of each camera. Each pipeline gets its own frame channel and stream, while recordings,
+// feed events and recognitions of every camera are sent to the shared recordOut,
+// feedOut and recogOut channels. The recognitions of the cameras recorded on events
+// go through a trigger relay, that also starts their event recordings. When enabled,
+// a single RTSP server republishes the streams of all the cameras.
+func newCameraPipelines(cameras []conf.CameraConfig, recordOut chan<- recorder.RecordedEvent, feedOut chan<- recorder.FeedEvent,
+	recogOut chan<- recognizer.RecognizedEvent, newRecognizer recognizerFactory) (*cameraPipelines, error) {
+	p := &cameraPipelines{
+		recorders:   make([]recorder.Recorder, 0, len(cameras)),
+		recognizers: make([]recognizer.Recognizer, 0, len(cameras)),
+		hlsMuxers:   make([]*live.HLSMuxer, 0, len(cameras)),
+		whepServers: make([]*live.WHEPServer, 0, len(cameras)),
+	}
+	streams := make(map[string]*recorder.Stream, len(cameras))
+
+	for _, cam := range cameras {
+		frameChan := make(chan camera.Frame, 10)
+		stream := recorder.NewStream()
+		streams[cam.ID] = stream
+
+		camRecogOut := recogOut
+		var triggerChan chan recorder.Trigger
+		if cam.Recording.Mode != conf.RecordContinuous {
+			camRecogChan := make(chan recognizer.RecognizedEvent, 5)
+			triggerChan = make(chan recorder.Trigger, 1)
+			p.relays = append(p.relays, newTriggerRelay(cam.ID, camRecogChan, recogOut, triggerChan))
+			camRecogOut = camRecogChan
+		}
+
+		p.recorders = append(p.recorders, recorder.NewRecorder(cam, recorder.EventChannels{
+			RecordOut: recordOut,
+			FrameOut:  frameChan,
+			FeedOut:   feedOut,
+			TriggerIn: triggerChan,
+			Stream:    stream,
+		}))
+
+		v, err := newRecognizer(cam, recognizer.EventChannels{
+			FrameIn:  frameChan,
+			RecogOut: camRecogOut,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("camera %q: %w", cam.ID, err)
+		}
+		p.recognizers = append(p.recognizers, v)
+
+		p.hlsMuxers = append(p.hlsMuxers, live.NewHLSMuxer(cam.ID, stream))
+		p.whepServers = append(p.whepServers, live.NewWHEPServer(cam.ID, stream))
+	}
+
+	cfg, _ := conf.ReadConf()
+	if cfg.Live.RTSP.Enabled {
+		p.rtspServer = live.NewRTSPServer(streams)
+	}
+
+	return p, nil
+}
@@ -2,8 +2,9 @@ package core
 
 import (
 	"context"
-	"image"
+	"fmt"
 
+	"github.com/pedrohba1/SSCS/services/camera"
 	"github.com/pedrohba1/SSCS/services/conf"
 	"github.com/pedrohba1/SSCS/services/indexer"
 	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
@@ -22,23 +23,28 @@ func NewBasic(args []string) *Core {
 		panic(err)
 	}
 
-	// start resources
-	recordChan := make(chan recorder.RecordedEvent, 5)
-	frameChan := make(chan image.Image, 10)
-	//starts the recorder
-
-
-	r := recorder.NewRTSP_H264Recorder(cfg.Recorder.RTSP.Feeds[0], recorder.EventChannels{
-		RecordOut: recordChan,
-		FrameOut:  frameChan,
-	})
+	cameras := cfg.CameraList()
+	if len(cameras) == 0 {
+		panic(fmt.Errorf("no cameras configured"))
+	}
 
-	// starts the recognizer
-	recogChan := make(chan recognizer.RecognizedEvent, 5)
-	v := recognizer.NewHaarDetector(recognizer.EventChannels{
-		FrameIn: frameChan,
-		RecogOut: recogChan,
-	})
+	// start resources, one recorder and recognizer per camera
+	recordChan := make(chan recorder.RecordedEvent, 5*len(cameras))
+	recogChan := make(chan recognizer.RecognizedEvent, 5*len(cameras))
+	feedChan := make(chan recorder.FeedEvent, len(cameras))
+	pipelines, err := newCameraPipelines(cameras, recordChan, feedChan, recogChan,
+		func(cam conf.CameraConfig, eChans recognizer.EventChannels) (recognizer.Recognizer, error) {
+			// the basic core runs a single detector per camera
+			detectors := cfg.CameraDetectors(cam)
+			if len(detectors) > 1 {
+				BaseLogger.BaseLogger.WithField("package", "core").
+					Warnf("camera %s: only the first detector runs in the basic core", cam.ID)
+			}
+			return recognizer.NewDetector(detectors[0], cam.ID, eChans)
+		})
+	if err != nil {
+		panic(err)
+	}
 
 	ctx, ctxCancel := context.WithCancel(context.Background())
 
@@ -48,10 +54,11 @@ func NewBasic(args []string) *Core {
 
 	// starts the indexer
 	dsn := cfg.Indexer.DbUrl
-	i, err := indexer.NewEventIndexer(dsn, indexer.EventChannels{
+	i, err := indexer.NewEventIndexer(dsn, camera.FromConfigList(cameras), indexer.EventChannels{
 		RecordIn: recordChan,
 		RecogIn:  recogChan,
 		CleanIn:  cleanChan,
+		FeedIn:   feedChan,
 	})
 
 	if err != nil {
@@ -60,18 +67,23 @@ func NewBasic(args []string) *Core {
 
 	// Create a new Core instance with the read configuration
 	p := &Core{
-		ctx:        ctx,
-		ctxCancel:  ctxCancel,
-		configPath: configPath,
-		config:     cfg,
-		recorder:   r,
-		indexer:    i,
-		recognizer: v,
-		storer:     s,
-		Logger:     BaseLogger.BaseLogger.WithField("package", "core"),
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+		configPath:  configPath,
+		config:      cfg,
+		recorders:   pipelines.recorders,
+		indexer:     i,
+		recognizers: pipelines.recognizers,
+		relays:      pipelines.relays,
+		hlsMuxers:   pipelines.hlsMuxers,
+		whepServers: pipelines.whepServers,
+		rtspServer:  pipelines.rtspServer,
+		storer:      s,
+		Logger:      BaseLogger.BaseLogger.WithField("package", "core"),
 	}
 
 	p.done = make(chan struct{})
+	p.setupSupervisor()
 
 	go p.Start()
 
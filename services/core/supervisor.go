@@ -0,0 +1,300 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentState is the health state of a component supervised by the Core.
+type ComponentState string
+
+const (
+	StateStarting ComponentState = "starting" // The component is being started or restarted
+	StateRunning  ComponentState = "running"  // The component started and reports no problem
+	StateDegraded ComponentState = "degraded" // The component is running, but reports a problem
+	StateFailed   ComponentState = "failed"   // The component failed to start, or stopped working, and waits for a restart
+	StateStopped  ComponentState = "stopped"  // The component was stopped
+)
+
+// ComponentHealth is a snapshot of the health of a single component.
+type ComponentHealth struct {
+	Name     string         `json:"name"`
+	State    ComponentState `json:"state"`
+	Error    string         `json:"error,omitempty"` // Last error reported by the component
+	Restarts int            `json:"restarts"`
+	Since    time.Time      `json:"since"` // When the component entered its state
+}
+
+// component is what the supervisor starts and stops.
+// All the SSCS components implement it.
+type component interface {
+	Start() error
+	Stop() error
+}
+
+// healthChecker is implemented by components that can report
+// problems while running, such as a recorder whose feed is down.
+type healthChecker interface {
+	Healthy() error
+}
+
+// failureReporter is implemented by components that can stop working after
+// they started, without recovering by themselves, such as a recognizer whose
+// detector exited. Failed components are stopped and restarted.
+type failureReporter interface {
+	Failed() error
+}
+
+// supervisedComponent holds a component along with its health.
+type supervisedComponent struct {
+	name string
+	comp component
+
+	state       ComponentState
+	err         error
+	restarts    int
+	since       time.Time
+	nextRestart time.Time
+}
+
+// supervisor starts the components in the order they were added, restarts the
+// ones that fail to start, or that fail afterwards, according to the restart
+// policy, tracks their health, and stops them in the reverse order. Components should be added after the
+// components they send events to, so that nothing is left without a consumer.
+type supervisor struct {
+	// lifecycleMu keeps start and stop from running at the same time, while
+	// mu protects the health of the components.
+	lifecycleMu sync.Mutex
+	mu          sync.Mutex
+	components  []*supervisedComponent
+
+	maxRestarts  int
+	restartDelay time.Duration
+	maxDelay     time.Duration
+	checkPeriod  time.Duration
+
+	logger *logrus.Entry
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+// newSupervisor creates a supervisor with the given restart policy,
+// using sensible defaults for the values that are not set.
+func newSupervisor(cfg conf.SupervisorConfig, logger *logrus.Entry) *supervisor {
+	s := &supervisor{
+		maxRestarts:  cfg.MaxRestarts,
+		restartDelay: time.Duration(cfg.RestartDelay) * time.Second,
+		maxDelay:     time.Duration(cfg.MaxRestartDelay) * time.Second,
+		checkPeriod:  time.Duration(cfg.CheckPeriod) * time.Second,
+		logger:       logger,
+		stopCh:       make(chan struct{}),
+	}
+	if s.restartDelay <= 0 {
+		s.restartDelay = 5 * time.Second
+	}
+	if s.maxDelay < s.restartDelay {
+		s.maxDelay = 5 * time.Minute
+		if s.maxDelay < s.restartDelay {
+			s.maxDelay = s.restartDelay
+		}
+	}
+	if s.checkPeriod <= 0 {
+		s.checkPeriod = 5 * time.Second
+	}
+	return s
+}
+
+// add registers a component under the given name. It must be called before start.
+func (s *supervisor) add(name string, comp component) {
+	s.components = append(s.components, &supervisedComponent{
+		name:  name,
+		comp:  comp,
+		state: StateStopped,
+		since: time.Now(),
+	})
+}
+
+// start starts every component and keeps watching them until stop is called.
+func (s *supervisor) start() {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	for _, c := range s.components {
+		s.startComponent(c)
+	}
+
+	s.wg.Add(1)
+	go s.watch()
+}
+
+// stop stops watching the components and stops the ones
+// that are running, in the reverse order they were started.
+func (s *supervisor) stop() {
+	s.lifecycleMu.Lock()
+	defer s.lifecycleMu.Unlock()
+
+	close(s.stopCh)
+	s.wg.Wait()
+
+	for i := len(s.components) - 1; i >= 0; i-- {
+		c := s.components[i]
+
+		s.mu.Lock()
+		running := c.state == StateRunning || c.state == StateDegraded
+		s.mu.Unlock()
+		if !running {
+			continue
+		}
+
+		s.logger.Infof("stopping %s...", c.name)
+		err := c.comp.Stop()
+		if err != nil {
+			s.logger.Errorf("failed to stop %s: %v", c.name, err)
+		}
+		s.setState(c, StateStopped, err)
+	}
+}
+
+// health returns a snapshot of the health of every component.
+func (s *supervisor) health() []ComponentHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	health := make([]ComponentHealth, 0, len(s.components))
+	for _, c := range s.components {
+		h := ComponentHealth{
+			Name:     c.name,
+			State:    c.state,
+			Restarts: c.restarts,
+			Since:    c.since,
+		}
+		if c.err != nil {
+			h.Error = c.err.Error()
+		}
+		health = append(health, h)
+	}
+	return health
+}
+
+func (s *supervisor) startComponent(c *supervisedComponent) {
+	s.setState(c, StateStarting, nil)
+
+	err := c.comp.Start()
+	if err != nil {
+		s.logger.Errorf("failed to start %s: %v", c.name, err)
+		s.setState(c, StateFailed, err)
+		s.scheduleRestart(c)
+		return
+	}
+	s.setState(c, StateRunning, nil)
+}
+
+// scheduleRestart sets when a failed component is restarted. The delay doubles
+// with every restart, and no restart is scheduled once maxRestarts is reached.
+func (s *supervisor) scheduleRestart(c *supervisedComponent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxRestarts > 0 && c.restarts >= s.maxRestarts {
+		s.logger.Errorf("%s failed %d times, giving up", c.name, c.restarts+1)
+		c.nextRestart = time.Time{}
+		return
+	}
+
+	delay := s.restartDelay
+	for i := 0; i < c.restarts && delay < s.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > s.maxDelay {
+		delay = s.maxDelay
+	}
+	c.nextRestart = time.Now().Add(delay)
+	s.logger.Infof("restarting %s in %v", c.name, delay)
+}
+
+// watch periodically restarts the failed components that are due,
+// and updates the health of the running ones.
+func (s *supervisor) watch() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.checkPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			for _, c := range s.components {
+				s.check(c)
+			}
+
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *supervisor) check(c *supervisedComponent) {
+	s.mu.Lock()
+	state, nextRestart := c.state, c.nextRestart
+	s.mu.Unlock()
+
+	switch state {
+	case StateFailed:
+		if nextRestart.IsZero() || time.Now().Before(nextRestart) {
+			return
+		}
+		s.mu.Lock()
+		c.restarts++
+		s.mu.Unlock()
+		s.logger.Infof("restarting %s...", c.name)
+		s.startComponent(c)
+
+	case StateRunning, StateDegraded:
+		if reporter, ok := c.comp.(failureReporter); ok {
+			if err := reporter.Failed(); err != nil {
+				s.logger.Errorf("%s stopped working: %v", c.name, err)
+				if err := c.comp.Stop(); err != nil {
+					s.logger.Errorf("failed to stop %s: %v", c.name, err)
+				}
+				s.setState(c, StateFailed, err)
+				s.scheduleRestart(c)
+				return
+			}
+		}
+
+		checker, ok := c.comp.(healthChecker)
+		if !ok {
+			return
+		}
+		if err := checker.Healthy(); err != nil {
+			s.setState(c, StateDegraded, err)
+		} else {
+			s.setState(c, StateRunning, nil)
+		}
+	}
+}
+
+// setState updates the state of a component, logging it when it changes.
+func (s *supervisor) setState(c *supervisedComponent, state ComponentState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := c.state != state
+	c.err = err
+	if !changed {
+		return
+	}
+	c.state = state
+	c.since = time.Now()
+
+	switch state {
+	case StateFailed, StateDegraded:
+		s.logger.Warnf("%s is %s: %v", c.name, state, err)
+	default:
+		s.logger.Infof("%s is %s", c.name, state)
+	}
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/recognizer"
+	"github.com/pedrohba1/SSCS/services/recorder"
+)
+
+// triggerRelay sits between the recognizer of a camera that is recorded on events
+// and the indexer. Every recognition it forwards to the indexer also triggers an
+// event recording on the camera recorder.
+type triggerRelay struct {
+	cameraID   string
+	recogIn    <-chan recognizer.RecognizedEvent
+	recogOut   chan<- recognizer.RecognizedEvent
+	triggerOut chan<- recorder.Trigger
+
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+func newTriggerRelay(cameraID string, recogIn <-chan recognizer.RecognizedEvent, recogOut chan<- recognizer.RecognizedEvent,
+	triggerOut chan<- recorder.Trigger) *triggerRelay {
+	return &triggerRelay{
+		cameraID:   cameraID,
+		recogIn:    recogIn,
+		recogOut:   recogOut,
+		triggerOut: triggerOut,
+	}
+}
+
+func (t *triggerRelay) Start() error {
+	t.stopCh = make(chan struct{})
+	t.wg.Add(1)
+	go t.relay()
+	return nil
+}
+
+func (t *triggerRelay) Stop() error {
+	close(t.stopCh)
+	t.wg.Wait()
+	return nil
+}
+
+func (t *triggerRelay) relay() {
+	defer t.wg.Done()
+
+	for {
+		select {
+		case e := <-t.recogIn:
+			// triggers are dropped while the recorder is busy, as
+			// the one it is handling extends the event anyway
+			select {
+			case t.triggerOut <- recorder.Trigger{CameraID: t.cameraID, Reason: e.Context, Time: time.Now()}:
+			default:
+			}
+
+			select {
+			case t.recogOut <- e:
+			case <-t.stopCh:
+				return
+			}
+
+		case <-t.stopCh:
+			return
+		}
+	}
+}
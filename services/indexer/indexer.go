@@ -28,4 +28,5 @@ type EventChannels struct {
 	RecordIn <-chan recorder.RecordedEvent
 	RecogIn  <-chan recognizer.RecognizedEvent
 	CleanIn  <-chan storer.CleanedEvent
+	FeedIn   <-chan recorder.FeedEvent
 }
@@ -1,8 +1,10 @@
 package indexer
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/pedrohba1/SSCS/services/camera"
 	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
 	"github.com/pedrohba1/SSCS/services/recognizer"
 	"github.com/pedrohba1/SSCS/services/recorder"
@@ -11,20 +13,29 @@ import (
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PostgresIndexer struct {
-	dsn    string
-	db     *gorm.DB
+	dsn     string
+	db      *gorm.DB
+	cameras []camera.Camera
 	logger *logrus.Entry
 
 	wg     sync.WaitGroup
 	eChans EventChannels
 	stopCh chan struct{}
+
+	// listenErr is why the listen loop exited, when it wasn't stopped
+	listenMu  sync.Mutex
+	listenErr error
 }
 
-func NewEventIndexer(dsn string, eChans EventChannels) (*PostgresIndexer, error) {
+// NewEventIndexer creates a PostgresIndexer. The given cameras are stored
+// when it starts, so that recordings and recognitions can reference them.
+func NewEventIndexer(dsn string, cameras []camera.Camera, eChans EventChannels) (*PostgresIndexer, error) {
 	p := &PostgresIndexer{dsn: dsn,
+		cameras: cameras,
 		eChans: eChans,
 		stopCh: make(chan struct{})}
 	p.setupLogger()
@@ -40,12 +51,26 @@ func (p *PostgresIndexer) Stop() error {
 
 func (p *PostgresIndexer) AutoMigrate() error {
 	p.logger.Info("migrating tables...")
+	p.db.AutoMigrate(&camera.Camera{})
 	p.db.AutoMigrate(&recorder.RecordedEvent{})
 	p.db.AutoMigrate(&recognizer.RecognizedEvent{})
+	p.db.AutoMigrate(&recognizer.Detection{})
 	p.db.AutoMigrate(&storer.CleanedEvent{})
+	p.db.AutoMigrate(&recorder.FeedEvent{})
 	return nil
 }
 
+// syncCameras inserts the configured cameras, updating the ones that already exist.
+func (p *PostgresIndexer) syncCameras() error {
+	if len(p.cameras) == 0 {
+		return nil
+	}
+	return p.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "timezone", "updated_at"}),
+	}).Create(&p.cameras).Error
+}
+
 func (p *PostgresIndexer) saveRecord(event recorder.RecordedEvent) error {
 	err := p.db.Create(&event).Error
 	if err != nil {
@@ -55,7 +80,27 @@ func (p *PostgresIndexer) saveRecord(event recorder.RecordedEvent) error {
 	return err
 }
 
+// saveRecognition stores the start of a track, and the following updates of
+// the same event into its row, so that a track is stored only once. The path
+// of the track is stored when it ends.
 func (p *PostgresIndexer) saveRecognition(event recognizer.RecognizedEvent) error {
+	if event.EventID != "" {
+		columns := []string{"end_time", "dwell", "ended"}
+		if event.Ended {
+			columns = append(columns, "trajectory")
+		}
+		res := p.db.Model(&recognizer.RecognizedEvent{}).Where("event_id = ?", event.EventID).
+			Select(columns).Omit(clause.Associations).Updates(&event)
+		if res.Error != nil {
+			p.logger.Info("error updating recognition")
+			return res.Error
+		}
+		if res.RowsAffected != 0 {
+			p.logger.Info("updated recognition: ", event.EventID)
+			return nil
+		}
+	}
+
 	err := p.db.Create(&event).Error
 	if err != nil {
 		p.logger.Info("error indexing record")
@@ -73,6 +118,15 @@ func (p *PostgresIndexer) modifyCleaned(event storer.CleanedEvent) error {
 	return err
 }
 
+func (p *PostgresIndexer) saveFeedEvent(event recorder.FeedEvent) error {
+	err := p.db.Create(&event).Error
+	if err != nil {
+		p.logger.Info("error indexing feed event")
+	}
+	p.logger.Info("saved feed event:", event)
+	return err
+}
+
 func (p *PostgresIndexer) setupLogger() {
 	p.logger = BaseLogger.BaseLogger.WithField("package", "indexer")
 }
@@ -89,24 +143,62 @@ func (p *PostgresIndexer) Start() error {
 
 	p.db = db
 	p.AutoMigrate()
+	if err := p.syncCameras(); err != nil {
+		p.logger.Errorf("failed to save cameras: %v", err)
+		return err
+	}
+	p.setListenErr(nil)
+	p.stopCh = make(chan struct{})
 	p.wg.Add(1)
 	go p.listen()
 
 	return nil
 }
 
+func (p *PostgresIndexer) setListenErr(err error) {
+	p.listenMu.Lock()
+	defer p.listenMu.Unlock()
+	p.listenErr = err
+}
+
+// Failed returns why the listen loop exited, if it did while the indexer was not stopped.
+func (p *PostgresIndexer) Failed() error {
+	p.listenMu.Lock()
+	defer p.listenMu.Unlock()
+	return p.listenErr
+}
+
+// Healthy returns an error while the database can't be reached.
+func (p *PostgresIndexer) Healthy() error {
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
 
 // listens to indexing events, sent by the other components and redirects them to 
 // the according functions to save them
 func (p *PostgresIndexer) listen() error {
 	defer p.wg.Done()
 
+	// a panic while indexing an event is reported to the supervisor,
+	// that restarts the indexer, instead of taking the daemon down
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Errorf("listen loop panicked: %v", r)
+			p.setListenErr(fmt.Errorf("listen loop panicked: %v", r))
+		}
+	}()
+
 	p.logger.Info("listening to index events...")
 
 	for {
 		select {
 		case <-p.stopCh:
 			p.logger.Info("Received stop signal")
+			p.drain()
 			return nil
 		case record := <-p.eChans.RecordIn:
 			if err := p.saveRecord(record); err != nil {
@@ -121,7 +213,38 @@ func (p *PostgresIndexer) listen() error {
 			if err := p.modifyCleaned(clean); err != nil {
 				p.logger.Errorf("Failed to save record: %v", err)
 			}
+		case feed := <-p.eChans.FeedIn:
+			if err := p.saveFeedEvent(feed); err != nil {
+				p.logger.Errorf("Failed to save feed event: %v", err)
+			}
 		}
 	}
 
 }
+
+// drain indexes the events that are still buffered in the channels,
+// such as the last recordings saved by the recorders while stopping
+func (p *PostgresIndexer) drain() {
+	for {
+		select {
+		case record := <-p.eChans.RecordIn:
+			if err := p.saveRecord(record); err != nil {
+				p.logger.Errorf("Failed to save record: %v", err)
+			}
+		case recog := <-p.eChans.RecogIn:
+			if err := p.saveRecognition(recog); err != nil {
+				p.logger.Errorf("Failed to save record: %v", err)
+			}
+		case clean := <-p.eChans.CleanIn:
+			if err := p.modifyCleaned(clean); err != nil {
+				p.logger.Errorf("Failed to save record: %v", err)
+			}
+		case feed := <-p.eChans.FeedIn:
+			if err := p.saveFeedEvent(feed); err != nil {
+				p.logger.Errorf("Failed to save feed event: %v", err)
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,520 @@
+// Package live serves the feeds of the cameras live, from
+// the access units their recorders already receive.
+package live
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/conf"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+	"github.com/pedrohba1/SSCS/services/recorder"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readerSize is how many units are buffered between the recorder and the muxer.
+const readerSize = 256
+
+// ErrTooFarAhead is returned for a blocking playlist reload of a
+// segment that is more than two segments after the last one.
+var ErrTooFarAhead = errors.New("the segment is too far ahead")
+
+var errPartNotFound = errors.New("part not found")
+
+// hlsPart is a partial segment of a live stream, served
+// before the segment it belongs to is complete.
+type hlsPart struct {
+	data        []byte
+	duration    time.Duration
+	independent bool // the part starts with a keyframe
+}
+
+// hlsSegment is a MPEG-TS segment of a live stream.
+type hlsSegment struct {
+	id            uint64
+	data          []byte
+	duration      time.Duration
+	time          time.Time // when the first unit of the segment was received
+	discontinuity bool      // the segment starts a new recording session
+
+	// parts are the partial segments the segment was served as, while it was made.
+	// They are dropped once the segment is too far from the end of the playlist.
+	parts []*hlsPart
+}
+
+// HLSMuxer makes a live HLS stream out of the units of a camera stream.
+// It keeps the last segments in memory, cut at the first keyframe after the
+// segment duration, and serves them along with their playlist.
+//
+// The stream is also served as Low-Latency HLS: the segment being made is published
+// in parts of about the part duration, and the playlist hints the next part, that
+// is answered as soon as it is ready. Players can block on the playlist until it
+// holds a given part, with the _HLS_msn and _HLS_part query params.
+type HLSMuxer struct {
+	cameraID        string
+	stream          *recorder.Stream
+	reader          *recorder.StreamReader
+	segmentDuration time.Duration
+	partDuration    time.Duration
+	segmentCount    int
+	logger          *logrus.Entry
+
+	// mu protects the segments, the parts of the segment being made, and the id of
+	// the next segment, that are served while new ones are made. notify is closed,
+	// and replaced, whenever a part or a segment is published.
+	mu               sync.RWMutex
+	segments         []*hlsSegment
+	cur              *hlsSegment
+	nextID           uint64
+	discontinuitySeq int
+	notify           chan struct{}
+
+	// the session and the segment being made. They are only used by the run goroutine.
+	tracks          *recorder.StreamTracks
+	videoTrack      *mpegts.Track
+	audioTrack      *mpegts.Track
+	w               *mpegts.Writer
+	buf             *bytes.Buffer
+	startPTS        time.Duration
+	lastPTS         time.Duration
+	partStart       int // offset of the part being made into buf
+	partStartPTS    time.Duration
+	partIndependent bool
+	discontinuity   bool
+
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+// NewHLSMuxer allocates a HLSMuxer of the given camera stream.
+func NewHLSMuxer(cameraID string, stream *recorder.Stream) *HLSMuxer {
+	cfg, _ := conf.ReadConf()
+
+	m := &HLSMuxer{
+		cameraID:        cameraID,
+		stream:          stream,
+		segmentDuration: time.Duration(cfg.Live.SegmentDuration) * time.Second,
+		partDuration:    time.Duration(cfg.Live.PartDuration) * time.Millisecond,
+		segmentCount:    cfg.Live.SegmentCount,
+		notify:          make(chan struct{}),
+		stopCh:          make(chan struct{}),
+	}
+	if m.segmentDuration <= 0 {
+		m.segmentDuration = time.Second
+	}
+	if m.partDuration <= 0 {
+		m.partDuration = 200 * time.Millisecond
+	}
+	if m.segmentCount <= 0 {
+		m.segmentCount = 7
+	}
+	m.setupLogger()
+	return m
+}
+
+func (m *HLSMuxer) setupLogger() {
+	m.logger = BaseLogger.BaseLogger.WithField("package", "live").WithField("camera", m.cameraID)
+}
+
+func (m *HLSMuxer) Start() error {
+	m.reader = m.stream.NewReader(readerSize)
+
+	m.wg.Add(1)
+	go m.run()
+	return nil
+}
+
+func (m *HLSMuxer) Stop() error {
+	close(m.stopCh)
+	m.wg.Wait()
+	m.reader.Close()
+	return nil
+}
+
+func (m *HLSMuxer) run() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case u := <-m.reader.C:
+			err := m.write(u)
+			if err != nil {
+				m.logger.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// write adds a unit to the segment being made. A new recording session closes the
+// current segment, as timestamps start over. Units are skipped until a keyframe is received.
+// Parts are cut at the first video unit after the part duration.
+func (m *HLSMuxer) write(u *recorder.Unit) error {
+	if u.Tracks != m.tracks {
+		m.closeSegment()
+		m.startSession(u.Tracks)
+	}
+
+	if m.cur == nil {
+		if !u.Video || !u.RandomAccess {
+			return nil
+		}
+		m.openSegment(u)
+	} else if u.Video && u.RandomAccess && u.PTS-m.startPTS >= m.segmentDuration {
+		m.lastPTS = u.PTS
+		m.closeSegment()
+		m.openSegment(u)
+	} else if u.Video && u.PTS-m.partStartPTS >= m.partDuration {
+		m.lastPTS = u.PTS
+		m.closePart()
+		m.openPart(u)
+	}
+
+	if u.Video {
+		m.lastPTS = u.PTS
+		return m.w.WriteH26x(m.videoTrack, durationGoToMPEGTS(u.PTS), durationGoToMPEGTS(u.DTS), u.RandomAccess, u.AU)
+	}
+
+	switch m.tracks.Audio.(type) {
+	case *mpegts.CodecMPEG4Audio:
+		return m.w.WriteMPEG4Audio(m.audioTrack, durationGoToMPEGTS(u.PTS), u.AU)
+	case *mpegts.CodecOpus:
+		return m.w.WriteOpus(m.audioTrack, durationGoToMPEGTS(u.PTS), u.AU)
+	}
+	return nil
+}
+
+// startSession sets up the MPEG-TS writer of a new recording session.
+func (m *HLSMuxer) startSession(tracks *recorder.StreamTracks) {
+	m.tracks = tracks
+	m.buf = &bytes.Buffer{}
+
+	m.videoTrack = &mpegts.Track{Codec: tracks.Video}
+	mtracks := []*mpegts.Track{m.videoTrack}
+	m.audioTrack = nil
+	if tracks.Audio != nil {
+		m.audioTrack = &mpegts.Track{Codec: tracks.Audio}
+		mtracks = append(mtracks, m.audioTrack)
+	}
+
+	// the writer is kept for the whole session, and its output is split
+	// into segments. Tables are written again at every keyframe.
+	m.w = mpegts.NewWriter(m.buf, mtracks)
+
+	m.mu.RLock()
+	m.discontinuity = len(m.segments) > 0
+	m.mu.RUnlock()
+}
+
+// openSegment starts a segment at a keyframe. It takes the number of the next segment,
+// that is only consumed once the segment is published, as the playlists need consecutive numbers.
+func (m *HLSMuxer) openSegment(u *recorder.Unit) {
+	m.buf.Reset()
+	seg := &hlsSegment{
+		id:            m.nextID,
+		time:          u.Time,
+		discontinuity: m.discontinuity,
+	}
+	m.discontinuity = false
+	m.startPTS = u.PTS
+	m.lastPTS = u.PTS
+
+	m.mu.Lock()
+	m.cur = seg
+	m.mu.Unlock()
+
+	m.openPart(u)
+}
+
+func (m *HLSMuxer) openPart(u *recorder.Unit) {
+	m.partStart = m.buf.Len()
+	m.partStartPTS = u.PTS
+	m.partIndependent = u.RandomAccess
+}
+
+// closePart publishes the part being made, as a part of the segment being made.
+func (m *HLSMuxer) closePart() {
+	part := &hlsPart{
+		data:        append([]byte(nil), m.buf.Bytes()[m.partStart:]...),
+		duration:    m.lastPTS - m.partStartPTS,
+		independent: m.partIndependent,
+	}
+	if part.duration <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cur.parts = append(m.cur.parts, part)
+	m.notifyLocked()
+}
+
+// closeSegment publishes the segment being made, if any, dropping the oldest
+// one when there are too many, and the parts of those that are too old.
+func (m *HLSMuxer) closeSegment() {
+	m.mu.RLock()
+	seg := m.cur
+	m.mu.RUnlock()
+	if seg == nil {
+		return
+	}
+	m.closePart()
+
+	data := append([]byte(nil), m.buf.Bytes()...)
+	duration := m.lastPTS - m.startPTS
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cur = nil
+	defer m.notifyLocked()
+	if duration <= 0 {
+		m.discontinuity = m.discontinuity || seg.discontinuity
+		return
+	}
+	seg.data = data
+	seg.duration = duration
+	m.nextID++
+
+	m.segments = append(m.segments, seg)
+	if len(m.segments) > m.segmentCount {
+		if m.segments[0].discontinuity {
+			m.discontinuitySeq++
+		}
+		m.segments = m.segments[1:]
+	}
+
+	// parts are listed for the segments in the last three target durations of the playlist
+	maxAge := 3 * time.Duration(m.targetDurationLocked()) * time.Second
+	var age time.Duration
+	for i := len(m.segments) - 1; i >= 0; i-- {
+		if age > maxAge {
+			m.segments[i].parts = nil
+		}
+		age += m.segments[i].duration
+	}
+}
+
+// notifyLocked wakes up the requests waiting for a part or a segment.
+func (m *HLSMuxer) notifyLocked() {
+	close(m.notify)
+	m.notify = make(chan struct{})
+}
+
+// targetDurationLocked returns the duration of the longest segment, in seconds, rounded up.
+func (m *HLSMuxer) targetDurationLocked() int {
+	targetDuration := 1
+	for _, seg := range m.segments {
+		if d := int(math.Ceil(seg.duration.Seconds())); d > targetDuration {
+			targetDuration = d
+		}
+	}
+	return targetDuration
+}
+
+// partTargetLocked returns the duration of the longest part listed, that is
+// at least the part duration, as parts are cut at the first frame after it.
+func (m *HLSMuxer) partTargetLocked() time.Duration {
+	target := m.partDuration
+	m.eachSegmentLocked(func(seg *hlsSegment) {
+		for _, part := range seg.parts {
+			if part.duration > target {
+				target = part.duration
+			}
+		}
+	})
+	return target
+}
+
+// eachSegmentLocked calls f with the segments kept, followed by the one being made.
+func (m *HLSMuxer) eachSegmentLocked(f func(seg *hlsSegment)) {
+	for _, seg := range m.segments {
+		f(seg)
+	}
+	if m.cur != nil {
+		f(m.cur)
+	}
+}
+
+// Playlist returns the live playlist, or false while there are no segments yet.
+func (m *HLSMuxer) Playlist() ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.segments) == 0 {
+		return nil, false
+	}
+
+	partTarget := m.partTargetLocked()
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	// the Low-Latency HLS tags require version 9
+	b.WriteString("#EXT-X-VERSION:9\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", m.targetDurationLocked())
+	fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget.Seconds())
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", m.segments[0].id)
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", m.discontinuitySeq)
+
+	for _, seg := range m.segments {
+		writeSegmentHeader(&b, seg)
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", seg.duration.Seconds(), segmentName(seg.id))
+	}
+
+	// the segment being made is only listed by its parts, followed by the next one
+	if m.cur != nil {
+		writeSegmentHeader(&b, m.cur)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s\"\n", partName(m.cur.id, len(m.cur.parts)))
+	}
+
+	return []byte(b.String()), true
+}
+
+// writeSegmentHeader writes the tags of a segment that precede its URI, along with its parts.
+func writeSegmentHeader(b *strings.Builder, seg *hlsSegment) {
+	if seg.discontinuity {
+		b.WriteString("#EXT-X-DISCONTINUITY\n")
+	}
+	b.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + seg.time.Format("2006-01-02T15:04:05.000Z07:00") + "\n")
+	for i, part := range seg.parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s\"", part.duration.Seconds(), partName(seg.id, i))
+		if part.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// BlockingPlaylist returns the live playlist once it holds the given part of the segment
+// with the given media sequence number, or the whole segment when part is negative, as
+// asked by the _HLS_msn and _HLS_part query params of LL-HLS players. It returns
+// ErrTooFarAhead when the segment won't be made soon, and the error of ctx when the
+// playlist isn't updated in time.
+func (m *HLSMuxer) BlockingPlaylist(ctx context.Context, msn uint64, part int) ([]byte, bool, error) {
+	err := m.wait(ctx, func() (bool, error) {
+		if msn > m.nextID+1 {
+			return false, ErrTooFarAhead
+		}
+		if msn < m.nextID {
+			return true, nil
+		}
+		return m.cur != nil && m.cur.id == msn && part >= 0 && part < len(m.cur.parts), nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	playlist, ok := m.Playlist()
+	return playlist, ok, nil
+}
+
+// Part returns the part with the given name, or false when it is not kept anymore.
+// The next part of the segment being made, that the playlist hints, is returned once it is ready.
+func (m *HLSMuxer) Part(ctx context.Context, name string) ([]byte, bool) {
+	msn, index, ok := parsePartName(name)
+	if !ok {
+		return nil, false
+	}
+
+	var data []byte
+	err := m.wait(ctx, func() (bool, error) {
+		m.eachSegmentLocked(func(seg *hlsSegment) {
+			if seg.id == msn && index < len(seg.parts) {
+				data = seg.parts[index].data
+			}
+		})
+		if data != nil {
+			return true, nil
+		}
+		if m.cur != nil && m.cur.id == msn && index == len(m.cur.parts) {
+			return false, nil
+		}
+		return false, errPartNotFound
+	})
+	return data, err == nil
+}
+
+// wait blocks until ready, that is called with mu held, returns true or an error.
+// It gives up after three target durations, or when ctx is done.
+func (m *HLSMuxer) wait(ctx context.Context, ready func() (bool, error)) error {
+	m.mu.RLock()
+	timeout := 3 * time.Duration(m.targetDurationLocked()) * time.Second
+	m.mu.RUnlock()
+	if timeout < 3*m.segmentDuration {
+		timeout = 3 * m.segmentDuration
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		m.mu.RLock()
+		ok, err := ready()
+		notify := m.notify
+		m.mu.RUnlock()
+		if ok || err != nil {
+			return err
+		}
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Segment returns the segment with the given name, or false when it is not kept anymore.
+func (m *HLSMuxer) Segment(name string) ([]byte, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, seg := range m.segments {
+		if segmentName(seg.id) == name {
+			return seg.data, true
+		}
+	}
+	return nil, false
+}
+
+func segmentName(id uint64) string {
+	return "segment" + strconv.FormatUint(id, 10) + ".ts"
+}
+
+func partName(id uint64, index int) string {
+	return "part" + strconv.FormatUint(id, 10) + "." + strconv.Itoa(index) + ".ts"
+}
+
+// parsePartName returns the segment and the index of a part, from its name.
+func parsePartName(name string) (uint64, int, bool) {
+	if !strings.HasPrefix(name, "part") || !strings.HasSuffix(name, ".ts") {
+		return 0, 0, false
+	}
+	id, index, ok := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(name, "part"), ".ts"), ".")
+	if !ok {
+		return 0, 0, false
+	}
+	msn, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 {
+		return 0, 0, false
+	}
+	return msn, i, true
+}
+
+func durationGoToMPEGTS(v time.Duration) int64 {
+	return int64(v.Seconds() * 90000)
+}
@@ -0,0 +1,301 @@
+package live
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/recorder"
+)
+
+// frameInterval is the interval of the test frames, that have a keyframe every second.
+const frameInterval = 100 * time.Millisecond
+
+var testTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestMuxer() *HLSMuxer {
+	return &HLSMuxer{
+		segmentDuration: time.Second,
+		partDuration:    200 * time.Millisecond,
+		segmentCount:    7,
+		notify:          make(chan struct{}),
+	}
+}
+
+// writeFrames writes the frames from the first one to the last one, excluded. It
+// doesn't stop the test on errors, as it is also called by goroutines.
+func writeFrames(t *testing.T, m *HLSMuxer, tracks *recorder.StreamTracks, first, last int) {
+	t.Helper()
+	for i := first; i < last; i++ {
+		pts := time.Duration(i) * frameInterval
+		u := &recorder.Unit{
+			Tracks:       tracks,
+			Video:        true,
+			AU:           [][]byte{{0x41, byte(i)}},
+			PTS:          pts,
+			DTS:          pts,
+			RandomAccess: i%10 == 0,
+			Time:         testTime.Add(pts),
+		}
+		if u.RandomAccess {
+			u.AU = [][]byte{{0x67, 0x42}, {0x68, 0xce}, {0x65, byte(i)}}
+		}
+		if err := m.write(u); err != nil {
+			t.Errorf("write(frame %d) error = %v", i, err)
+			return
+		}
+	}
+}
+
+func TestHLSMuxerPlaylist(t *testing.T) {
+	tracks := &recorder.StreamTracks{Video: &mpegts.CodecH264{}}
+	m := newTestMuxer()
+	writeFrames(t, m, tracks, 0, 15)
+
+	playlist, ok := m.Playlist()
+	if !ok {
+		t.Fatal("Playlist() not ready")
+	}
+	want := `#EXTM3U
+#EXT-X-VERSION:9
+#EXT-X-TARGETDURATION:1
+#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600
+#EXT-X-PART-INF:PART-TARGET=0.200
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-DISCONTINUITY-SEQUENCE:0
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00.000Z
+#EXT-X-PART:DURATION=0.200,URI="part0.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.200,URI="part0.1.ts"
+#EXT-X-PART:DURATION=0.200,URI="part0.2.ts"
+#EXT-X-PART:DURATION=0.200,URI="part0.3.ts"
+#EXT-X-PART:DURATION=0.200,URI="part0.4.ts"
+#EXTINF:1.000,
+segment0.ts
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:01.000Z
+#EXT-X-PART:DURATION=0.200,URI="part1.0.ts",INDEPENDENT=YES
+#EXT-X-PART:DURATION=0.200,URI="part1.1.ts"
+#EXT-X-PRELOAD-HINT:TYPE=PART,URI="part1.2.ts"
+`
+	if string(playlist) != want {
+		t.Errorf("Playlist() =\n%s\nwant\n%s", playlist, want)
+	}
+
+	// the parts of a segment are the segment split
+	var parts [][]byte
+	for _, part := range m.segments[0].parts {
+		parts = append(parts, part.data)
+	}
+	if !bytes.Equal(bytes.Join(parts, nil), m.segments[0].data) {
+		t.Error("the parts of the segment don't make up the segment")
+	}
+}
+
+func TestHLSMuxerPlaylistEntries(t *testing.T) {
+	tests := []struct {
+		name         string
+		frames       int
+		sessions     int // the frames are written again in new recording sessions
+		wantSegments []string
+		wantParts    []string
+		wantHint     string
+	}{
+		{
+			name:   "before the first segment",
+			frames: 8,
+		},
+		{
+			name:         "parts of the last three target durations",
+			frames:       65,
+			wantSegments: []string{"segment0.ts", "segment1.ts", "segment2.ts", "segment3.ts", "segment4.ts", "segment5.ts"},
+			wantParts: []string{
+				"part2.0.ts", "part2.1.ts", "part2.2.ts", "part2.3.ts", "part2.4.ts",
+				"part3.0.ts", "part3.1.ts", "part3.2.ts", "part3.3.ts", "part3.4.ts",
+				"part4.0.ts", "part4.1.ts", "part4.2.ts", "part4.3.ts", "part4.4.ts",
+				"part5.0.ts", "part5.1.ts", "part5.2.ts", "part5.3.ts", "part5.4.ts",
+				"part6.0.ts", "part6.1.ts",
+			},
+			wantHint: "part6.2.ts",
+		},
+		{
+			name:         "oldest segments dropped",
+			frames:       95,
+			wantSegments: []string{"segment2.ts", "segment3.ts", "segment4.ts", "segment5.ts", "segment6.ts", "segment7.ts", "segment8.ts"},
+			wantParts: []string{
+				"part5.0.ts", "part5.1.ts", "part5.2.ts", "part5.3.ts", "part5.4.ts",
+				"part6.0.ts", "part6.1.ts", "part6.2.ts", "part6.3.ts", "part6.4.ts",
+				"part7.0.ts", "part7.1.ts", "part7.2.ts", "part7.3.ts", "part7.4.ts",
+				"part8.0.ts", "part8.1.ts", "part8.2.ts", "part8.3.ts", "part8.4.ts",
+				"part9.0.ts", "part9.1.ts",
+			},
+			wantHint: "part9.2.ts",
+		},
+		{
+			name:         "new session",
+			frames:       15,
+			sessions:     2,
+			wantSegments: []string{"segment0.ts", "segment1.ts", "segment2.ts"},
+			wantParts: []string{
+				"part0.0.ts", "part0.1.ts", "part0.2.ts", "part0.3.ts", "part0.4.ts",
+				"part1.0.ts", "part1.1.ts",
+				"part2.0.ts", "part2.1.ts", "part2.2.ts", "part2.3.ts", "part2.4.ts",
+				"part3.0.ts", "part3.1.ts",
+			},
+			wantHint: "part3.2.ts",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMuxer()
+			for i := 0; i < tt.sessions || i == 0; i++ {
+				writeFrames(t, m, &recorder.StreamTracks{Video: &mpegts.CodecH264{}}, 0, tt.frames)
+			}
+
+			playlist, ok := m.Playlist()
+			if ok != (tt.wantSegments != nil) {
+				t.Fatalf("Playlist() ready = %v, want %v", ok, tt.wantSegments != nil)
+			}
+			var segments, parts []string
+			var hint string
+			for _, line := range strings.Split(string(playlist), "\n") {
+				switch {
+				case strings.HasPrefix(line, "segment"):
+					segments = append(segments, line)
+				case strings.HasPrefix(line, "#EXT-X-PART:"):
+					parts = append(parts, uri(line))
+				case strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"):
+					hint = uri(line)
+				}
+			}
+			if !reflect.DeepEqual(segments, tt.wantSegments) {
+				t.Errorf("segments = %v, want %v", segments, tt.wantSegments)
+			}
+			if !reflect.DeepEqual(parts, tt.wantParts) {
+				t.Errorf("parts = %v, want %v", parts, tt.wantParts)
+			}
+			if hint != tt.wantHint {
+				t.Errorf("hint = %q, want %q", hint, tt.wantHint)
+			}
+			if tt.sessions > 1 && !strings.Contains(string(playlist), "#EXT-X-DISCONTINUITY\n") {
+				t.Error("new session without a discontinuity")
+			}
+		})
+	}
+}
+
+// uri returns the URI attribute of a playlist tag.
+func uri(line string) string {
+	_, value, _ := strings.Cut(line, `URI="`)
+	value, _, _ = strings.Cut(value, `"`)
+	return value
+}
+
+func TestHLSMuxerBlockingPlaylist(t *testing.T) {
+	tests := []struct {
+		name    string
+		msn     uint64
+		part    int
+		written bool // frames are written while waiting
+		wantErr error
+	}{
+		{name: "listed segment", msn: 0, part: -1},
+		{name: "listed part", msn: 1, part: 1},
+		{name: "segment being made", msn: 1, part: -1, written: true},
+		{name: "next part", msn: 1, part: 2, written: true},
+		{name: "next segment", msn: 2, part: 0, written: true},
+		{name: "part not made in time", msn: 1, part: 2, wantErr: context.DeadlineExceeded},
+		{name: "too far ahead", msn: 3, part: -1, wantErr: ErrTooFarAhead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := &recorder.StreamTracks{Video: &mpegts.CodecH264{}}
+			m := newTestMuxer()
+			writeFrames(t, m, tracks, 0, 15)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			if tt.written {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					time.Sleep(10 * time.Millisecond)
+					writeFrames(t, m, tracks, 15, 25)
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			playlist, ok, err := m.BlockingPlaylist(ctx, tt.msn, tt.part)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("BlockingPlaylist() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !ok {
+				t.Fatal("BlockingPlaylist() not ready")
+			}
+			want := segmentName(tt.msn)
+			if tt.part >= 0 {
+				want = partName(tt.msn, tt.part)
+			}
+			if !strings.Contains(string(playlist), want) {
+				t.Errorf("BlockingPlaylist() doesn't list %s:\n%s", want, playlist)
+			}
+		})
+	}
+}
+
+func TestHLSMuxerPart(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		written bool // frames are written while waiting
+		want    bool
+	}{
+		{name: "part of a listed segment", file: "part0.3.ts", want: true},
+		{name: "part of the segment being made", file: "part1.1.ts", want: true},
+		{name: "hinted part", file: "part1.2.ts", written: true, want: true},
+		{name: "hinted part not made in time", file: "part1.2.ts"},
+		{name: "part after the hint", file: "part1.3.ts"},
+		{name: "unknown segment", file: "part7.0.ts"},
+		{name: "segment name", file: "segment0.ts"},
+		{name: "negative index", file: "part0.-1.ts"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := &recorder.StreamTracks{Video: &mpegts.CodecH264{}}
+			m := newTestMuxer()
+			writeFrames(t, m, tracks, 0, 15)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			if tt.written {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					time.Sleep(10 * time.Millisecond)
+					writeFrames(t, m, tracks, 15, 17)
+				}()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			data, ok := m.Part(ctx, tt.file)
+			if ok != tt.want {
+				t.Fatalf("Part(%q) found = %v, want %v", tt.file, ok, tt.want)
+			}
+			if ok && len(data) == 0 {
+				t.Errorf("Part(%q) is empty", tt.file)
+			}
+		})
+	}
+}
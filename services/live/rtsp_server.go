@@ -0,0 +1,424 @@
+package live
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/auth"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/conf"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+	"github.com/pedrohba1/SSCS/services/recorder"
+	"github.com/pion/rtp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// rtspRealm is the realm of the RTSP server authentication.
+const rtspRealm = "SSCS"
+
+// RTSPServer republishes the stream of every camera through RTSP, at
+// rtsp://<address>/<camera id>, so that other tools can read the cameras
+// from SSCS instead of opening more connections to them.
+//
+// The stream of a camera is published at the first keyframe of each recording
+// session, with the parameter sets in the SDP. Readers are disconnected when
+// the recording session ends, as the tracks can change with the next one.
+type RTSPServer struct {
+	cfg    conf.RTSPServerConfig
+	paths  map[string]*rtspPath
+	server *gortsplib.Server
+	nonce  string
+	logger *logrus.Entry
+
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+// NewRTSPServer allocates a RTSPServer of the given camera streams, by camera ID.
+func NewRTSPServer(streams map[string]*recorder.Stream) *RTSPServer {
+	cfg, _ := conf.ReadConf()
+
+	s := &RTSPServer{
+		cfg:    cfg.Live.RTSP,
+		paths:  make(map[string]*rtspPath),
+		stopCh: make(chan struct{}),
+	}
+	if s.cfg.Address == "" {
+		s.cfg.Address = ":8555"
+	}
+	for cameraID, stream := range streams {
+		s.paths[cameraID] = &rtspPath{cameraID: cameraID, stream: stream}
+	}
+	s.setupLogger()
+	return s
+}
+
+func (s *RTSPServer) setupLogger() {
+	s.logger = BaseLogger.BaseLogger.WithField("package", "live")
+}
+
+func (s *RTSPServer) Start() error {
+	nonce, err := auth.GenerateNonce()
+	if err != nil {
+		return err
+	}
+	s.nonce = nonce
+
+	s.server = &gortsplib.Server{
+		Handler:        s,
+		RTSPAddress:    s.cfg.Address,
+		UDPRTPAddress:  s.cfg.UDPRTPAddress,
+		UDPRTCPAddress: s.cfg.UDPRTCPAddress,
+	}
+	err = s.server.Start()
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("RTSP server listening on %s", s.cfg.Address)
+
+	for _, p := range s.paths {
+		p.server = s.server
+		p.logger = s.logger.WithField("camera", p.cameraID)
+		p.reader = p.stream.NewReader(readerSize)
+
+		s.wg.Add(1)
+		go p.run(&s.wg, s.stopCh)
+	}
+	return nil
+}
+
+func (s *RTSPServer) Stop() error {
+	close(s.stopCh)
+	s.wg.Wait()
+	for _, p := range s.paths {
+		p.reader.Close()
+		p.setStream(nil)
+	}
+	s.server.Close()
+	return nil
+}
+
+// OnDescribe implements gortsplib.ServerHandlerOnDescribe.
+func (s *RTSPServer) OnDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return s.findStream(ctx.Request, ctx.Path)
+}
+
+// OnSetup implements gortsplib.ServerHandlerOnSetup.
+func (s *RTSPServer) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) (*base.Response, *gortsplib.ServerStream, error) {
+	return s.findStream(ctx.Request, ctx.Path)
+}
+
+// OnPlay implements gortsplib.ServerHandlerOnPlay.
+func (s *RTSPServer) OnPlay(_ *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	return &base.Response{StatusCode: base.StatusOK}, nil
+}
+
+// findStream authenticates a request, and returns the stream of the camera at the given path.
+func (s *RTSPServer) findStream(req *base.Request, path string) (*base.Response, *gortsplib.ServerStream, error) {
+	if s.cfg.Username != "" {
+		// some clients strip the control attribute from the URL of SETUP requests
+		baseURL := &base.URL{Scheme: req.URL.Scheme, Host: req.URL.Host, Path: path + "/"}
+		err := auth.Validate(req, s.cfg.Username, s.cfg.Password, baseURL, nil, rtspRealm, s.nonce)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusUnauthorized,
+				Header: base.Header{
+					"WWW-Authenticate": auth.GenerateWWWAuthenticate(nil, rtspRealm, s.nonce),
+				},
+			}, nil, nil
+		}
+	}
+
+	p, ok := s.paths[strings.Trim(path, "/")]
+	if !ok {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, fmt.Errorf("camera not found: %s", path)
+	}
+
+	stream := p.getStream()
+	if stream == nil {
+		return &base.Response{StatusCode: base.StatusNotFound}, nil, fmt.Errorf("camera %s is not being recorded", p.cameraID)
+	}
+	return &base.Response{StatusCode: base.StatusOK}, stream, nil
+}
+
+// rtspTrack sends the units of a track of the stream as RTP packets.
+type rtspTrack struct {
+	media  *description.Media
+	encode func(u *recorder.Unit) ([]*rtp.Packet, error)
+}
+
+// rtspPath publishes the stream of a camera.
+type rtspPath struct {
+	cameraID string
+	stream   *recorder.Stream
+	reader   *recorder.StreamReader
+	server   *gortsplib.Server
+	logger   *logrus.Entry
+
+	// mu protects serverStream, that is read by the server
+	// handlers and replaced at each recording session.
+	mu           sync.Mutex
+	serverStream *gortsplib.ServerStream
+}
+
+func (p *rtspPath) getStream() *gortsplib.ServerStream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.serverStream
+}
+
+// setStream replaces the published stream, disconnecting the readers of the previous one.
+func (p *rtspPath) setStream(stream *gortsplib.ServerStream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.serverStream != nil {
+		p.serverStream.Close()
+	}
+	p.serverStream = stream
+}
+
+func (p *rtspPath) run(wg *sync.WaitGroup, stopCh chan struct{}) {
+	defer wg.Done()
+
+	var tracks *recorder.StreamTracks
+	var videoTrack, audioTrack *rtspTrack
+	var stream *gortsplib.ServerStream
+
+	for {
+		select {
+		case <-stopCh:
+			return
+
+		case u := <-p.reader.C:
+			if u.Tracks != tracks {
+				tracks = u.Tracks
+				videoTrack, audioTrack, stream = nil, nil, nil
+				p.setStream(nil)
+			}
+
+			// the stream is published once the parameter sets are known
+			if stream == nil {
+				if !u.Video || !u.RandomAccess {
+					continue
+				}
+
+				var err error
+				videoTrack, audioTrack, err = newRTSPTracks(tracks, u.AU)
+				if err != nil {
+					p.logger.Errorf("%v", err)
+					tracks = nil
+					continue
+				}
+
+				desc := &description.Session{Medias: []*description.Media{videoTrack.media}}
+				if audioTrack != nil {
+					desc.Medias = append(desc.Medias, audioTrack.media)
+				}
+				stream = gortsplib.NewServerStream(p.server, desc)
+				p.setStream(stream)
+			}
+
+			track := videoTrack
+			if !u.Video {
+				track = audioTrack
+			}
+			if track == nil {
+				continue
+			}
+
+			pkts, err := track.encode(u)
+			if err != nil {
+				p.logger.Errorf("%v", err)
+				continue
+			}
+			for _, pkt := range pkts {
+				stream.WritePacketRTPWithNTP(track.media, pkt, u.Time)
+			}
+		}
+	}
+}
+
+// newRTSPTracks sets up the tracks of a recording session,
+// taking the parameter sets from its first random access unit.
+func newRTSPTracks(tracks *recorder.StreamTracks, au [][]byte) (*rtspTrack, *rtspTrack, error) {
+	videoTrack, err := newRTSPVideoTrack(tracks.Video, au)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tracks.Audio == nil {
+		return videoTrack, nil, nil
+	}
+	audioTrack, err := newRTSPAudioTrack(tracks.Audio)
+	if err != nil {
+		return nil, nil, err
+	}
+	return videoTrack, audioTrack, nil
+}
+
+func newRTSPVideoTrack(codec mpegts.Codec, au [][]byte) (*rtspTrack, error) {
+	var forma format.Format
+	var encode func(au [][]byte) ([]*rtp.Packet, error)
+	var isDelimiter func(nalu []byte) bool
+
+	switch codec.(type) {
+	case *mpegts.CodecH264:
+		f := &format.H264{PayloadTyp: 96, PacketizationMode: 1}
+		for _, nalu := range au {
+			switch h264.NALUType(nalu[0] & 0x1F) {
+			case h264.NALUTypeSPS:
+				f.SPS = nalu
+			case h264.NALUTypePPS:
+				f.PPS = nalu
+			}
+		}
+		enc, err := f.CreateEncoder()
+		if err != nil {
+			return nil, err
+		}
+		forma, encode, isDelimiter = f, enc.Encode, isH264Delimiter
+
+	case *mpegts.CodecH265:
+		f := &format.H265{PayloadTyp: 96}
+		for _, nalu := range au {
+			switch h265.NALUType((nalu[0] >> 1) & 0b111111) {
+			case h265.NALUType_VPS_NUT:
+				f.VPS = nalu
+			case h265.NALUType_SPS_NUT:
+				f.SPS = nalu
+			case h265.NALUType_PPS_NUT:
+				f.PPS = nalu
+			}
+		}
+		enc, err := f.CreateEncoder()
+		if err != nil {
+			return nil, err
+		}
+		forma, encode, isDelimiter = f, enc.Encode, isH265Delimiter
+
+	default:
+		return nil, fmt.Errorf("unsupported video codec %T", codec)
+	}
+
+	return &rtspTrack{
+		media: &description.Media{Type: description.MediaTypeVideo, Formats: []format.Format{forma}},
+		encode: func(u *recorder.Unit) ([]*rtp.Packet, error) {
+			pkts, err := encode(withoutDelimiter(u.AU, isDelimiter))
+			if err != nil {
+				return nil, err
+			}
+			ts := durationToRTP(u.PTS, forma.ClockRate())
+			for _, pkt := range pkts {
+				pkt.Timestamp = ts
+			}
+			return pkts, nil
+		},
+	}, nil
+}
+
+func newRTSPAudioTrack(codec mpegts.Codec) (*rtspTrack, error) {
+	var forma format.Format
+	var encode func(au []byte) ([]*rtp.Packet, error)
+	var duration func(au []byte) time.Duration
+
+	switch codec := codec.(type) {
+	case *mpegts.CodecMPEG4Audio:
+		config := codec.Config
+		f := &format.MPEG4Audio{
+			PayloadTyp:       97,
+			Config:           &config,
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+		}
+		enc, err := f.CreateEncoder()
+		if err != nil {
+			return nil, err
+		}
+		forma = f
+		encode = func(au []byte) ([]*rtp.Packet, error) {
+			return enc.Encode([][]byte{au})
+		}
+		duration = func(_ []byte) time.Duration {
+			return time.Duration(mpeg4audio.SamplesPerAccessUnit) * time.Second / time.Duration(config.SampleRate)
+		}
+
+	case *mpegts.CodecOpus:
+		f := &format.Opus{PayloadTyp: 97, IsStereo: codec.ChannelCount == 2}
+		enc, err := f.CreateEncoder()
+		if err != nil {
+			return nil, err
+		}
+		forma = f
+		encode = func(au []byte) ([]*rtp.Packet, error) {
+			pkt, err := enc.Encode(au)
+			if err != nil {
+				return nil, err
+			}
+			return []*rtp.Packet{pkt}, nil
+		}
+		duration = opus.PacketDuration
+
+	default:
+		return nil, fmt.Errorf("unsupported audio codec %T", codec)
+	}
+
+	return &rtspTrack{
+		media: &description.Media{Type: description.MediaTypeAudio, Formats: []format.Format{forma}},
+		encode: func(u *recorder.Unit) ([]*rtp.Packet, error) {
+			var pkts []*rtp.Packet
+			pts := u.PTS
+			for _, au := range u.AU {
+				auPkts, err := encode(au)
+				if err != nil {
+					return nil, err
+				}
+				ts := durationToRTP(pts, forma.ClockRate())
+				for _, pkt := range auPkts {
+					pkt.Timestamp = ts
+				}
+				pkts = append(pkts, auPkts...)
+				pts += duration(au)
+			}
+			return pkts, nil
+		},
+	}, nil
+}
+
+// withoutDelimiter returns an access unit without its delimiter,
+// that is only needed by MPEG-TS.
+func withoutDelimiter(au [][]byte, isDelimiter func(nalu []byte) bool) [][]byte {
+	ret := make([][]byte, 0, len(au))
+	for _, nalu := range au {
+		if !isDelimiter(nalu) {
+			ret = append(ret, nalu)
+		}
+	}
+	return ret
+}
+
+func isH264Delimiter(nalu []byte) bool {
+	return h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeAccessUnitDelimiter
+}
+
+func isH265Delimiter(nalu []byte) bool {
+	return h265.NALUType((nalu[0]>>1)&0b111111) == h265.NALUType_AUD_NUT
+}
+
+// durationToRTP converts a timestamp to a RTP one, that wraps around.
+// Seconds are converted apart from their fraction, so that the product
+// doesn't overflow after hours of stream.
+func durationToRTP(v time.Duration, clockRate int) uint32 {
+	secs := v / time.Second
+	return uint32(int64(secs)*int64(clockRate) + int64(v%time.Second)*int64(clockRate)/int64(time.Second))
+}
@@ -0,0 +1,30 @@
+package live
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationToRTP(t *testing.T) {
+	tests := []struct {
+		name      string
+		v         time.Duration
+		clockRate int
+		want      uint32
+	}{
+		{"zero", 0, 90000, 0},
+		{"video", 1500 * time.Millisecond, 90000, 135000},
+		{"audio", 20 * time.Millisecond, 48000, 960},
+		{"wraps around", 14 * time.Hour, 90000, 241032704},
+		{"over 30 hours", 31*time.Hour + 500*time.Millisecond, 90000, 1454110408},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := durationToRTP(tt.v, tt.clockRate)
+			if got != tt.want {
+				t.Errorf("durationToRTP(%v, %d) = %d, want %d", tt.v, tt.clockRate, got, tt.want)
+			}
+		})
+	}
+}
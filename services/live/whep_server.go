@@ -0,0 +1,387 @@
+package live
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/conf"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+	"github.com/pedrohba1/SSCS/services/recorder"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSessionNotFound is returned when a WHEP session doesn't exist, or was closed.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ErrStreamUnavailable is returned when a WHEP session is requested
+// while the server is not started, or already stopped, or before the
+// first H264 keyframe of the camera was received.
+var ErrStreamUnavailable = errors.New("live stream unavailable")
+
+// whepPayloadMaxSize is the maximum size of the RTP payloads sent to the peers,
+// smaller than the one of RTSP, as WebRTC adds its own headers.
+const whepPayloadMaxSize = 1200
+
+// whepTimeout is how long a new session can take to connect before it's closed.
+const whepTimeout = 10 * time.Second
+
+// WHEPServer serves the live stream of a camera to WebRTC peers,
+// that connect through WHEP (WebRTC-HTTP Egress Protocol).
+//
+// The H264 access units received by the recorder are sent again as RTP packets,
+// with their parameter sets, on a track of each peer. Every peer starts
+// receiving the video at the first keyframe sent after it was created.
+type WHEPServer struct {
+	cameraID string
+	stream   *recorder.Stream
+	reader   *recorder.StreamReader
+	api      *webrtc.API
+	config   webrtc.Configuration
+	logger   *logrus.Entry
+
+	// mu protects the sessions, that are created
+	// and closed by the HTTP handlers, and the fmtp line
+	// of their tracks, taken from the last SPS received.
+	mu       sync.Mutex
+	started  bool
+	fmtp     string
+	sessions map[string]*whepSession
+
+	wg     sync.WaitGroup
+	stopCh chan struct{}
+}
+
+// whepSession is a peer of a WHEPServer.
+type whepSession struct {
+	pc    *webrtc.PeerConnection
+	track *webrtc.TrackLocalStaticRTP
+
+	// started is set when the first keyframe is sent to the peer,
+	// and reset when the recording session changes.
+	started bool
+}
+
+// NewWHEPServer allocates a WHEPServer of the given camera stream.
+func NewWHEPServer(cameraID string, stream *recorder.Stream) *WHEPServer {
+	cfg, _ := conf.ReadConf()
+
+	s := &WHEPServer{
+		cameraID: cameraID,
+		stream:   stream,
+		sessions: make(map[string]*whepSession),
+		stopCh:   make(chan struct{}),
+	}
+	if len(cfg.Live.ICEServers) != 0 {
+		s.config.ICEServers = []webrtc.ICEServer{{URLs: cfg.Live.ICEServers}}
+	}
+	s.setupLogger()
+	return s
+}
+
+func (s *WHEPServer) setupLogger() {
+	s.logger = BaseLogger.BaseLogger.WithField("package", "live").WithField("camera", s.cameraID)
+}
+
+func (s *WHEPServer) Start() error {
+	m := &webrtc.MediaEngine{}
+	err := m.RegisterDefaultCodecs()
+	if err != nil {
+		return err
+	}
+	ir := &interceptor.Registry{}
+	err = webrtc.RegisterDefaultInterceptors(m, ir)
+	if err != nil {
+		return err
+	}
+	s.api = webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(ir))
+
+	s.reader = s.stream.NewReader(readerSize)
+
+	s.wg.Add(1)
+	go s.run()
+
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *WHEPServer) Stop() error {
+	close(s.stopCh)
+	s.wg.Wait()
+	if s.reader != nil {
+		s.reader.Close()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = false
+	s.fmtp = ""
+	for id, sess := range s.sessions {
+		sess.pc.Close()
+		delete(s.sessions, id)
+	}
+	return nil
+}
+
+// run sends the video of the stream to the peers, starting every
+// peer, and every recording session, at a keyframe.
+func (s *WHEPServer) run() {
+	defer s.wg.Done()
+
+	var tracks *recorder.StreamTracks
+	var enc *rtph264.Encoder
+	var warned bool
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+
+		case u := <-s.reader.C:
+			if u.Tracks != tracks {
+				tracks = u.Tracks
+				enc = nil
+				s.restartSessions()
+
+				if _, ok := tracks.Video.(*mpegts.CodecH264); !ok {
+					s.mu.Lock()
+					s.fmtp = ""
+					s.mu.Unlock()
+					if !warned {
+						s.logger.Warn("WebRTC live view is only available for H264 feeds")
+						warned = true
+					}
+					continue
+				}
+
+				enc = &rtph264.Encoder{PayloadType: 96, PacketizationMode: 1, PayloadMaxSize: whepPayloadMaxSize}
+				err := enc.Init()
+				if err != nil {
+					s.logger.Errorf("%v", err)
+					enc = nil
+					continue
+				}
+			}
+
+			if enc == nil || !u.Video {
+				continue
+			}
+			if u.RandomAccess {
+				s.setSPS(u.AU)
+			}
+
+			err := s.writeAU(enc, u)
+			if err != nil {
+				s.logger.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// restartSessions makes every peer wait for the first keyframe
+// of a new recording session.
+func (s *WHEPServer) restartSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.started = false
+	}
+}
+
+// setSPS sets the fmtp line of the tracks of the new peers
+// from the SPS of a keyframe.
+func (s *WHEPServer) setSPS(au [][]byte) {
+	for _, nalu := range au {
+		if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSPS {
+			fmtp, ok := h264FmtpLine(nalu)
+			if ok {
+				s.mu.Lock()
+				s.fmtp = fmtp
+				s.mu.Unlock()
+			}
+			return
+		}
+	}
+}
+
+// writeAU sends an access unit to the peers, without its delimiter.
+// Peers that didn't start yet only receive it if it's a keyframe.
+func (s *WHEPServer) writeAU(enc *rtph264.Encoder, u *recorder.Unit) error {
+	pkts, err := enc.Encode(withoutDelimiter(u.AU, isH264Delimiter))
+	if err != nil {
+		return err
+	}
+
+	ts := durationToRTP(u.PTS, 90000)
+	for _, pkt := range pkts {
+		pkt.Timestamp = ts
+	}
+
+	var tracks []*webrtc.TrackLocalStaticRTP
+	s.mu.Lock()
+	for _, sess := range s.sessions {
+		if !sess.started {
+			if !u.RandomAccess {
+				continue
+			}
+			sess.started = true
+		}
+		tracks = append(tracks, sess.track)
+	}
+	s.mu.Unlock()
+
+	for _, track := range tracks {
+		for _, pkt := range pkts {
+			// errors are only about peers that went away,
+			// and they are closed by their state changes
+			track.WriteRTP(pkt)
+		}
+	}
+	return nil
+}
+
+// NewSession creates a session for a peer from its SDP offer, and returns
+// the session ID along with the SDP answer, that holds every ICE candidate.
+// Sessions can only be created while the server is started.
+func (s *WHEPServer) NewSession(offer string) (string, string, error) {
+	s.mu.Lock()
+	started, fmtp := s.started, s.fmtp
+	s.mu.Unlock()
+	if !started || fmtp == "" {
+		return "", "", ErrStreamUnavailable
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
+		MimeType:    webrtc.MimeTypeH264,
+		ClockRate:   90000,
+		SDPFmtpLine: fmtp,
+	}, "video", s.cameraID)
+	if err != nil {
+		return "", "", err
+	}
+
+	pc, err := s.api.NewPeerConnection(s.config)
+	if err != nil {
+		return "", "", err
+	}
+
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	// read the RTCP packets, so that the interceptors process them
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			_, _, err := sender.Read(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	id, err := newSessionID()
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	var connectedOnce sync.Once
+	connected := make(chan struct{})
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		s.logger.Debugf("WHEP session %s: %s", id, state)
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectedOnce.Do(func() { close(connected) })
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			s.CloseSession(id)
+		}
+	})
+
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	err = pc.SetLocalDescription(answer)
+	if err != nil {
+		pc.Close()
+		return "", "", err
+	}
+	<-gatherComplete
+
+	// the server may have been stopped while the ICE candidates were gathered
+	s.mu.Lock()
+	if !s.started {
+		s.mu.Unlock()
+		pc.Close()
+		return "", "", ErrStreamUnavailable
+	}
+	s.sessions[id] = &whepSession{pc: pc, track: track}
+	s.mu.Unlock()
+
+	// sessions whose peer never connects are closed too
+	go func() {
+		select {
+		case <-connected:
+		case <-time.After(whepTimeout):
+			s.CloseSession(id)
+		case <-s.stopCh:
+		}
+	}()
+
+	s.logger.Infof("WHEP session %s created", id)
+	return id, pc.LocalDescription().SDP, nil
+}
+
+// CloseSession closes a session, or returns ErrSessionNotFound.
+func (s *WHEPServer) CloseSession(id string) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+
+	if !ok {
+		return ErrSessionNotFound
+	}
+	s.logger.Infof("WHEP session %s closed", id)
+	return sess.pc.Close()
+}
+
+// h264FmtpLine returns the fmtp line of a H264 track, whose
+// profile-level-id is made of the profile and level bytes of its SPS.
+func h264FmtpLine(sps []byte) (string, bool) {
+	if len(sps) < 4 {
+		return "", false
+	}
+	return "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=" + hex.EncodeToString(sps[1:4]), true
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
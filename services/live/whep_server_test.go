@@ -0,0 +1,118 @@
+package live
+
+import (
+	"testing"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/pedrohba1/SSCS/services/recorder"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestH264FmtpLine(t *testing.T) {
+	tests := []struct {
+		name string
+		sps  []byte
+		want string
+		ok   bool
+	}{
+		{
+			name: "constrained baseline",
+			sps:  []byte{0x67, 0x42, 0xe0, 0x1f, 0xda},
+			want: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+			ok:   true,
+		},
+		{
+			name: "high",
+			sps:  []byte{0x67, 0x64, 0x00, 0x28, 0xac},
+			want: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640028",
+			ok:   true,
+		},
+		{
+			name: "truncated",
+			sps:  []byte{0x67, 0x64},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := h264FmtpLine(tt.sps)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("h264FmtpLine() = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestWHEPServerSessionsStartAtKeyframe(t *testing.T) {
+	s := &WHEPServer{sessions: make(map[string]*whepSession)}
+	enc := &rtph264.Encoder{PayloadType: 96, PacketizationMode: 1, PayloadMaxSize: whepPayloadMaxSize}
+	err := enc.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addSession := func(id string) {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "cam")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.sessions[id] = &whepSession{track: track}
+	}
+	started := func() map[string]bool {
+		ret := make(map[string]bool)
+		for id, sess := range s.sessions {
+			ret[id] = sess.started
+		}
+		return ret
+	}
+
+	sps := []byte{0x67, 0x42, 0xe0, 0x1f}
+	keyframe := &recorder.Unit{Video: true, RandomAccess: true, AU: [][]byte{sps, {0x68, 0xce}, {0x65, 0x88}}}
+	frame := &recorder.Unit{Video: true, AU: [][]byte{{0x41, 0x9a}}}
+
+	addSession("a")
+	for _, u := range []*recorder.Unit{frame, keyframe, frame} {
+		err = s.writeAU(enc, u)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a peer created between keyframes waits for the next one
+	addSession("b")
+	err = s.writeAU(enc, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := started(); !got["a"] || got["b"] {
+		t.Fatalf("started = %v, want only a", got)
+	}
+
+	err = s.writeAU(enc, keyframe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := started(); !got["a"] || !got["b"] {
+		t.Fatalf("started = %v, want a and b", got)
+	}
+
+	// a new recording session starts over at its first keyframe
+	s.restartSessions()
+	err = s.writeAU(enc, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := started(); got["a"] || got["b"] {
+		t.Fatalf("started = %v, want none", got)
+	}
+}
+
+func TestWHEPServerSetSPS(t *testing.T) {
+	s := &WHEPServer{}
+	s.setSPS([][]byte{{0x09, 0xf0}, {0x67, 0x64, 0x00, 0x28}, {0x68, 0xce}, {0x65, 0x88}})
+
+	want := "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640028"
+	if s.fmtp != want {
+		t.Errorf("fmtp = %q, want %q", s.fmtp, want)
+	}
+}
@@ -1,8 +1,9 @@
 package recognizer
 
 import (
-	"image"
+	"fmt"
 
+	"github.com/pedrohba1/SSCS/services/camera"
 	"github.com/pedrohba1/SSCS/services/conf"
 	"github.com/pedrohba1/SSCS/services/helpers"
 	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
@@ -10,77 +11,141 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// detectorQueueSize is how many frames can wait for each detector of a
+// CompositeRecognizer. Frames are dropped for a detector whose queue is full.
+const detectorQueueSize = 2
+
+// compositeDetector is a detector of a CompositeRecognizer, along with its own frame queue.
+type compositeDetector struct {
+	name     string
+	detector Recognizer
+	frames   chan camera.Frame
+}
+
+// CompositeRecognizer runs several detectors over the frames of a camera.
+// Every frame is offered to each detector without waiting for it, so that
+// a slow detector only misses frames, instead of stalling the others.
 type CompositeRecognizer struct {
 	logger *logrus.Entry
 
-	frameChan <-chan image.Image
-	fr        *HaarDetector
-	cr  	*HaarDetector
+	frameChan <-chan camera.Frame
+	eChans    EventChannels
+	detectors []compositeDetector
+	cameraID  string
 	stopCh    chan struct{}
-
+	done      chan struct{}
 }
 
-func NewCompositeRecognizer(echan EventChannels) *CompositeRecognizer {
-    r := &CompositeRecognizer{
-        stopCh: make(chan struct{}),
-    }
-	r.frameChan = echan.FrameIn
-
-    // Create the channels for each HaarDetector
-    echan.FrameInCopy1 = make(chan image.Image)
-    echan.FrameInCopy2 = make(chan image.Image)
-
-    // Initialize each HaarDetector with its respective channel
-    r.fr= NewHaarDetector(EventChannels{FrameIn: echan.FrameInCopy1, RecogOut: echan.RecogOut})
-    r.cr = NewHaarDetector(EventChannels{FrameIn: echan.FrameInCopy2, RecogOut: echan.RecogOut})
-
-    r.setupLogger()
-
-    // Start the duplicator goroutine
-    go r.duplicateFrames(echan)
+// NewCompositeRecognizer creates a CompositeRecognizer running the given detectors
+// over the frames of a camera.
+func NewCompositeRecognizer(cameraID string, detectors []conf.DetectorConfig, echan EventChannels) (*CompositeRecognizer, error) {
+	r := &CompositeRecognizer{
+		cameraID:  cameraID,
+		frameChan: echan.FrameIn,
+		eChans:    echan,
+		stopCh:    make(chan struct{}),
+	}
+	r.setupLogger()
+
+	// every detector receives the frames through its own queue
+	for _, settings := range detectors {
+		frames := make(chan camera.Frame, detectorQueueSize)
+		d, err := NewDetector(settings, cameraID, EventChannels{FrameIn: frames, RecogOut: echan.RecogOut})
+		if err != nil {
+			return nil, err
+		}
+		r.detectors = append(r.detectors, compositeDetector{name: settings.Name, detector: d, frames: frames})
+	}
 
-    return r
-}
-func (r *CompositeRecognizer) duplicateFrames(echan EventChannels) {
-    for {
-        select {
-        case frame := <-echan.FrameIn:
-            // Send the frame to both HaarDetectors
-            echan.FrameInCopy1 <- frame
-            echan.FrameInCopy2 <- frame
-        case <-r.stopCh:
-            return
-        }
-    }
+	return r, nil
 }
 
+// fanOut offers every incoming frame to each detector.
+func (r *CompositeRecognizer) fanOut() {
+	for {
+		select {
+		case frame := <-r.frameChan:
+			if len(r.detectors) == 0 {
+				frame.Release()
+				continue
+			}
+
+			// each detector releases the frame, once it is done with it
+			for i := 1; i < len(r.detectors); i++ {
+				frame.Retain()
+			}
+			for _, d := range r.detectors {
+				select {
+				case d.frames <- frame:
+				default:
+					r.logger.Debugf("detector %s is busy, dropping frame", d.name)
+					frame.Release()
+				}
+			}
+		case <-r.stopCh:
+			return
+		}
+	}
+}
 
 func (r *CompositeRecognizer) Start() error {
 	// Ensure the recordings directory exists
-	cfg, err := conf.ReadConf()
+	cfg, _ := conf.ReadConf()
 
-	err = helpers.EnsureDirectoryExists(cfg.Recognizer.ThumbsDir)
+	err := helpers.EnsureDirectoryExists(cfg.Recognizer.ThumbsDir)
 	if err != nil {
 		r.logger.Errorf("%v", err)
 		return err
 	}
+
+	for i, d := range r.detectors {
+		err := d.detector.Start()
+		if err != nil {
+			for _, started := range r.detectors[:i] {
+				started.detector.Stop()
+			}
+			return err
+		}
+	}
+
+	r.stopCh = make(chan struct{})
+	r.done = make(chan struct{})
 	go r.view()
 
 	return nil
 }
 
 func (r *CompositeRecognizer) Stop() error {
-	r.fr.Stop()
-	r.cr.Stop()
+	close(r.stopCh)
+	<-r.done
+	for _, d := range r.detectors {
+		d.detector.Stop()
+	}
+	return nil
+}
+
+// Failed returns why a detector stopped working, if any did.
+func (r *CompositeRecognizer) Failed() error {
+	for _, d := range r.detectors {
+		reporter, ok := d.detector.(interface{ Failed() error })
+		if !ok {
+			continue
+		}
+		if err := reporter.Failed(); err != nil {
+			return fmt.Errorf("detector %s: %w", d.name, err)
+		}
+	}
 	return nil
 }
 
+// view fans out the incoming frames to the detectors
+// until the recognizer is stopped
 func (r *CompositeRecognizer) view() error {
-	go r.fr.view()
-	go r.cr.view()
+	defer close(r.done)
+	r.fanOut()
 	return nil
 }
 
 func (r *CompositeRecognizer) setupLogger() {
-	r.logger = BaseLogger.BaseLogger.WithField("package", "composite-recognizer")
+	r.logger = BaseLogger.BaseLogger.WithField("package", "composite-recognizer").WithField("camera", r.cameraID)
 }
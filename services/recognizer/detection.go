@@ -0,0 +1,33 @@
+package recognizer
+
+import "image"
+
+// Detection is something detected in the frame of a RecognizedEvent, stored by the
+// indexer in a table of its own. Its box is normalized to the size of the frame,
+// from 0 to 1, so that clients can draw it over any resolution of the frame.
+// Detectors that have no confidence, such as the motion detector, report 1.
+type Detection struct {
+	ID                uint   `gorm:"primaryKey"`
+	RecognizedEventID uint   `gorm:"index"`
+	Detector          string `gorm:"type:text"` // Name of the detector
+	TrackID           uint64 // Track of the detector the box belongs to
+	Label             string `gorm:"type:text;index"`
+	Confidence        float32
+	X                 float64 // Left of the box
+	Y                 float64 // Top of the box
+	Width             float64
+	Height            float64
+}
+
+// newDetection returns the detection of a box of a frame of the given size.
+func newDetection(label string, confidence float32, box image.Rectangle, size image.Point) Detection {
+	w, h := float64(size.X), float64(size.Y)
+	return Detection{
+		Label:      label,
+		Confidence: confidence,
+		X:          float64(box.Min.X) / w,
+		Y:          float64(box.Min.Y) / h,
+		Width:      float64(box.Dx()) / w,
+		Height:     float64(box.Dy()) / h,
+	}
+}
@@ -0,0 +1,404 @@
+package recognizer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/helpers"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+
+	"github.com/sirupsen/logrus"
+	"gocv.io/x/gocv"
+)
+
+// DNNDetector detects objects with a deep neural network run on the CPU by OpenCV,
+// such as a YOLO or a SSD model, loaded from ONNX, Caffe or TensorFlow files.
+//
+// The layout of the model output is recognized from its shape: SSD models output
+// [1, 1, N, 7] detections, YOLOv5 models [1, N, 5+classes] and YOLOv8 models
+// [1, 4+classes, N]. Only the last output of the model is read.
+type DNNDetector struct {
+	logger *logrus.Entry
+	wg     sync.WaitGroup
+
+	eChans    EventChannels
+	cameraID  string
+	settings  conf.DetectorConfig
+	thumbsDir string
+
+	net     gocv.Net
+	labels  []string
+	allowed map[string]bool // nil when every class is reported
+
+	// inputSize is the size of the model input, that the frames are resized to.
+	// The pixels are multiplied by scale, after the mean is subtracted.
+	inputSize  image.Point
+	scale      float64
+	mean       float64
+	swapRB     bool
+	confidence float64 // default threshold of the classes
+	nms        float32 // IoU above which overlapping boxes of a class are merged
+
+	tracker tracker
+	monitor viewMonitor
+	stopCh  chan struct{}
+}
+
+// dnnDetection is an object detected by a DNNDetector, in the coordinates of the frame.
+type dnnDetection struct {
+	label      string
+	confidence float32
+	box        image.Rectangle
+}
+
+// NewDNNDetector creates a DNNDetector for the frames of the given camera.
+func NewDNNDetector(cameraID string, settings conf.DetectorConfig, eChans EventChannels) *DNNDetector {
+	d := &DNNDetector{
+		eChans:   eChans,
+		cameraID: cameraID,
+		settings: settings,
+		inputSize: image.Pt(
+			int(settings.Param("inputWidth", 640)),
+			int(settings.Param("inputHeight", 640)),
+		),
+		scale:      settings.Param("scale", 1.0/255),
+		mean:       settings.Param("mean", 0),
+		swapRB:     settings.Param("swapRB", 1) != 0,
+		confidence: settings.Param("confidence", 0.5),
+		nms:        float32(settings.Param("nms", 0.45)),
+		tracker:    newTracker(cameraID, settings),
+		stopCh:     make(chan struct{}),
+	}
+	if len(settings.Classes) != 0 {
+		d.allowed = make(map[string]bool, len(settings.Classes))
+		for _, class := range settings.Classes {
+			d.allowed[class] = true
+		}
+	}
+	d.setupLogger()
+
+	return d
+}
+
+func (d *DNNDetector) Start() error {
+	cfg, _ := conf.ReadConf()
+	d.thumbsDir = cfg.Recognizer.ThumbsDir
+	err := helpers.EnsureDirectoryExists(d.thumbsDir)
+	if err != nil {
+		d.logger.Errorf("%v", err)
+		return err
+	}
+
+	if d.settings.Labels != "" {
+		d.labels, err = readLabels(d.settings.Labels)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the model is loaded here, instead of in the view,
+	// so that a bad model path is reported by Start
+	d.net = gocv.ReadNet(d.settings.Model, d.settings.Config)
+	if d.net.Empty() {
+		d.net.Close()
+		return fmt.Errorf("couldn't read the model of detector %s: %v", d.settings.Name, d.settings.Model)
+	}
+	d.net.SetPreferableBackend(gocv.NetBackendOpenCV)
+	d.net.SetPreferableTarget(gocv.NetTargetCPU)
+	d.logger.Infof("loaded model %s, with %d labels", d.settings.Model, len(d.labels))
+
+	d.stopCh = make(chan struct{})
+	d.wg.Add(1)
+	d.monitor.start(d.view, d.stopCh)
+	return nil
+}
+
+func (d *DNNDetector) Stop() error {
+	close(d.stopCh) // signal to stop the view
+	d.wg.Wait()     // Wait for the view to finish
+	return nil
+}
+
+// Failed returns why the view exited, if it did while the detector was not stopped.
+func (d *DNNDetector) Failed() error {
+	return d.monitor.failed()
+}
+
+// sendRecog waits for the event to be taken, as the starts and the ends of the
+// tracks are only sent once, until the detector is stopped.
+func (d *DNNDetector) sendRecog(recog RecognizedEvent) error {
+	select {
+	case d.eChans.RecogOut <- recog:
+		return nil
+	case <-d.stopCh:
+		d.logger.Info("received stop signal")
+		return nil
+	}
+}
+
+func (d *DNNDetector) view() error {
+	defer d.wg.Done()
+	defer d.net.Close()
+
+	green := color.RGBA{0, 255, 0, 0}
+
+	for {
+		select {
+		case frame, ok := <-d.eChans.FrameIn:
+			if !ok {
+				if n := d.tracker.stop(d.eChans.RecogOut); n != 0 {
+					d.logger.Warnf("dropped the end of %d tracks", n)
+				}
+				return nil
+			}
+			if frame.Image == nil {
+				d.logger.Info("nil frame received, continuing...")
+				continue
+			}
+
+			// Convert image.Image to gocv.Mat, along with the main stream
+			// image, if any. The Mats are copies, so the frame is released right away.
+			img, err := gocv.ImageToMatRGB(frame.Image)
+			main, hasMain, mainErr := mainStreamMat(frame)
+			frame.Release()
+			if err != nil {
+				d.logger.Errorf("Error converting image to Mat: %v", err)
+				main.Close()
+				continue
+			}
+			if mainErr != nil {
+				d.logger.Errorf("Error converting main stream image to Mat: %v", mainErr)
+			}
+
+			// objects are followed across frames, and only the frame an object starts
+			// being tracked at is saved and sent, its event being updated afterwards
+			detections := d.detect(img)
+			found := make([]Detection, 0, len(detections))
+			for _, det := range detections {
+				found = append(found, newDetection(det.label, det.confidence, det.box, image.Pt(img.Cols(), img.Rows())))
+			}
+			starts := d.tracker.observe(frame, found)
+			for _, e := range d.tracker.flush(frame) {
+				d.sendRecog(e)
+			}
+			if !starts {
+				img.Close()
+				main.Close()
+				continue
+			}
+
+			// the thumbnail is taken from the main stream, when the frame comes from the substream
+			thumb := img
+			if hasMain {
+				thumb = main
+			}
+			context := make([]string, 0, len(detections))
+			for _, det := range detections {
+				box := det.box
+				if hasMain {
+					box = toMainStream(box, img, main)
+				}
+				text := fmt.Sprintf("%s %.0f%%", det.label, det.confidence*100)
+				gocv.Rectangle(&thumb, box, green, 2)
+				gocv.PutText(&thumb, text, image.Pt(box.Min.X, box.Min.Y-4), gocv.FontHersheyPlain, 1.2, green, 2)
+				context = append(context, text)
+			}
+
+			fname, err := helpers.SaveMatToFile(thumb, d.thumbsDir)
+			img.Close()
+			main.Close()
+			if err != nil {
+				d.logger.Errorf("Error saving file: %v", err)
+				continue
+			}
+			for _, e := range d.tracker.start(frame, fname, strings.Join(context, ", "), found) {
+				d.sendRecog(e)
+			}
+
+		case <-d.stopCh:
+			d.logger.Info("received stop signal")
+			if n := d.tracker.stop(d.eChans.RecogOut); n != 0 {
+				d.logger.Warnf("dropped the end of %d tracks", n)
+			}
+			return nil
+		}
+	}
+}
+
+// detect runs the model over an image, returning the objects of the
+// allowed classes that reach their threshold, after non-maximum suppression.
+func (d *DNNDetector) detect(img gocv.Mat) []dnnDetection {
+	mean := gocv.NewScalar(d.mean, d.mean, d.mean, 0)
+	blob := gocv.BlobFromImage(img, d.scale, d.inputSize, mean, d.swapRB, false)
+	defer blob.Close()
+
+	d.net.SetInput(blob, "")
+	out := d.net.Forward("")
+	defer out.Close()
+
+	data, err := out.DataPtrFloat32()
+	if err != nil {
+		d.logger.Errorf("%v", err)
+		return nil
+	}
+	candidates, err := d.parse(out.Size(), data, img.Cols(), img.Rows())
+	if err != nil {
+		d.logger.Errorf("%v", err)
+		return nil
+	}
+
+	// overlapping boxes are merged within each class
+	byLabel := make(map[string][]dnnDetection)
+	for _, c := range candidates {
+		byLabel[c.label] = append(byLabel[c.label], c)
+	}
+
+	var detections []dnnDetection
+	for _, dets := range byLabel {
+		boxes := make([]image.Rectangle, len(dets))
+		scores := make([]float32, len(dets))
+		for i, det := range dets {
+			boxes[i] = det.box
+			scores[i] = det.confidence
+		}
+		for _, i := range gocv.NMSBoxes(boxes, scores, 0, d.nms) {
+			detections = append(detections, dets[i])
+		}
+	}
+
+	sort.Slice(detections, func(i, j int) bool {
+		return detections[i].confidence > detections[j].confidence
+	})
+	return detections
+}
+
+// parse reads the candidate detections out of the model output, given by its
+// dimensions and values, in the coordinates of a frame of the given size,
+// keeping those that are reported.
+func (d *DNNDetector) parse(dims []int, data []float32, width int, height int) ([]dnnDetection, error) {
+	if n := outputSize(dims); len(data) < n {
+		return nil, fmt.Errorf("output of shape %v has %d values, want %d", dims, len(data), n)
+	}
+
+	var candidates []dnnDetection
+	add := func(class int, confidence float32, box image.Rectangle) {
+		label := d.label(class)
+		if !d.reported(label, confidence) {
+			return
+		}
+		candidates = append(candidates, dnnDetection{
+			label:      label,
+			confidence: confidence,
+			box:        box.Intersect(image.Rect(0, 0, width, height)),
+		})
+	}
+
+	// YOLO boxes are given as center and size, in the coordinates of the model input
+	sx := float32(width) / float32(d.inputSize.X)
+	sy := float32(height) / float32(d.inputSize.Y)
+	yoloBox := func(cx, cy, w, h float32) image.Rectangle {
+		return image.Rect(int((cx-w/2)*sx), int((cy-h/2)*sy), int((cx+w/2)*sx), int((cy+h/2)*sy))
+	}
+
+	switch {
+	// SSD: (image, class, confidence, left, top, right, bottom), normalized
+	case len(dims) == 4 && dims[3] == 7:
+		for i := 0; i < dims[2]; i++ {
+			row := data[i*7 : i*7+7]
+			add(int(row[1]), row[2], image.Rect(
+				int(row[3]*float32(width)), int(row[4]*float32(height)),
+				int(row[5]*float32(width)), int(row[6]*float32(height)),
+			))
+		}
+
+	// YOLOv8: 4+classes rows of N values
+	case len(dims) == 3 && dims[1] > 4 && dims[1] < dims[2]:
+		n := dims[2]
+		for i := 0; i < n; i++ {
+			class, score := -1, float32(0)
+			for c := 0; c < dims[1]-4; c++ {
+				if s := data[(4+c)*n+i]; s > score {
+					class, score = c, s
+				}
+			}
+			if class >= 0 {
+				add(class, score, yoloBox(data[i], data[n+i], data[2*n+i], data[3*n+i]))
+			}
+		}
+
+	// YOLOv5: N rows of 5+classes values, the fifth being the objectness
+	case len(dims) == 3 && dims[2] > 5:
+		stride := dims[2]
+		for i := 0; i < dims[1]; i++ {
+			row := data[i*stride : (i+1)*stride]
+			class, score := -1, float32(0)
+			for c, s := range row[5:] {
+				if s > score {
+					class, score = c, s
+				}
+			}
+			if class >= 0 {
+				add(class, row[4]*score, yoloBox(row[0], row[1], row[2], row[3]))
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported output shape %v", dims)
+	}
+	return candidates, nil
+}
+
+// outputSize returns the number of values of an output of the given dimensions.
+func outputSize(dims []int) int {
+	n := 1
+	for _, dim := range dims {
+		n *= dim
+	}
+	return n
+}
+
+// label returns the name of a class.
+func (d *DNNDetector) label(class int) string {
+	if class >= 0 && class < len(d.labels) {
+		return d.labels[class]
+	}
+	return fmt.Sprintf("class %d", class)
+}
+
+// reported tells if a detection of the given class and confidence is reported.
+func (d *DNNDetector) reported(label string, confidence float32) bool {
+	if d.allowed != nil && !d.allowed[label] {
+		return false
+	}
+	threshold, ok := d.settings.Thresholds[label]
+	if !ok {
+		threshold = d.confidence
+	}
+	return float64(confidence) >= threshold
+}
+
+// readLabels reads a file with the name of a class per line.
+func readLabels(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read labels file: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\r\n"), "\n")
+	labels := make([]string, len(lines))
+	for i, line := range lines {
+		labels[i] = strings.TrimSpace(line)
+	}
+	return labels, nil
+}
+
+func (d *DNNDetector) setupLogger() {
+	d.logger = BaseLogger.BaseLogger.WithField("package", "recognizer").WithField("camera", d.cameraID).
+		WithField("detector", d.settings.Name)
+}
@@ -0,0 +1,219 @@
+package recognizer
+
+import (
+	"image"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+// yoloCandidate is a box of a YOLO output, as center and size in the coordinates of the model input.
+type yoloCandidate struct {
+	cx, cy, w, h float32
+	objectness   float32 // only in the YOLOv5 outputs
+	scores       []float32
+}
+
+// yolov5Output lays the boxes out as rows of 5+classes values.
+func yolov5Output(classes int, boxes ...yoloCandidate) ([]int, []float32) {
+	stride := 5 + classes
+	data := make([]float32, len(boxes)*stride)
+	for i, b := range boxes {
+		copy(data[i*stride:], append([]float32{b.cx, b.cy, b.w, b.h, b.objectness}, b.scores...))
+	}
+	return []int{1, len(boxes), stride}, data
+}
+
+// yolov8Output lays the boxes out as columns of 4+classes values, padded to n boxes.
+func yolov8Output(classes int, n int, boxes ...yoloCandidate) ([]int, []float32) {
+	rows := 4 + classes
+	data := make([]float32, rows*n)
+	for i, b := range boxes {
+		values := append([]float32{b.cx, b.cy, b.w, b.h}, b.scores...)
+		for row, v := range values {
+			data[row*n+i] = v
+		}
+	}
+	return []int{1, rows, n}, data
+}
+
+// ssdOutput lays the detections out as rows of (image, class, confidence, left, top, right, bottom).
+func ssdOutput(rows ...[7]float32) ([]int, []float32) {
+	data := make([]float32, 0, len(rows)*7)
+	for _, row := range rows {
+		data = append(data, row[:]...)
+	}
+	return []int{1, 1, len(rows), 7}, data
+}
+
+func TestDNNDetectorParse(t *testing.T) {
+	labels := []string{"person", "car"}
+
+	// the frames are 1280x720, and the model input 640x640
+	tests := []struct {
+		name     string
+		settings conf.DetectorConfig
+		output   func() ([]int, []float32)
+		want     []dnnDetection
+		wantErr  string
+	}{
+		{
+			name: "ssd",
+			output: func() ([]int, []float32) {
+				return ssdOutput(
+					[7]float32{0, 1, 0.9, 0.1, 0.2, 0.3, 0.4},
+					[7]float32{0, 0, 0.3, 0.5, 0.5, 0.6, 0.6},
+					[7]float32{0, 0, 0.6, 0.9, 0.9, 1.2, 1.1},
+				)
+			},
+			want: []dnnDetection{
+				{"car", 0.9, image.Rect(128, 144, 384, 288)},
+				{"person", 0.6, image.Rect(1152, 648, 1280, 720)},
+			},
+		},
+		{
+			name: "yolov5",
+			output: func() ([]int, []float32) {
+				return yolov5Output(2,
+					yoloCandidate{320, 320, 64, 128, 0.9, []float32{0.1, 0.8}},
+					yoloCandidate{100, 100, 20, 20, 0.5, []float32{0.9, 0.1}},
+				)
+			},
+			want: []dnnDetection{
+				{"car", 0.72, image.Rect(576, 288, 704, 432)},
+			},
+		},
+		{
+			name: "yolov8",
+			output: func() ([]int, []float32) {
+				return yolov8Output(2, 8,
+					yoloCandidate{cx: 320, cy: 320, w: 64, h: 128, scores: []float32{0.1, 0.8}},
+					yoloCandidate{cx: 100, cy: 100, w: 20, h: 20, scores: []float32{0.4, 0.2}},
+					yoloCandidate{cx: 600, cy: 40, w: 100, h: 100, scores: []float32{0.7, 0.6}},
+				)
+			},
+			want: []dnnDetection{
+				{"car", 0.8, image.Rect(576, 288, 704, 432)},
+				{"person", 0.7, image.Rect(1100, 0, 1280, 101)},
+			},
+		},
+		{
+			name:     "class thresholds",
+			settings: conf.DetectorConfig{Thresholds: map[string]float64{"person": 0.2, "car": 0.85}},
+			output: func() ([]int, []float32) {
+				return ssdOutput(
+					[7]float32{0, 1, 0.8, 0.1, 0.1, 0.2, 0.2},
+					[7]float32{0, 0, 0.3, 0.1, 0.1, 0.2, 0.2},
+				)
+			},
+			want: []dnnDetection{
+				{"person", 0.3, image.Rect(128, 72, 256, 144)},
+			},
+		},
+		{
+			name:     "allowed classes",
+			settings: conf.DetectorConfig{Classes: []string{"car", "class 5"}},
+			output: func() ([]int, []float32) {
+				return ssdOutput(
+					[7]float32{0, 0, 0.9, 0.1, 0.1, 0.2, 0.2},
+					[7]float32{0, 1, 0.9, 0.1, 0.1, 0.2, 0.2},
+					[7]float32{0, 5, 0.9, 0.1, 0.1, 0.2, 0.2},
+				)
+			},
+			want: []dnnDetection{
+				{"car", 0.9, image.Rect(128, 72, 256, 144)},
+				{"class 5", 0.9, image.Rect(128, 72, 256, 144)},
+			},
+		},
+		{
+			name: "nothing detected",
+			output: func() ([]int, []float32) {
+				return yolov8Output(2, 8)
+			},
+			want: nil,
+		},
+		{
+			name: "unsupported shape",
+			output: func() ([]int, []float32) {
+				return []int{1, 10}, make([]float32, 10)
+			},
+			wantErr: "unsupported output shape",
+		},
+		{
+			name: "missing values",
+			output: func() ([]int, []float32) {
+				return []int{1, 1, 2, 7}, make([]float32, 7)
+			},
+			wantErr: "has 7 values",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &DNNDetector{
+				settings:   tt.settings,
+				labels:     labels,
+				inputSize:  image.Pt(640, 640),
+				confidence: 0.5,
+			}
+			if len(tt.settings.Classes) != 0 {
+				d.allowed = make(map[string]bool)
+				for _, class := range tt.settings.Classes {
+					d.allowed[class] = true
+				}
+			}
+
+			dims, data := tt.output()
+			got, err := d.parse(dims, data, 1280, 720)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parse() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse() error = %v", err)
+			}
+
+			// confidences are products of float32 values
+			for i := range got {
+				got[i].confidence = float32(int(got[i].confidence*100+0.5)) / 100
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"lines", "person\nbicycle\ncar\n", []string{"person", "bicycle", "car"}},
+		{"windows line endings", "person\r\ncar\r\n", []string{"person", "car"}},
+		{"no trailing newline", "person\ncar", []string{"person", "car"}},
+		{"spaces", " person \ntraffic light\n", []string{"person", "traffic light"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/labels.txt"
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readLabels(path)
+			if err != nil {
+				t.Fatalf("readLabels() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readLabels() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
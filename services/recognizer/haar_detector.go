@@ -21,16 +21,25 @@ type HaarDetector struct {
 	wg     sync.WaitGroup
 
 	eChans EventChannels
+	cameraID string
+	settings conf.DetectorConfig // the defaults of the recognizer settings are used when empty
 	haarPath string
 	thumbsDir  string
 	eventName string
 	frameLabel string
+	minArea int // faces of a smaller area, in pixels of the analyzed frame, are ignored
+	classifier gocv.CascadeClassifier
+	tracker tracker
+	monitor viewMonitor
 	stopCh chan struct{}
 }
 
-func NewHaarDetector(eChans EventChannels) *HaarDetector {
+// NewHaarDetector creates a HaarDetector for the frames of the given camera.
+func NewHaarDetector(cameraID string, eChans EventChannels) *HaarDetector {
 	r := &HaarDetector{
 		eChans: eChans,
+		cameraID: cameraID,
+		tracker: newTracker(cameraID, conf.DetectorConfig{Name: conf.DetectorHaar}),
 		stopCh: make(chan struct{}),
 	}
 	r.setupLogger()
@@ -42,18 +51,36 @@ func (hd *HaarDetector) Start() error {
 	// Ensure the recordings directory exists
 	cfg, _ := conf.ReadConf()
 	hd.haarPath = cfg.Recognizer.HaarPath
+	if hd.settings.Model != "" {
+		hd.haarPath = hd.settings.Model
+	}
 	hd.eventName = cfg.Recognizer.EventName
 	hd.logger.Info("haar path:", hd.haarPath)
 
 	hd.thumbsDir = cfg.Recognizer.ThumbsDir
 	hd.frameLabel = cfg.Recognizer.FrameLabel
+	if hd.settings.Label != "" {
+		hd.eventName = hd.settings.Label
+		hd.frameLabel = hd.settings.Label
+	}
+	hd.minArea = int(hd.settings.Param("minArea", 0))
 	err := helpers.EnsureDirectoryExists(cfg.Recognizer.ThumbsDir)
 	if err != nil {
 		hd.logger.Errorf("%v", err)
 		return err
 	}
+
+	// load classifier to recognize faces. It is loaded here, instead of in
+	// the view, so that a bad haar path is reported by Start
+	hd.classifier = gocv.NewCascadeClassifier()
+	if !hd.classifier.Load(hd.haarPath) {
+		hd.classifier.Close()
+		return fmt.Errorf("couldn't read haar cascading file: %v", hd.haarPath)
+	}
+
+	hd.stopCh = make(chan struct{})
 	hd.wg.Add(1)
-	go hd.view()
+	hd.monitor.start(hd.view, hd.stopCh)
 	return nil
 }
 
@@ -64,6 +91,13 @@ func (r *HaarDetector) Stop() error {
 }
 
 
+// Failed returns why the view exited, if it did while the detector was not stopped.
+func (r *HaarDetector) Failed() error {
+	return r.monitor.failed()
+}
+
+// sendRecog waits for the event to be taken, as the starts and the ends of the
+// tracks are only sent once, until the detector is stopped.
 func (m *HaarDetector) sendRecog(recog RecognizedEvent) error {
 	select {
 	case m.eChans.RecogOut <- recog:
@@ -71,23 +105,15 @@ func (m *HaarDetector) sendRecog(recog RecognizedEvent) error {
 	case <-m.stopCh:
 		m.logger.Info("received stop signal")
 		return nil
-	default:
-		m.logger.Info("buffer is full")
-		return nil
 	}
 }
 
 func (r *HaarDetector) view() error {
 	defer r.wg.Done()
 
-	// load classifier to recognize faces
-	classifier := gocv.NewCascadeClassifier()
+	classifier := r.classifier
 	defer classifier.Close()
 
-	if !classifier.Load(r.haarPath) {
-		fmt.Printf("Error reading cascade file: %v\n", r.haarPath)
-		return fmt.Errorf("couldn't read haar cascading file")
-	}
 	blue := color.RGBA{0, 0, 255, 0}
 
 	img := gocv.NewMat()
@@ -99,23 +125,51 @@ func (r *HaarDetector) view() error {
 		case frame, ok := <-r.eChans.FrameIn:
 			if !ok {
 				// channel was closed and drained, handle the closure, perhaps break the view
-				break
+				if n := r.tracker.stop(r.eChans.RecogOut); n != 0 {
+					r.logger.Warnf("dropped the end of %d tracks", n)
+				}
+				return nil
 			}
-			if frame == nil {
+			if frame.Image == nil {
 				r.logger.Info("nil frame received, continuing...")
 				continue
 			}
-			// Convert image.Image to gocv.Mat.
-			img, err := gocv.ImageToMatRGB(frame)
+			// Convert image.Image to gocv.Mat, along with the main stream
+			// image, if any. The Mats are copies, so the frame is released right away.
+			img, err := gocv.ImageToMatRGB(frame.Image)
+			main, hasMain, mainErr := mainStreamMat(frame)
+			frame.Release()
 
 			if err != nil {
 				r.logger.Errorf("Error converting image to Mat: %v", err)
+				main.Close()
 				continue
 			}
+			if mainErr != nil {
+				r.logger.Errorf("Error converting main stream image to Mat: %v", mainErr)
+			}
 
-			// detect faces
-			rects := classifier.DetectMultiScale(img)
-			// m.logger.Info("detected faces amount: ", len(rects))
+			// detect faces, ignoring the ones that are too small
+			var rects []image.Rectangle
+			var detections []Detection
+			for _, rect := range classifier.DetectMultiScale(img) {
+				if rect.Dx()*rect.Dy() >= r.minArea {
+					rects = append(rects, rect)
+					detections = append(detections, newDetection(r.frameLabel, 1, rect, image.Pt(img.Cols(), img.Rows())))
+				}
+			}
+
+			// faces are followed across frames, and only the frame a face starts
+			// being tracked at is saved and sent, its event being updated afterwards
+			starts := r.tracker.observe(frame, detections)
+			for _, e := range r.tracker.flush(frame) {
+				r.sendRecog(e)
+			}
+			if !starts {
+				img.Close()
+				main.Close()
+				continue
+			}
 
 			// draw a rectangle around each face on the original image,
 			// along with text identifying as "Human"
@@ -126,26 +180,40 @@ func (r *HaarDetector) view() error {
 				gocv.PutText(&img, r.frameLabel, pt, gocv.FontHersheyPlain, 1.2, blue, 2)
 			}
 
-			helpers.SaveMatToFile(img, r.thumbsDir)
+			// the thumbnail is taken from the main stream, when the frame comes from the substream
+			thumb := img
+			if hasMain {
+				for _, rect := range rects {
+					rect = toMainStream(rect, img, main)
+					gocv.Rectangle(&main, rect, blue, 3)
+					pt := image.Pt(rect.Min.X, rect.Min.Y-2)
+					gocv.PutText(&main, r.frameLabel, pt, gocv.FontHersheyPlain, 1.2, blue, 2)
+				}
+				thumb = main
+			}
 
-			fname, err:= helpers.SaveMatToFile(img, r.thumbsDir);
+			fname, err:= helpers.SaveMatToFile(thumb, r.thumbsDir);
+			img.Close()
+			main.Close()
 			if err != nil {
 				r.logger.Errorf("Error saving file: %v", err)
 				continue
 			}
-			r.sendRecog(RecognizedEvent{
-				Path: fname,
-				Context: r.eventName,
-			})
+			for _, e := range r.tracker.start(frame, fname, r.eventName, detections) {
+				r.sendRecog(e)
+			}
 		
 
 		case <-r.stopCh:
 			r.logger.Info("received stop signal")
+			if n := r.tracker.stop(r.eChans.RecogOut); n != 0 {
+				r.logger.Warnf("dropped the end of %d tracks", n)
+			}
 			return nil // Exit the view when stop signal is received.
 		}
 	}
 }
 
 func (m *HaarDetector) setupLogger() {
-	m.logger = BaseLogger.BaseLogger.WithField("package", "recognizer")
+	m.logger = BaseLogger.BaseLogger.WithField("package", "recognizer").WithField("camera", m.cameraID)
 }
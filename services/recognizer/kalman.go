@@ -0,0 +1,58 @@
+package recognizer
+
+const (
+	// kalmanAcceleration is the standard deviation of the acceleration of the
+	// tracked boxes, in frame sizes per second squared, as the boxes are normalized.
+	kalmanAcceleration = 0.5
+	// kalmanMeasurement is the standard deviation of the boxes given by the detectors.
+	kalmanMeasurement = 0.01
+)
+
+// kalman is a constant velocity Kalman filter of a coordinate of a tracked box.
+// Each coordinate of the box has its own filter, as they are independent.
+type kalman struct {
+	x float64       // position
+	v float64       // velocity, per second
+	p [2][2]float64 // covariance of the position and the velocity
+}
+
+// newKalman starts a filter at a measured position, whose velocity is unknown.
+func newKalman(x float64) kalman {
+	return kalman{
+		x: x,
+		p: [2][2]float64{
+			{kalmanMeasurement * kalmanMeasurement, 0},
+			{0, 1},
+		},
+	}
+}
+
+// predict moves the filter dt seconds forward.
+func (k *kalman) predict(dt float64) {
+	k.x += k.v * dt
+
+	// P = F P F' + Q, Q being the noise of a random acceleration over dt
+	q := kalmanAcceleration * kalmanAcceleration
+	p := k.p
+	k.p[0][0] = p[0][0] + dt*(p[0][1]+p[1][0]) + dt*dt*p[1][1] + q*dt*dt*dt*dt/4
+	k.p[0][1] = p[0][1] + dt*p[1][1] + q*dt*dt*dt/2
+	k.p[1][0] = p[1][0] + dt*p[1][1] + q*dt*dt*dt/2
+	k.p[1][1] = p[1][1] + q*dt*dt
+}
+
+// update corrects the filter with a measured position.
+func (k *kalman) update(z float64) {
+	s := k.p[0][0] + kalmanMeasurement*kalmanMeasurement
+	k0 := k.p[0][0] / s
+	k1 := k.p[1][0] / s
+
+	y := z - k.x
+	k.x += k0 * y
+	k.v += k1 * y
+
+	p := k.p
+	k.p[0][0] = (1 - k0) * p[0][0]
+	k.p[0][1] = (1 - k0) * p[0][1]
+	k.p[1][0] = p[1][0] - k1*p[0][0]
+	k.p[1][1] = p[1][1] - k1*p[0][1]
+}
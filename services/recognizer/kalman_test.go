@@ -0,0 +1,59 @@
+package recognizer
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKalman(t *testing.T) {
+	tests := []struct {
+		name     string
+		start    float64
+		velocity float64 // per second
+	}{
+		{"static", 0.5, 0},
+		{"moving right", 0.1, 0.2},
+		{"moving left", 0.9, -0.3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const dt = 0.1
+			k := newKalman(tt.start)
+			x := tt.start
+			for i := 0; i < 20; i++ {
+				x += tt.velocity * dt
+				k.predict(dt)
+				k.update(x)
+			}
+
+			if math.Abs(k.x-x) > 0.01 {
+				t.Errorf("position = %v, want %v", k.x, x)
+			}
+			if math.Abs(k.v-tt.velocity) > 0.02 {
+				t.Errorf("velocity = %v, want %v", k.v, tt.velocity)
+			}
+
+			// without measurements, the box keeps moving at its velocity
+			k.predict(0.5)
+			if want := x + tt.velocity*0.5; math.Abs(k.x-want) > 0.02 {
+				t.Errorf("predicted position = %v, want %v", k.x, want)
+			}
+		})
+	}
+}
+
+func TestKalmanUncertainty(t *testing.T) {
+	k := newKalman(0.5)
+	before := k.p[0][0]
+	k.predict(1)
+	if k.p[0][0] <= before {
+		t.Errorf("predict didn't grow the position variance: %v <= %v", k.p[0][0], before)
+	}
+
+	predicted := k.p[0][0]
+	k.update(0.5)
+	if k.p[0][0] >= predicted {
+		t.Errorf("update didn't shrink the position variance: %v >= %v", k.p[0][0], predicted)
+	}
+}
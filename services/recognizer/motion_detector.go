@@ -19,17 +19,25 @@ type MotionDetector struct {
 
 	MinimumArea int
 	thumbsDir string
+	eventName   string
 	eChans      EventChannels
+	cameraID    string
+	tracker     tracker
+	monitor     viewMonitor
 	stopCh      chan struct{}
 }
 
-func NewMotionDetector(eChans EventChannels) *MotionDetector {
+// NewMotionDetector creates a MotionDetector for the frames of the given camera.
+func NewMotionDetector(cameraID string, eChans EventChannels) *MotionDetector {
 
 	cfg, _ := conf.ReadConf()
 	r := &MotionDetector{
 		eChans:      eChans,
+		cameraID:    cameraID,
 		MinimumArea: 3000,
 		thumbsDir: cfg.Recognizer.ThumbsDir,
+		eventName:   "motion detected",
+		tracker:     newTracker(cameraID, conf.DetectorConfig{Name: conf.DetectorMotion}),
 		stopCh: make(chan struct{}),
 	}
 	r.setupLogger()
@@ -45,8 +53,12 @@ func (m *MotionDetector) Start() error {
 		m.logger.Errorf("%v", err)
 		return err
 	}
+
+	// the stop channel is made again, as a detector is started
+	// again after being stopped when its recognizer is restarted
+	m.stopCh = make(chan struct{})
 	m.wg.Add(1)
-	go m.view()
+	m.monitor.start(m.view, m.stopCh)
 	return nil
 }
 
@@ -56,6 +68,13 @@ func (m *MotionDetector) Stop() error {
 	return nil
 }
 
+// Failed returns why the view exited, if it did while the detector was not stopped.
+func (m *MotionDetector) Failed() error {
+	return m.monitor.failed()
+}
+
+// sendRecog waits for the event to be taken, as the starts and the ends of the
+// tracks are only sent once, until the detector is stopped.
 func (m *MotionDetector) sendRecog(recog RecognizedEvent) error {
 	select {
 	case m.eChans.RecogOut <- recog:
@@ -63,9 +82,6 @@ func (m *MotionDetector) sendRecog(recog RecognizedEvent) error {
 	case <-m.stopCh:
 		m.logger.Info("received stop signal")
 		return nil
-	default:
-		m.logger.Info("buffer is full")
-		return nil
 	}
 }
 
@@ -96,18 +112,28 @@ func (m *MotionDetector) view() error {
 		case frame, ok := <-m.eChans.FrameIn:
 			if !ok {
 				// channel was closed and drained, handle the closure, perhaps break the view
-				break
+				if n := m.tracker.stop(m.eChans.RecogOut); n != 0 {
+					m.logger.Warnf("dropped the end of %d tracks", n)
+				}
+				return nil
 			}
-			if frame == nil {
+			if frame.Image == nil {
 				m.logger.Warn("nil frame received, continuing...")
 				continue
 			}
-			// Convert image.Image to gocv.Mat.
-			img, err := gocv.ImageToMatRGB(frame)
+			// Convert image.Image to gocv.Mat, along with the main stream
+			// image, if any. The Mats are copies, so the frame is released right away.
+			img, err := gocv.ImageToMatRGB(frame.Image)
+			main, hasMain, mainErr := mainStreamMat(frame)
+			frame.Release()
 			if err != nil {
 				m.logger.Errorf("Error converting image to Mat: %v", err)
+				main.Close()
 				continue
 			}
+			if mainErr != nil {
+				m.logger.Errorf("Error converting main stream image to Mat: %v", mainErr)
+			}
 
 			// first phase of cleaning up image, obtain foreground only
 			mog2.Apply(img, &imgDelta)
@@ -123,6 +149,27 @@ func (m *MotionDetector) view() error {
 
 			// now find contours
 			contours := gocv.FindContours(imgThresh, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+			var detections []Detection
+			for i := 0; i < contours.Size(); i++ {
+				if gocv.ContourArea(contours.At(i)) >= float64(m.MinimumArea) {
+					rect := gocv.BoundingRect(contours.At(i))
+					detections = append(detections, newDetection("motion", 1, rect, image.Pt(img.Cols(), img.Rows())))
+				}
+			}
+
+			// moving areas are followed across frames, and only the frame an area starts
+			// being tracked at is saved and sent, its event being updated afterwards
+			starts := m.tracker.observe(frame, detections)
+			for _, e := range m.tracker.flush(frame) {
+				m.sendRecog(e)
+			}
+			if !starts {
+				contours.Close()
+				img.Close()
+				main.Close()
+				continue
+			}
+
 			for i := 0; i < contours.Size(); i++ {
 				area := gocv.ContourArea(contours.At(i))
 				if area < float64(m.MinimumArea) {
@@ -135,29 +182,42 @@ func (m *MotionDetector) view() error {
 
 				rect := gocv.BoundingRect(contours.At(i))
 				gocv.Rectangle(&img, rect, color.RGBA{0, 0, 255, 0}, 2)
+
+				// the thumbnail is taken from the main stream, when the frame comes from the substream
+				if hasMain {
+					gocv.Rectangle(&main, toMainStream(rect, img, main), color.RGBA{0, 0, 255, 0}, 2)
+				}
 			}
 
 			contours.Close()
 
-			gocv.PutText(&img, status, image.Pt(10, 20), gocv.FontHersheyPlain, 1.2, statusColor, 2)
-			fname, err:= helpers.SaveMatToFile(img, m.thumbsDir)
+			thumb := img
+			if hasMain {
+				thumb = main
+			}
+			gocv.PutText(&thumb, status, image.Pt(10, 20), gocv.FontHersheyPlain, 1.2, statusColor, 2)
+			fname, err:= helpers.SaveMatToFile(thumb, m.thumbsDir)
+			img.Close()
+			main.Close()
 			if err != nil {
 				m.logger.Errorf("Error saving file: %v", err)
 				continue
 			}
-			m.sendRecog(RecognizedEvent{
-				Path: fname,
-				Context: "motion detected",
-			})
+			for _, e := range m.tracker.start(frame, fname, m.eventName, detections) {
+				m.sendRecog(e)
+			}
 		
 
 		case <-m.stopCh:
 			m.logger.Info("received stop signal")
+			if n := m.tracker.stop(m.eChans.RecogOut); n != 0 {
+				m.logger.Warnf("dropped the end of %d tracks", n)
+			}
 			return nil // Exit the view when stop signal is received.
 		}
 	}
 }
 
 func (m *MotionDetector) setupLogger() {
-	m.logger = BaseLogger.BaseLogger.WithField("package", "motion-detector")
+	m.logger = BaseLogger.BaseLogger.WithField("package", "motion-detector").WithField("camera", m.cameraID)
 }
@@ -3,8 +3,11 @@
 package recognizer
 
 import (
-	"image"
+	"fmt"
 	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
 )
 
 // Recognizer is an interface for a recognizer component.
@@ -18,13 +21,35 @@ type Recognizer interface {
 	view() error
 }
 
+// NewDetector creates a single detector for the frames of a camera, from its
+// settings. The known types are "haar", "motion" and "dnn".
+func NewDetector(settings conf.DetectorConfig, cameraID string, eChans EventChannels) (Recognizer, error) {
+	switch settings.Type {
+	case conf.DetectorHaar:
+		d := NewHaarDetector(cameraID, eChans)
+		d.settings = settings
+		d.tracker = newTracker(cameraID, settings)
+		return d, nil
+	case conf.DetectorMotion:
+		d := NewMotionDetector(cameraID, eChans)
+		d.MinimumArea = int(settings.Param("minArea", float64(d.MinimumArea)))
+		if settings.Label != "" {
+			d.eventName = settings.Label
+		}
+		d.tracker = newTracker(cameraID, settings)
+		return d, nil
+	case conf.DetectorDNN:
+		return NewDNNDetector(cameraID, settings, eChans), nil
+	default:
+		return nil, fmt.Errorf("detector %q: unknown type %q", settings.Name, settings.Type)
+	}
+}
+
 // EventChannels are channels for communicating with this service.
 type EventChannels struct {
-    FrameIn      <-chan image.Image
-    FrameOut     chan<- image.Image
+    FrameIn      <-chan camera.Frame
+    FrameOut     chan<- camera.Frame
     RecogOut     chan<- RecognizedEvent
-    FrameInCopy1 chan image.Image  // Channel for the first HaarDetector
-    FrameInCopy2 chan image.Image  // Channel for the second HaarDetector
 }
 
 // Config contains all parameters that can be customized
@@ -35,9 +60,46 @@ type Config struct {
 
 // RecognizedEvent is useful to emit events to
 // other components (such as the indexer)
-// after something was detected by the recognition algorithms
+// after something was detected by the recognition algorithms.
+// The frame time and segment fields point to the moment of the
+// recordings the recognized frame was taken from.
+//
+// An event is an object tracked across frames, from the first to the last frame
+// it was detected in. It is sent when the track starts, updated while it lasts
+// and sent a last time when it ends, along with the path of the object, all of
+// them with the same EventID.
 type RecognizedEvent struct {
+	ID        uint      `gorm:"primaryKey"`
+	EventID   string    `gorm:"type:text;index"` // Identifies the updates of an event
+	Detector  string    `gorm:"type:text"` // Name of the detector that recognized it
+	TrackID   uint64    // Track of the detector the event follows
+	Label     string    `gorm:"type:text;index"` // Label of the tracked object
 	Path      string    `gorm:"type:text"` // Thumbnail saved path
+	CameraID  string    `gorm:"type:text;index"` // Camera the recognized frame came from
+	Camera    *camera.Camera `json:",omitempty"`
 	Context      string    `gorm:"type:text"` // Exported by starting with an uppercase letter
+	FrameTime     time.Time // When the recognized frame was captured
+	SegmentPath   string        `gorm:"type:text"` // Recording the frame is in, empty when it was not recorded
+	SegmentOffset time.Duration // Offset of the frame into the recording
+	StartTime     time.Time // First frame of the track
+	EndTime       time.Time // Last frame of the track so far
+	Dwell         time.Duration // Time the object stayed in view, so far
+	Ended         bool      // Whether the track is over
+	Trajectory    []TrackPoint `gorm:"type:jsonb;serializer:json" json:",omitempty"` // Path of the object, once the track is over
+	Detections    []Detection `json:",omitempty"` // Detection of the object in the frame the track started at
+	InView        map[string]int `gorm:"type:jsonb;serializer:json" json:",omitempty"` // Objects detected in that frame by label, the tracked one included
     CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
 }
+
+// newRecognizedEvent returns the event of something recognized into
+// the given frame, whose thumbnail was saved at path.
+func newRecognizedEvent(frame camera.Frame, path string, context string) RecognizedEvent {
+	return RecognizedEvent{
+		Path:          path,
+		CameraID:      frame.CameraID,
+		Context:       context,
+		FrameTime:     frame.Time,
+		SegmentPath:   frame.Segment.Path,
+		SegmentOffset: frame.Segment.Offset,
+	}
+}
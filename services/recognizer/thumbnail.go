@@ -0,0 +1,34 @@
+package recognizer
+
+import (
+	"image"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+
+	"gocv.io/x/gocv"
+)
+
+// mainStreamMat converts the main stream image of a frame that was decoded from the
+// substream of its camera, so that its thumbnail is taken at full resolution.
+// ok is false, and the Mat empty, when the frame has no main stream image.
+func mainStreamMat(frame camera.Frame) (mat gocv.Mat, ok bool, err error) {
+	if frame.Main == nil || frame.Main.Image == nil {
+		return gocv.NewMat(), false, nil
+	}
+	mat, err = gocv.ImageToMatRGB(frame.Main.Image)
+	if err != nil {
+		return gocv.NewMat(), false, err
+	}
+	return mat, true, nil
+}
+
+// toMainStream rescales a rectangle found into the analyzed image
+// into the coordinates of the main stream image.
+func toMainStream(rect image.Rectangle, analyzed gocv.Mat, main gocv.Mat) image.Rectangle {
+	sx := float64(main.Cols()) / float64(analyzed.Cols())
+	sy := float64(main.Rows()) / float64(analyzed.Rows())
+	return image.Rect(
+		int(float64(rect.Min.X)*sx), int(float64(rect.Min.Y)*sy),
+		int(float64(rect.Max.X)*sx), int(float64(rect.Max.Y)*sy),
+	)
+}
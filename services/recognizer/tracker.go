@@ -0,0 +1,343 @@
+package recognizer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+const (
+	// eventUpdateInterval is how often the event of a track is sent again, with its
+	// end time moved forward. It is shorter than the post-roll of event recordings,
+	// that can't be less than conf.MinEventPostRoll, so that they are extended for
+	// as long as the track lasts.
+	eventUpdateInterval = 2 * time.Second
+
+	// trackPointInterval is the minimum time between the points of the path of a track.
+	trackPointInterval = 500 * time.Millisecond
+
+	// stopSendTimeout is how long a stopping detector waits for the ends of its tracks
+	// to be taken, before dropping them, as nothing may read them anymore.
+	stopSendTimeout = 2 * time.Second
+)
+
+// TrackPoint is a point of the path of a tracked object: the center
+// of its box, normalized like the boxes of the detections.
+type TrackPoint struct {
+	Time time.Time
+	X    float64
+	Y    float64
+}
+
+// track is an object followed by a tracker across frames.
+type track struct {
+	id     uint64
+	label  string
+	box    [4]kalman // center, width and height of the box
+	last   time.Time // frame time the filters were moved to
+	first  time.Time // first frame the object was detected in
+	seen   time.Time // last frame the object was detected in
+	path   []TrackPoint
+	event  *RecognizedEvent // nil until the track starts
+	sentAt time.Time        // frame time the event was last sent at
+}
+
+// tracker follows the objects found by a detector across frames, in the manner of
+// SORT: the boxes of each track are predicted by Kalman filters, and matched to the
+// detections of the same label that overlap them the most. A detection that matches
+// no track starts a new one.
+//
+// A track becomes an event once it lasts for the debounce window, and ends once its
+// object isn't detected for the cooldown window. The event is sent when the track
+// starts, then again every eventUpdateInterval while it lasts, and a last time when
+// it ends, with the path of the track. All of them share the same EventID.
+type tracker struct {
+	cameraID string
+	detector string
+	debounce time.Duration
+	cooldown time.Duration
+	minIoU   float64 // detections overlapping a track less are not matched to it
+
+	nextID uint64
+	tracks []*track
+}
+
+// newTracker creates the tracker of a detector, whose "debounce" and "cooldown"
+// parameters are given in seconds, and whose "iou" parameter is the minimum
+// overlap of a detection with a track for it to be matched.
+func newTracker(cameraID string, settings conf.DetectorConfig) tracker {
+	return tracker{
+		cameraID: cameraID,
+		detector: settings.Name,
+		debounce: time.Duration(settings.Param("debounce", 0) * float64(time.Second)),
+		cooldown: time.Duration(settings.Param("cooldown", 5) * float64(time.Second)),
+		minIoU:   settings.Param("iou", 0.3),
+		nextID:   1,
+	}
+}
+
+// observe matches the detections of a frame to the tracks, setting their TrackID.
+// It returns true when a track starts at the frame, that the detector then opens
+// with start, once the thumbnail of the frame is saved.
+func (t *tracker) observe(frame camera.Frame, detections []Detection) bool {
+	for _, tr := range t.tracks {
+		tr.predict(frame.Time)
+	}
+
+	// every pair of a track and a detection of the same label that overlap
+	// enough is a candidate, the pairs overlapping the most being matched first
+	type pair struct {
+		track     int
+		detection int
+		iou       float64
+	}
+	var pairs []pair
+	for i, tr := range t.tracks {
+		for j, d := range detections {
+			if d.Label != tr.label {
+				continue
+			}
+			if iou := boxIoU(tr.detection(), d); iou >= t.minIoU {
+				pairs = append(pairs, pair{i, j, iou})
+			}
+		}
+	}
+	sort.Slice(pairs, func(a, b int) bool {
+		return pairs[a].iou > pairs[b].iou
+	})
+
+	matchedTracks := make([]bool, len(t.tracks))
+	matchedDetections := make([]bool, len(detections))
+	for _, p := range pairs {
+		if matchedTracks[p.track] || matchedDetections[p.detection] {
+			continue
+		}
+		matchedTracks[p.track] = true
+		matchedDetections[p.detection] = true
+
+		tr := t.tracks[p.track]
+		tr.update(frame.Time, detections[p.detection])
+		detections[p.detection].TrackID = tr.id
+	}
+
+	// a track that didn't start yet is forgotten once its object isn't detected
+	// for the cooldown window, like the tracks of events are ended by flush
+	tracks := t.tracks[:0]
+	for i, tr := range t.tracks {
+		if matchedTracks[i] || tr.event != nil || frame.Time.Sub(tr.seen) < t.cooldown {
+			tracks = append(tracks, tr)
+		}
+	}
+	t.tracks = tracks
+
+	for j := range detections {
+		if matchedDetections[j] {
+			continue
+		}
+		tr := newTrack(t.nextID, frame.Time, detections[j])
+		t.nextID++
+		t.tracks = append(t.tracks, tr)
+		detections[j].TrackID = tr.id
+	}
+
+	starts := false
+	for _, tr := range t.tracks {
+		if t.due(tr, frame.Time) {
+			starts = true
+		}
+	}
+	return starts
+}
+
+// due tells if a track starts at a frame.
+func (t *tracker) due(tr *track, now time.Time) bool {
+	return tr.event == nil && tr.seen.Equal(now) && now.Sub(tr.first) >= t.debounce
+}
+
+// start opens the events of the tracks that start at a frame, whose thumbnail was
+// saved at path. Each event gets the detection of its own track, so that the
+// detections of a frame are stored once, with the event of their track, and
+// the count of the detections of the frame by label.
+func (t *tracker) start(frame camera.Frame, path string, context string, detections []Detection) []RecognizedEvent {
+	inView := make(map[string]int)
+	for _, d := range detections {
+		inView[d.Label]++
+	}
+
+	var events []RecognizedEvent
+	for _, tr := range t.tracks {
+		if !t.due(tr, frame.Time) {
+			continue
+		}
+
+		e := newRecognizedEvent(frame, path, context)
+		e.EventID = fmt.Sprintf("%s/%s/%d/%d", t.cameraID, t.detector, tr.first.UnixNano(), tr.id)
+		e.Detector = t.detector
+		e.TrackID = tr.id
+		e.Label = tr.label
+		e.StartTime = tr.first
+		e.EndTime = tr.seen
+		e.Dwell = tr.seen.Sub(tr.first)
+		e.InView = inView
+
+		for _, d := range detections {
+			if d.TrackID == tr.id {
+				d.Detector = t.detector
+				e.Detections = append(e.Detections, d)
+			}
+		}
+
+		events = append(events, e)
+
+		// the updates of the event don't carry the detections, that are only stored once
+		e.Detections = nil
+		tr.event = &e
+		tr.sentAt = frame.Time
+	}
+	return events
+}
+
+// flush returns the updates and the ends of the events of the tracks that are due at a frame.
+func (t *tracker) flush(frame camera.Frame) []RecognizedEvent {
+	var events []RecognizedEvent
+	tracks := t.tracks[:0]
+	for _, tr := range t.tracks {
+		if tr.event == nil {
+			tracks = append(tracks, tr)
+			continue
+		}
+		if frame.Time.Sub(tr.seen) >= t.cooldown {
+			events = append(events, tr.end())
+			continue
+		}
+		tracks = append(tracks, tr)
+
+		if frame.Time.Sub(tr.sentAt) < eventUpdateInterval || !tr.seen.After(tr.event.EndTime) {
+			continue
+		}
+		tr.event.EndTime = tr.seen
+		tr.event.Dwell = tr.seen.Sub(tr.first)
+		tr.sentAt = frame.Time
+		events = append(events, *tr.event)
+	}
+	t.tracks = tracks
+	return events
+}
+
+// stop ends the events of the tracks of a detector that is stopping, and sends them.
+// It waits up to stopSendTimeout for them to be taken, and returns how many were dropped.
+func (t *tracker) stop(out chan<- RecognizedEvent) int {
+	timeout := time.NewTimer(stopSendTimeout)
+	defer timeout.Stop()
+
+	dropped := 0
+	expired := false
+	for _, tr := range t.tracks {
+		if tr.event == nil {
+			continue
+		}
+		e := tr.end()
+		if !expired {
+			select {
+			case out <- e:
+				continue
+			case <-timeout.C:
+				expired = true
+			}
+		}
+		// once the timeout expired, the rest is only sent when there is room for it
+		select {
+		case out <- e:
+		default:
+			dropped++
+		}
+	}
+	t.tracks = nil
+	return dropped
+}
+
+// newTrack starts a track at a detection.
+func newTrack(id uint64, now time.Time, d Detection) *track {
+	tr := &track{
+		id:    id,
+		label: d.Label,
+		box: [4]kalman{
+			newKalman(d.X + d.Width/2),
+			newKalman(d.Y + d.Height/2),
+			newKalman(d.Width),
+			newKalman(d.Height),
+		},
+		last:  now,
+		first: now,
+		seen:  now,
+	}
+	tr.record(now)
+	return tr
+}
+
+// predict moves the box of the track to a frame time.
+func (tr *track) predict(now time.Time) {
+	dt := now.Sub(tr.last).Seconds()
+	if dt <= 0 {
+		return
+	}
+	for i := range tr.box {
+		tr.box[i].predict(dt)
+	}
+	tr.last = now
+}
+
+// update corrects the box of the track with a detection matched to it.
+func (tr *track) update(now time.Time, d Detection) {
+	tr.box[0].update(d.X + d.Width/2)
+	tr.box[1].update(d.Y + d.Height/2)
+	tr.box[2].update(d.Width)
+	tr.box[3].update(d.Height)
+	tr.seen = now
+	tr.record(now)
+}
+
+// record adds the current center of the box to the path, unless the last point is too recent.
+func (tr *track) record(now time.Time) {
+	if n := len(tr.path); n != 0 && now.Sub(tr.path[n-1].Time) < trackPointInterval {
+		return
+	}
+	tr.path = append(tr.path, TrackPoint{Time: now, X: tr.box[0].x, Y: tr.box[1].x})
+}
+
+// detection returns the box of the track, as a detection.
+func (tr *track) detection() Detection {
+	w := math.Max(tr.box[2].x, 0)
+	h := math.Max(tr.box[3].x, 0)
+	return Detection{
+		X:      tr.box[0].x - w/2,
+		Y:      tr.box[1].x - h/2,
+		Width:  w,
+		Height: h,
+	}
+}
+
+// end returns the last event of the track, with its path.
+func (tr *track) end() RecognizedEvent {
+	e := *tr.event
+	e.EndTime = tr.seen
+	e.Dwell = tr.seen.Sub(tr.first)
+	e.Ended = true
+	e.Trajectory = tr.path
+	return e
+}
+
+// boxIoU returns the intersection over union of the boxes of two detections.
+func boxIoU(a Detection, b Detection) float64 {
+	w := math.Min(a.X+a.Width, b.X+b.Width) - math.Max(a.X, b.X)
+	h := math.Min(a.Y+a.Height, b.Y+b.Height) - math.Max(a.Y, b.Y)
+	if w <= 0 || h <= 0 {
+		return 0
+	}
+	inter := w * h
+	return inter / (a.Width*a.Height + b.Width*b.Height - inter)
+}
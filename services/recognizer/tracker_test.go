@@ -0,0 +1,360 @@
+package recognizer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+var trackerEpoch = time.Date(2024, 4, 18, 18, 0, 0, 0, time.UTC)
+
+// testFrame is a frame given to a tracker, at an offset from trackerEpoch.
+type testFrame struct {
+	at         time.Duration
+	detections []Detection
+}
+
+func box(label string, x float64, y float64) Detection {
+	return Detection{Label: label, X: x, Y: y, Width: 0.1, Height: 0.1}
+}
+
+func newTestTracker(params map[string]float64) tracker {
+	return newTracker("cam", conf.DetectorConfig{Name: "objects", Params: params})
+}
+
+// runTracker feeds the frames to a tracker the way the detectors do, returning
+// the TrackID of the detections of each frame, and the events that were sent,
+// as "<kind> <track> <frame offset>".
+func runTracker(tr *tracker, frames []testFrame) ([][]uint64, []string, []RecognizedEvent) {
+	var ids [][]uint64
+	var names []string
+	var events []RecognizedEvent
+	for _, f := range frames {
+		frame := camera.Frame{CameraID: "cam", Time: trackerEpoch.Add(f.at)}
+		detections := append([]Detection(nil), f.detections...)
+
+		starts := tr.observe(frame, detections)
+		var frameIDs []uint64
+		for _, d := range detections {
+			frameIDs = append(frameIDs, d.TrackID)
+		}
+		ids = append(ids, frameIDs)
+
+		for _, e := range tr.flush(frame) {
+			kind := "update"
+			if e.Ended {
+				kind = "end"
+			}
+			names = append(names, fmt.Sprintf("%s %d %v", kind, e.TrackID, f.at))
+			events = append(events, e)
+		}
+		if starts {
+			for _, e := range tr.start(frame, "thumb.jpg", "context", detections) {
+				names = append(names, fmt.Sprintf("start %d %v", e.TrackID, f.at))
+				events = append(events, e)
+			}
+		}
+	}
+	return ids, names, events
+}
+
+// every returns frames from start to end, every step, with the same detections.
+func every(start time.Duration, end time.Duration, step time.Duration, detections ...Detection) []testFrame {
+	var frames []testFrame
+	for at := start; at <= end; at += step {
+		frames = append(frames, testFrame{at, detections})
+	}
+	return frames
+}
+
+func TestBoxIoU(t *testing.T) {
+	tests := []struct {
+		name string
+		a    Detection
+		b    Detection
+		want float64
+	}{
+		{"same box", Detection{X: 0.1, Y: 0.1, Width: 0.2, Height: 0.2}, Detection{X: 0.1, Y: 0.1, Width: 0.2, Height: 0.2}, 1},
+		{"apart", Detection{X: 0, Y: 0, Width: 0.2, Height: 0.2}, Detection{X: 0.5, Y: 0.5, Width: 0.2, Height: 0.2}, 0},
+		{"touching", Detection{X: 0, Y: 0, Width: 0.2, Height: 0.2}, Detection{X: 0.2, Y: 0, Width: 0.2, Height: 0.2}, 0},
+		{"half shifted", Detection{X: 0, Y: 0, Width: 0.2, Height: 0.2}, Detection{X: 0.1, Y: 0, Width: 0.2, Height: 0.2}, 1.0 / 3},
+		{"inside", Detection{X: 0, Y: 0, Width: 0.4, Height: 0.4}, Detection{X: 0.1, Y: 0.1, Width: 0.2, Height: 0.2}, 0.25},
+		{"empty", Detection{X: 0, Y: 0, Width: 0, Height: 0}, Detection{X: 0, Y: 0, Width: 0.2, Height: 0.2}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := boxIoU(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("boxIoU() = %v, want %v", got, tt.want)
+			}
+			if got := boxIoU(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("boxIoU() swapped = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerMatching(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []testFrame
+		want   [][]uint64
+	}{
+		{
+			name: "moving object keeps its track",
+			frames: []testFrame{
+				{0, []Detection{box("person", 0.10, 0.10)}},
+				{100 * time.Millisecond, []Detection{box("person", 0.12, 0.11)}},
+				{200 * time.Millisecond, []Detection{box("person", 0.14, 0.12)}},
+			},
+			want: [][]uint64{{1}, {1}, {1}},
+		},
+		{
+			name: "other label starts a track",
+			frames: []testFrame{
+				{0, []Detection{box("person", 0.10, 0.10)}},
+				{100 * time.Millisecond, []Detection{box("car", 0.10, 0.10)}},
+			},
+			want: [][]uint64{{1}, {2}},
+		},
+		{
+			name: "far detection starts a track",
+			frames: []testFrame{
+				{0, []Detection{box("person", 0.10, 0.10)}},
+				{100 * time.Millisecond, []Detection{box("person", 0.70, 0.70)}},
+			},
+			want: [][]uint64{{1}, {2}},
+		},
+		{
+			name: "objects keep their tracks whatever the order of the detections",
+			frames: []testFrame{
+				{0, []Detection{box("person", 0.10, 0.10), box("person", 0.60, 0.10)}},
+				{100 * time.Millisecond, []Detection{box("person", 0.61, 0.10), box("person", 0.11, 0.10)}},
+				{200 * time.Millisecond, []Detection{box("person", 0.12, 0.10), box("person", 0.62, 0.10)}},
+			},
+			want: [][]uint64{{1, 2}, {2, 1}, {1, 2}},
+		},
+		{
+			name: "the closest detection gets the track",
+			frames: []testFrame{
+				{0, []Detection{box("person", 0.10, 0.10)}},
+				{100 * time.Millisecond, []Detection{box("person", 0.15, 0.10), box("person", 0.11, 0.10)}},
+			},
+			want: [][]uint64{{1}, {2, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(nil)
+			ids, _, _ := runTracker(&tr, tt.frames)
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("track ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerDebounce(t *testing.T) {
+	person := box("person", 0.10, 0.10)
+	tests := []struct {
+		name     string
+		debounce float64
+		frames   []testFrame
+		want     []string
+	}{
+		{
+			name:     "starts right away",
+			debounce: 0,
+			frames:   every(0, time.Second, 500*time.Millisecond, person),
+			want:     []string{"start 1 0s"},
+		},
+		{
+			name:     "starts after the debounce window",
+			debounce: 1,
+			frames:   every(0, 1500*time.Millisecond, 500*time.Millisecond, person),
+			want:     []string{"start 1 1s"},
+		},
+		{
+			name:     "shorter than the debounce window",
+			debounce: 2,
+			frames:   every(0, time.Second, 500*time.Millisecond, person),
+			want:     nil,
+		},
+		{
+			name:     "missed before it starts",
+			debounce: 1,
+			frames: []testFrame{
+				{0, []Detection{person}},
+				{500 * time.Millisecond, nil},
+				{time.Second, []Detection{person}},
+				{1500 * time.Millisecond, []Detection{person}},
+				{2 * time.Second, []Detection{person}},
+			},
+			want: []string{"start 1 1s"},
+		},
+		{
+			name:     "missed for the cooldown window before it starts",
+			debounce: 1,
+			frames: []testFrame{
+				{0, []Detection{person}},
+				{10 * time.Second, nil},
+				{10500 * time.Millisecond, []Detection{person}},
+				{11 * time.Second, []Detection{person}},
+				{11500 * time.Millisecond, []Detection{person}},
+			},
+			want: []string{"start 2 11.5s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(map[string]float64{"debounce": tt.debounce, "cooldown": 10})
+			_, events, _ := runTracker(&tr, tt.frames)
+			if !reflect.DeepEqual(events, tt.want) {
+				t.Errorf("events = %v, want %v", events, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrackerFlush(t *testing.T) {
+	person := box("person", 0.10, 0.10)
+	tests := []struct {
+		name     string
+		cooldown float64
+		frames   []testFrame
+		want     []string
+	}{
+		{
+			name:     "updated while it lasts and ended after the cooldown",
+			cooldown: 2,
+			frames: append(every(0, 3*time.Second, 500*time.Millisecond, person),
+				every(3500*time.Millisecond, 6*time.Second, 500*time.Millisecond)...),
+			want: []string{"start 1 0s", "update 1 2s", "update 1 4s", "end 1 5s"},
+		},
+		{
+			name:     "short cooldown",
+			cooldown: 0.5,
+			frames: append(every(0, 3*time.Second, 500*time.Millisecond, person),
+				every(3500*time.Millisecond, 6*time.Second, 500*time.Millisecond)...),
+			want: []string{"start 1 0s", "update 1 2s", "end 1 3.5s"},
+		},
+		{
+			name:     "not updated when it wasn't seen again",
+			cooldown: 5,
+			frames: append([]testFrame{{0, []Detection{person}}},
+				every(500*time.Millisecond, 6*time.Second, 500*time.Millisecond)...),
+			want: []string{"start 1 0s", "end 1 5s"},
+		},
+		{
+			name:     "detected again within the cooldown",
+			cooldown: 2,
+			frames: []testFrame{
+				{0, []Detection{person}},
+				{time.Second, nil},
+				{1500 * time.Millisecond, []Detection{person}},
+				{3 * time.Second, nil},
+				{3500 * time.Millisecond, nil},
+			},
+			want: []string{"start 1 0s", "update 1 3s", "end 1 3.5s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTestTracker(map[string]float64{"cooldown": tt.cooldown})
+			_, names, events := runTracker(&tr, tt.frames)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("events = %v, want %v", names, tt.want)
+			}
+
+			last := events[len(events)-1]
+			if !last.Ended || len(last.Trajectory) == 0 {
+				t.Errorf("last event isn't ended with a trajectory: %+v", last)
+			}
+			if last.Dwell != last.EndTime.Sub(last.StartTime) {
+				t.Errorf("dwell = %v, want %v", last.Dwell, last.EndTime.Sub(last.StartTime))
+			}
+			for _, e := range events {
+				if e.EventID != events[0].EventID {
+					t.Errorf("event id = %q, want %q", e.EventID, events[0].EventID)
+				}
+			}
+			if len(tr.tracks) != 0 {
+				t.Errorf("%d tracks left after the end", len(tr.tracks))
+			}
+		})
+	}
+}
+
+func TestTrackerStartDetections(t *testing.T) {
+	tr := newTestTracker(nil)
+	_, _, events := runTracker(&tr, []testFrame{
+		{0, []Detection{box("person", 0.10, 0.10), box("person", 0.60, 0.10), box("car", 0.30, 0.60)}},
+	})
+	if len(events) != 3 {
+		t.Fatalf("%d events, want 3", len(events))
+	}
+
+	// each event holds the detection of its own track only
+	for _, e := range events {
+		if len(e.Detections) != 1 {
+			t.Fatalf("event of track %d has %d detections, want 1", e.TrackID, len(e.Detections))
+		}
+		d := e.Detections[0]
+		if d.TrackID != e.TrackID || d.Label != e.Label || d.Detector != "objects" {
+			t.Errorf("event of track %d %q has detection %+v", e.TrackID, e.Label, d)
+		}
+	}
+}
+
+func TestTrackerStartInView(t *testing.T) {
+	person := box("person", 0.10, 0.10)
+	tr := newTestTracker(map[string]float64{"debounce": 1})
+	_, _, events := runTracker(&tr, []testFrame{
+		{0, []Detection{person}},
+		{500 * time.Millisecond, []Detection{person, box("person", 0.60, 0.10)}},
+		{time.Second, []Detection{person, box("person", 0.60, 0.10), box("car", 0.30, 0.60)}},
+	})
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+
+	// the objects in view count the ones that didn't start yet
+	want := map[string]int{"person": 2, "car": 1}
+	if !reflect.DeepEqual(events[0].InView, want) {
+		t.Errorf("InView = %v, want %v", events[0].InView, want)
+	}
+}
+
+func TestTrackerStop(t *testing.T) {
+	tr := newTestTracker(map[string]float64{"debounce": 1})
+	runTracker(&tr, []testFrame{
+		{0, []Detection{box("person", 0.10, 0.10)}},
+		{time.Second, []Detection{box("person", 0.10, 0.10), box("car", 0.60, 0.60)}},
+	})
+
+	// only the started track is ended, the other one was never sent
+	out := make(chan RecognizedEvent, 2)
+	if dropped := tr.stop(out); dropped != 0 {
+		t.Errorf("dropped %d ends", dropped)
+	}
+	close(out)
+
+	var ends []RecognizedEvent
+	for e := range out {
+		ends = append(ends, e)
+	}
+	if len(ends) != 1 || !ends[0].Ended || ends[0].TrackID != 1 {
+		t.Errorf("ends = %+v, want the end of track 1", ends)
+	}
+	if len(tr.tracks) != 0 {
+		t.Errorf("%d tracks left after stopping", len(tr.tracks))
+	}
+}
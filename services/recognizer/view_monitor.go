@@ -0,0 +1,53 @@
+package recognizer
+
+import (
+	"fmt"
+	"sync"
+)
+
+// viewMonitor runs the view of a detector, and records why it exited
+// when it wasn't stopped, so that the detector can report the failure.
+type viewMonitor struct {
+	mu  sync.Mutex
+	err error
+}
+
+// start runs view in a goroutine, clearing the failure of the previous one.
+func (m *viewMonitor) start(view func() error, stopCh <-chan struct{}) {
+	m.setErr(nil)
+	go m.run(view, stopCh)
+}
+
+// run runs view, recovering it from a panic. An exit of the view before
+// stopCh is closed is recorded as a failure.
+func (m *viewMonitor) run(view func() error, stopCh <-chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			m.setErr(fmt.Errorf("view panicked: %v", r))
+		}
+	}()
+
+	err := view()
+	select {
+	case <-stopCh:
+		return
+	default:
+	}
+	if err == nil {
+		err = fmt.Errorf("view exited")
+	}
+	m.setErr(err)
+}
+
+func (m *viewMonitor) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+}
+
+// failed returns why the view exited, or nil while it is running.
+func (m *viewMonitor) failed() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
@@ -0,0 +1,180 @@
+package recorder
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+)
+
+// #cgo pkg-config: libavcodec libavutil
+// #include <libavcodec/avcodec.h>
+// #include <libavutil/channel_layout.h>
+//
+// static void set_mono(AVCodecContext *ctx) {
+// #if LIBAVUTIL_VERSION_INT >= AV_VERSION_INT(57, 28, 100)
+// 	av_channel_layout_default(&ctx->ch_layout, 1);
+// #else
+// 	ctx->channels = 1;
+// 	ctx->channel_layout = AV_CH_LAYOUT_MONO;
+// #endif
+// }
+//
+// static int alloc_frame(AVFrame *frame, AVCodecContext *ctx) {
+// 	frame->format = ctx->sample_fmt;
+// 	frame->nb_samples = ctx->frame_size;
+// #if LIBAVUTIL_VERSION_INT >= AV_VERSION_INT(57, 28, 100)
+// 	int res = av_channel_layout_copy(&frame->ch_layout, &ctx->ch_layout);
+// 	if (res < 0) {
+// 		return res;
+// 	}
+// #else
+// 	frame->channels = ctx->channels;
+// 	frame->channel_layout = ctx->channel_layout;
+// #endif
+// 	return av_frame_get_buffer(frame, 0);
+// }
+import "C"
+
+// maxAudioDrift is how far the PTS of the incoming samples can move away from
+// the PTS computed by counting samples before the encoder timeline is realigned,
+// for instance because some packets were lost.
+const maxAudioDrift = 500 * time.Millisecond
+
+// aacEncoder is a wrapper around FFmpeg's AAC encoder. It is used to transcode
+// audio codecs that can't be stored into MPEG-TS, such as G.711.
+// It encodes mono audio only.
+type aacEncoder struct {
+	codecCtx   *C.AVCodecContext
+	frame      *C.AVFrame
+	pkt        *C.AVPacket
+	sampleRate int
+	frameSize  int
+
+	// samples waiting to fill a frame
+	samples []float32
+
+	// startPTS is the PTS of the first encoded sample,
+	// and count the number of samples sent to the encoder.
+	started  bool
+	startPTS time.Duration
+	count    int64
+}
+
+// newAACEncoder allocates a new aacEncoder.
+func newAACEncoder(sampleRate int) (*aacEncoder, error) {
+	codec := C.avcodec_find_encoder(C.AV_CODEC_ID_AAC)
+	if codec == nil {
+		return nil, fmt.Errorf("avcodec_find_encoder() failed")
+	}
+
+	codecCtx := C.avcodec_alloc_context3(codec)
+	if codecCtx == nil {
+		return nil, fmt.Errorf("avcodec_alloc_context3() failed")
+	}
+
+	codecCtx.sample_fmt = C.AV_SAMPLE_FMT_FLTP
+	codecCtx.sample_rate = C.int(sampleRate)
+	codecCtx.bit_rate = 32000
+	codecCtx.time_base = C.AVRational{num: 1, den: C.int(sampleRate)}
+	C.set_mono(codecCtx)
+
+	res := C.avcodec_open2(codecCtx, codec, nil)
+	if res < 0 {
+		C.avcodec_free_context(&codecCtx)
+		return nil, fmt.Errorf("avcodec_open2() failed")
+	}
+
+	frame := C.av_frame_alloc()
+	if frame == nil {
+		C.avcodec_free_context(&codecCtx)
+		return nil, fmt.Errorf("av_frame_alloc() failed")
+	}
+
+	res = C.alloc_frame(frame, codecCtx)
+	if res < 0 {
+		C.av_frame_free(&frame)
+		C.avcodec_free_context(&codecCtx)
+		return nil, fmt.Errorf("av_frame_get_buffer() failed")
+	}
+
+	pkt := C.av_packet_alloc()
+	if pkt == nil {
+		C.av_frame_free(&frame)
+		C.avcodec_free_context(&codecCtx)
+		return nil, fmt.Errorf("av_packet_alloc() failed")
+	}
+
+	return &aacEncoder{
+		codecCtx:   codecCtx,
+		frame:      frame,
+		pkt:        pkt,
+		sampleRate: sampleRate,
+		frameSize:  int(codecCtx.frame_size),
+	}, nil
+}
+
+// close closes the encoder.
+func (e *aacEncoder) close() {
+	C.av_packet_free(&e.pkt)
+	C.av_frame_free(&e.frame)
+	C.avcodec_free_context(&e.codecCtx)
+}
+
+func (e *aacEncoder) samplesDuration(n int64) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(e.sampleRate)
+}
+
+// encode sends PCM samples, whose first one has the given PTS, to the encoder.
+// It returns the AAC access units that are ready, if any, and the PTS of the first one.
+func (e *aacEncoder) encode(samples []int16, pts time.Duration) ([][]byte, time.Duration, error) {
+	if !e.started {
+		e.started = true
+		e.startPTS = pts
+	} else {
+		expected := e.startPTS + e.samplesDuration(e.count+int64(len(e.samples)))
+		drift := pts - expected
+		if drift > maxAudioDrift || drift < -maxAudioDrift {
+			e.startPTS += drift
+		}
+	}
+
+	for _, s := range samples {
+		e.samples = append(e.samples, float32(s)/32768)
+	}
+
+	var aus [][]byte
+	var firstPTS time.Duration
+
+	for len(e.samples) >= e.frameSize {
+		res := C.av_frame_make_writable(e.frame)
+		if res < 0 {
+			return nil, 0, fmt.Errorf("av_frame_make_writable() failed")
+		}
+
+		dst := unsafe.Slice((*float32)(unsafe.Pointer(e.frame.data[0])), e.frameSize)
+		copy(dst, e.samples[:e.frameSize])
+		e.samples = e.samples[e.frameSize:]
+
+		e.frame.pts = C.int64_t(e.count)
+		e.count += int64(e.frameSize)
+
+		res = C.avcodec_send_frame(e.codecCtx, e.frame)
+		if res < 0 {
+			return nil, 0, fmt.Errorf("avcodec_send_frame() failed")
+		}
+
+		// receive every packet that is available
+		for C.avcodec_receive_packet(e.codecCtx, e.pkt) >= 0 {
+			// skip the priming samples that the encoder places before the first one
+			if e.pkt.pts >= 0 {
+				if aus == nil {
+					firstPTS = e.startPTS + e.samplesDuration(int64(e.pkt.pts))
+				}
+				aus = append(aus, C.GoBytes(unsafe.Pointer(e.pkt.data), e.pkt.size))
+			}
+			C.av_packet_unref(e.pkt)
+		}
+	}
+
+	return aus, firstPTS, nil
+}
@@ -0,0 +1,309 @@
+package recorder
+
+import (
+	"errors"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpmpeg4audio"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtpsimpleaudio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/bluenviron/mediacommon/pkg/codecs/opus"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pion/rtp"
+)
+
+// errNoAudio is returned when a feed doesn't offer any supported audio codec.
+var errNoAudio = errors.New("the feed offers neither AAC, Opus nor G711 audio")
+
+// audioCodec handles what is specific to each of the audio codecs the recorder
+// supports. Like videoCodec, each recording session must use its own.
+type audioCodec interface {
+	// name returns a human readable name of the codec.
+	name() string
+
+	// decodeRTP extracts audio frames from a RTP packet.
+	// It returns nil, without error, while more packets are needed.
+	decodeRTP(pkt *rtp.Packet) ([][]byte, error)
+
+	// prepare converts audio frames, the first one with the given PTS, into access
+	// units that can be muxed, and returns the PTS of the first of them.
+	// It returns nil, without error, while no access unit is ready.
+	prepare(frames [][]byte, pts time.Duration) ([][]byte, time.Duration, error)
+
+	// duration returns the duration of a prepared access unit.
+	duration(au []byte) time.Duration
+
+	// sampleRate returns the sample rate of the prepared access units.
+	sampleRate() int
+
+	// mpegtsCodec returns the codec of the MPEG-TS track.
+	mpegtsCodec() mpegts.Codec
+
+	// writeMPEGTS writes prepared access units, the first
+	// one with the given PTS, into a MPEG-TS track.
+	writeMPEGTS(w *mpegts.Writer, track *mpegts.Track, pts time.Duration, aus [][]byte) error
+
+	// fmp4Codec returns the codec of the fMP4 track.
+	fmp4Codec() fmp4.Codec
+
+	// close frees the resources of the codec.
+	close()
+}
+
+// findAudioCodec looks for the first audio format offered by the
+// feed that can be recorded, or returns errNoAudio.
+func findAudioCodec(desc *description.Session) (*description.Media, format.Format, audioCodec, error) {
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma := forma.(type) {
+			case *format.MPEG4Audio:
+				config := forma.GetConfig()
+				if config == nil {
+					continue
+				}
+				rtpDec, err := forma.CreateDecoder()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				return medi, forma, &aacCodec{rtpDec: rtpDec, config: config}, nil
+
+			case *format.Opus:
+				rtpDec, err := forma.CreateDecoder()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				channelCount := 1
+				if forma.IsStereo {
+					channelCount = 2
+				}
+				return medi, forma, &opusCodec{rtpDec: rtpDec, channelCount: channelCount}, nil
+
+			case *format.G711:
+				rtpDec, err := forma.CreateDecoder()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				enc, err := newAACEncoder(forma.ClockRate())
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				return medi, forma, &g711Codec{rtpDec: rtpDec, muLaw: forma.MULaw, enc: enc}, nil
+			}
+		}
+	}
+	return nil, nil, nil, errNoAudio
+}
+
+// aacCodec is the audioCodec of MPEG-4 Audio (AAC) streams.
+type aacCodec struct {
+	rtpDec *rtpmpeg4audio.Decoder
+	config *mpeg4audio.Config
+}
+
+func (c *aacCodec) name() string {
+	return "AAC"
+}
+
+func (c *aacCodec) decodeRTP(pkt *rtp.Packet) ([][]byte, error) {
+	aus, err := c.rtpDec.Decode(pkt)
+	if err == rtpmpeg4audio.ErrMorePacketsNeeded {
+		return nil, nil
+	}
+	return aus, err
+}
+
+func (c *aacCodec) prepare(frames [][]byte, pts time.Duration) ([][]byte, time.Duration, error) {
+	return frames, pts, nil
+}
+
+func (c *aacCodec) duration(_ []byte) time.Duration {
+	return aacDuration(c.config.SampleRate)
+}
+
+func (c *aacCodec) sampleRate() int {
+	return c.config.SampleRate
+}
+
+func (c *aacCodec) mpegtsCodec() mpegts.Codec {
+	return &mpegts.CodecMPEG4Audio{Config: *c.config}
+}
+
+func (c *aacCodec) writeMPEGTS(w *mpegts.Writer, track *mpegts.Track, pts time.Duration, aus [][]byte) error {
+	return w.WriteMPEG4Audio(track, durationGoToMPEGTS(pts), aus)
+}
+
+func (c *aacCodec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecMPEG4Audio{Config: *c.config}
+}
+
+func (c *aacCodec) close() {}
+
+// opusCodec is the audioCodec of Opus streams.
+type opusCodec struct {
+	rtpDec       *rtpsimpleaudio.Decoder
+	channelCount int
+}
+
+func (c *opusCodec) name() string {
+	return "Opus"
+}
+
+func (c *opusCodec) decodeRTP(pkt *rtp.Packet) ([][]byte, error) {
+	packet, err := c.rtpDec.Decode(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{packet}, nil
+}
+
+func (c *opusCodec) prepare(frames [][]byte, pts time.Duration) ([][]byte, time.Duration, error) {
+	return frames, pts, nil
+}
+
+func (c *opusCodec) duration(au []byte) time.Duration {
+	return opus.PacketDuration(au)
+}
+
+func (c *opusCodec) sampleRate() int {
+	return 48000
+}
+
+func (c *opusCodec) mpegtsCodec() mpegts.Codec {
+	return &mpegts.CodecOpus{ChannelCount: c.channelCount}
+}
+
+func (c *opusCodec) writeMPEGTS(w *mpegts.Writer, track *mpegts.Track, pts time.Duration, aus [][]byte) error {
+	return w.WriteOpus(track, durationGoToMPEGTS(pts), aus)
+}
+
+func (c *opusCodec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecOpus{ChannelCount: c.channelCount}
+}
+
+func (c *opusCodec) close() {}
+
+// g711Codec is the audioCodec of G711 streams. MPEG-TS can't carry G711,
+// so the samples are transcoded to AAC before being recorded.
+type g711Codec struct {
+	rtpDec *rtpsimpleaudio.Decoder
+	muLaw  bool
+	enc    *aacEncoder
+}
+
+func (c *g711Codec) name() string {
+	if c.muLaw {
+		return "G711 mu-law (transcoded to AAC)"
+	}
+	return "G711 A-law (transcoded to AAC)"
+}
+
+func (c *g711Codec) decodeRTP(pkt *rtp.Packet) ([][]byte, error) {
+	frame, err := c.rtpDec.Decode(pkt)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{frame}, nil
+}
+
+// prepare transcodes G711 frames into AAC access units.
+func (c *g711Codec) prepare(frames [][]byte, pts time.Duration) ([][]byte, time.Duration, error) {
+	var aus [][]byte
+	var ausPTS time.Duration
+
+	for _, frame := range frames {
+		samples := make([]int16, len(frame))
+		for i, b := range frame {
+			if c.muLaw {
+				samples[i] = muLawToLinear(b)
+			} else {
+				samples[i] = aLawToLinear(b)
+			}
+		}
+
+		frameAUs, frameAUsPTS, err := c.enc.encode(samples, pts)
+		if err != nil {
+			return nil, 0, err
+		}
+		if aus == nil {
+			ausPTS = frameAUsPTS
+		}
+		aus = append(aus, frameAUs...)
+
+		pts += c.enc.samplesDuration(int64(len(frame)))
+	}
+
+	return aus, ausPTS, nil
+}
+
+func (c *g711Codec) duration(_ []byte) time.Duration {
+	return aacDuration(c.enc.sampleRate)
+}
+
+func (c *g711Codec) sampleRate() int {
+	return c.enc.sampleRate
+}
+
+func (c *g711Codec) config() mpeg4audio.Config {
+	return mpeg4audio.Config{
+		Type:         mpeg4audio.ObjectTypeAACLC,
+		SampleRate:   c.enc.sampleRate,
+		ChannelCount: 1,
+	}
+}
+
+func (c *g711Codec) mpegtsCodec() mpegts.Codec {
+	return &mpegts.CodecMPEG4Audio{Config: c.config()}
+}
+
+func (c *g711Codec) writeMPEGTS(w *mpegts.Writer, track *mpegts.Track, pts time.Duration, aus [][]byte) error {
+	return w.WriteMPEG4Audio(track, durationGoToMPEGTS(pts), aus)
+}
+
+func (c *g711Codec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecMPEG4Audio{Config: c.config()}
+}
+
+func (c *g711Codec) close() {
+	c.enc.close()
+}
+
+// aacDuration returns the duration of an AAC access unit.
+func aacDuration(sampleRate int) time.Duration {
+	return time.Duration(mpeg4audio.SamplesPerAccessUnit) * time.Second / time.Duration(sampleRate)
+}
+
+// muLawToLinear converts a mu-law sample into a 16 bit linear PCM sample.
+// Specification: ITU-T G.711
+func muLawToLinear(u byte) int16 {
+	u = ^u
+	t := (int32(u&0x0f) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return int16(0x84 - t)
+	}
+	return int16(t - 0x84)
+}
+
+// aLawToLinear converts an A-law sample into a 16 bit linear PCM sample.
+// Specification: ITU-T G.711
+func aLawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int32(a&0x0f) << 4
+	seg := (a & 0x70) >> 4
+	switch seg {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= seg - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
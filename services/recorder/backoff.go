@@ -0,0 +1,68 @@
+package recorder
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+// backoff computes the delays between reconnection attempts. Each delay grows
+// exponentially up to a maximum, and is randomized by a jitter so that many
+// cameras dropping at once don't reconnect all at the same time.
+type backoff struct {
+	initialDelay time.Duration
+	maxDelay     time.Duration
+	multiplier   float64
+	jitter       float64
+
+	current time.Duration
+}
+
+// newBackoff creates a backoff from the reconnect configuration,
+// using sensible defaults for the values that are not set.
+func newBackoff(cfg conf.ReconnectConfig) *backoff {
+	b := &backoff{
+		initialDelay: time.Duration(cfg.InitialDelay) * time.Second,
+		maxDelay:     time.Duration(cfg.MaxDelay) * time.Second,
+		multiplier:   cfg.Multiplier,
+		jitter:       cfg.Jitter,
+	}
+	if b.initialDelay <= 0 {
+		b.initialDelay = time.Second
+	}
+	if b.maxDelay < b.initialDelay {
+		b.maxDelay = 60 * time.Second
+		if b.maxDelay < b.initialDelay {
+			b.maxDelay = b.initialDelay
+		}
+	}
+	if b.multiplier < 1 {
+		b.multiplier = 2
+	}
+	if b.jitter < 0 || b.jitter > 1 {
+		b.jitter = 0.2
+	}
+	return b
+}
+
+// next returns the delay to wait before the next attempt.
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initialDelay
+	} else {
+		b.current = time.Duration(float64(b.current) * b.multiplier)
+		if b.current > b.maxDelay {
+			b.current = b.maxDelay
+		}
+	}
+
+	// spread the delay over [current - jitter, current + jitter]
+	spread := float64(b.current) * b.jitter
+	return b.current + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// reset makes the next delay start over from the initial delay.
+func (b *backoff) reset() {
+	b.current = 0
+}
@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+func TestBackoffNext(t *testing.T) {
+	s := time.Second
+
+	tests := []struct {
+		name string
+		cfg  conf.ReconnectConfig
+		want []time.Duration
+	}{
+		{"defaults", conf.ReconnectConfig{}, []time.Duration{s, 2 * s, 4 * s, 8 * s, 16 * s, 32 * s, 60 * s, 60 * s}},
+		{"configured", conf.ReconnectConfig{InitialDelay: 2, MaxDelay: 10, Multiplier: 3}, []time.Duration{2 * s, 6 * s, 10 * s, 10 * s}},
+		{"max below initial", conf.ReconnectConfig{InitialDelay: 5, MaxDelay: 2}, []time.Duration{5 * s, 10 * s, 20 * s, 40 * s, 60 * s}},
+		{"initial above default max", conf.ReconnectConfig{InitialDelay: 120, MaxDelay: 10}, []time.Duration{120 * s, 120 * s}},
+		{"multiplier below one", conf.ReconnectConfig{InitialDelay: 1, MaxDelay: 10, Multiplier: 0.5}, []time.Duration{s, 2 * s, 4 * s}},
+		{"constant", conf.ReconnectConfig{InitialDelay: 3, MaxDelay: 3, Multiplier: 1}, []time.Duration{3 * s, 3 * s, 3 * s}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.cfg)
+			var got []time.Duration
+			for range tt.want {
+				got = append(got, b.next())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delays = %v, want %v", got, tt.want)
+			}
+
+			b.reset()
+			if d := b.next(); d != tt.want[0] {
+				t.Errorf("delay after reset = %v, want %v", d, tt.want[0])
+			}
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name       string
+		jitter     float64
+		wantJitter float64
+	}{
+		{"none", 0, 0},
+		{"half", 0.5, 0.5},
+		{"negative", -1, 0.2},
+		{"above one", 1.5, 0.2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(conf.ReconnectConfig{InitialDelay: 10, MaxDelay: 10, Jitter: tt.jitter})
+			if b.jitter != tt.wantJitter {
+				t.Fatalf("jitter = %v, want %v", b.jitter, tt.wantJitter)
+			}
+
+			spread := time.Duration(float64(10*time.Second) * tt.wantJitter)
+			for i := 0; i < 100; i++ {
+				if d := b.next(); d < 10*time.Second-spread || d > 10*time.Second+spread {
+					t.Fatalf("delay %v out of 10s ± %v", d, spread)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package recorder
+
+import (
+	"errors"
+	"image"
+	"sync"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+
+	"github.com/sirupsen/logrus"
+)
+
+// feed is what the recorders that connect to a camera share: it keeps the feed
+// recorded, reports when it goes down and comes back up, and sends the frames.
+// Recorders embed it, and set the logger and the backoff before supervising.
+type feed struct {
+	camera  conf.CameraConfig
+	logger  *logrus.Entry
+	backoff *backoff
+
+	// feedDown tells if the feed was reported down.
+	// It is only used by the supervise goroutine.
+	feedDown bool
+
+	// feedErr is the error that brought the feed down, while it is down.
+	feedErrMu sync.Mutex
+	feedErr   error
+
+	wg sync.WaitGroup
+
+	eChans EventChannels
+	stopCh chan struct{}
+}
+
+func newFeed(camera conf.CameraConfig, eChans EventChannels) feed {
+	return feed{
+		camera: camera,
+		eChans: eChans,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// supervise keeps the feed recorded. Every time a recording session ends
+// because of an error, the feed is reported as down and a new session is
+// started after a backoff delay, until the recorder is stopped.
+func (r *feed) supervise(record func() error) {
+	defer r.wg.Done()
+
+	for {
+		err := record()
+
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		if err == nil {
+			err = errors.New("stream ended")
+		}
+		// errors of the client can contain the stream URL, along with its credentials
+		err = errors.New(r.camera.Redact(err.Error()))
+		r.setFeedErr(err)
+		if !r.feedDown {
+			r.feedDown = true
+			r.sendFeedEvent(FeedEvent{CameraID: r.camera.ID, Status: FeedDown, Reason: err.Error()})
+		}
+
+		delay := r.backoff.next()
+		r.logger.Errorf("feed down: %v. Reconnecting in %v", err, delay.Round(time.Millisecond))
+
+		select {
+		case <-time.After(delay):
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+// feedPlaying is called by record once the feed starts playing. It reports
+// the feed as up again if it was down, and resets the reconnection delays.
+func (r *feed) feedPlaying() {
+	r.backoff.reset()
+	r.setFeedErr(nil)
+	if r.feedDown {
+		r.feedDown = false
+		r.logger.Info("feed up")
+		r.sendFeedEvent(FeedEvent{CameraID: r.camera.ID, Status: FeedUp})
+	}
+}
+
+func (r *feed) setFeedErr(err error) {
+	r.feedErrMu.Lock()
+	defer r.feedErrMu.Unlock()
+	r.feedErr = err
+}
+
+// Healthy returns the error that brought the feed down, or nil while it is being recorded.
+func (r *feed) Healthy() error {
+	r.feedErrMu.Lock()
+	defer r.feedErrMu.Unlock()
+	return r.feedErr
+}
+
+func (r *feed) sendFeedEvent(event FeedEvent) {
+	if r.eChans.FeedOut == nil {
+		return
+	}
+	select {
+	case r.eChans.FeedOut <- event:
+	case <-r.stopCh:
+	}
+}
+
+// newFrame wraps an image decoded from the video access unit of the given PTS
+// into a Frame of the camera. pool is the pool of the image, if any.
+func (r *feed) newFrame(img image.Image, pool *camera.FramePool, pts time.Duration, keyframe bool,
+	segment camera.SegmentRef) camera.Frame {
+	return camera.Frame{
+		Image:    img,
+		Pool:     pool,
+		CameraID: r.camera.ID,
+		PTS:      pts,
+		Time:     time.Now(),
+		Keyframe: keyframe,
+		Segment:  segment,
+	}
+}
+
+// sendFrame sends a frame to the recognizers. The frame is dropped,
+// and released, when they are not keeping up.
+func (r *feed) sendFrame(frame camera.Frame) error {
+	select {
+	case r.eChans.FrameOut <- frame:
+		return nil
+	case <-r.stopCh:
+		frame.Release()
+		r.logger.Info("received stop signal")
+		return nil
+	default:
+		frame.Release()
+		r.logger.Info("buffer is full")
+		return nil
+	}
+}
@@ -0,0 +1,230 @@
+package recorder
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"unsafe"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+)
+
+// #cgo pkg-config: libavcodec libavutil libswscale
+// #include <stdlib.h>
+// #include <libavcodec/avcodec.h>
+// #include <libavutil/imgutils.h>
+// #include <libswscale/swscale.h>
+import "C"
+
+func frameData(frame *C.AVFrame) **C.uint8_t {
+	return (**C.uint8_t)(unsafe.Pointer(&frame.data[0]))
+}
+
+func frameLineSize(frame *C.AVFrame) *C.int {
+	return (*C.int)(unsafe.Pointer(&frame.linesize[0]))
+}
+
+// framePoolSize is how many images the frame pool of a decoder holds, in use or released.
+// It covers the frames buffered between the recorder and the recognizers, the frames
+// decoded while they are all in use being dropped.
+const framePoolSize = 16
+
+// frameDecoder decodes the NALUs of a video stream into frames.
+type frameDecoder interface {
+	decode(nalu []byte) (image.Image, error)
+
+	// skip decodes a NALU whose frame is not needed, such as a frame
+	// the next ones depend on, without converting it.
+	skip(nalu []byte)
+
+	// framePool returns the pool the decoded images belong to,
+	// or nil when they don't need to be released.
+	framePool() *camera.FramePool
+	close()
+}
+
+// ffmpegDecoder is a wrapper around a FFmpeg video decoder that converts
+// the decoded frames to RGBA. It is fed with NALUs, one at a time.
+//
+// FFmpeg converts every frame into the same buffer, so each frame is copied
+// into an image of the frame pool, that stays valid until it is released.
+//
+// Frames bigger than maxSize are scaled down by the conversion, keeping their aspect ratio.
+type ffmpegDecoder struct {
+	codecCtx    *C.AVCodecContext
+	srcFrame    *C.AVFrame
+	swsCtx      *C.struct_SwsContext
+	dstFrame    *C.AVFrame
+	dstFramePtr []uint8
+	frames      *camera.FramePool
+	maxSize     image.Point
+
+	// srcWidth and srcHeight are the size of the decoded frames the conversion was set up for
+	srcWidth  C.int
+	srcHeight C.int
+}
+
+// newFFmpegDecoder allocates a new ffmpegDecoder for the FFmpeg decoder with the given
+// name, such as "h264" or "hevc", whose frames are scaled down to fit into maxSize.
+func newFFmpegDecoder(name string, maxSize image.Point) (*ffmpegDecoder, error) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+
+	codec := C.avcodec_find_decoder_by_name(cname)
+	if codec == nil {
+		return nil, fmt.Errorf("avcodec_find_decoder_by_name(%s) failed", name)
+	}
+
+	codecCtx := C.avcodec_alloc_context3(codec)
+	if codecCtx == nil {
+		return nil, fmt.Errorf("avcodec_alloc_context3() failed")
+	}
+
+	res := C.avcodec_open2(codecCtx, codec, nil)
+	if res < 0 {
+		C.avcodec_close(codecCtx)
+		return nil, fmt.Errorf("avcodec_open2() failed")
+	}
+
+	srcFrame := C.av_frame_alloc()
+	if srcFrame == nil {
+		C.avcodec_close(codecCtx)
+		return nil, fmt.Errorf("av_frame_alloc() failed")
+	}
+
+	return &ffmpegDecoder{
+		codecCtx: codecCtx,
+		srcFrame: srcFrame,
+		frames:   camera.NewFramePool(framePoolSize),
+		maxSize:  maxSize,
+	}, nil
+}
+
+// close closes the decoder.
+func (d *ffmpegDecoder) close() {
+	if d.dstFrame != nil {
+		C.av_frame_free(&d.dstFrame)
+	}
+
+	if d.swsCtx != nil {
+		C.sws_freeContext(d.swsCtx)
+		d.swsCtx = nil
+	}
+
+	C.av_frame_free(&d.srcFrame)
+	C.avcodec_close(d.codecCtx)
+}
+
+// scaledSize returns the size frames are converted to, that fits into maxSize.
+func (d *ffmpegDecoder) scaledSize(width int, height int) (int, int) {
+	scale := 1.0
+	if d.maxSize.X > 0 && width > d.maxSize.X {
+		scale = float64(d.maxSize.X) / float64(width)
+	}
+	if d.maxSize.Y > 0 && height > d.maxSize.Y {
+		scale = math.Min(scale, float64(d.maxSize.Y)/float64(height))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return int(math.Max(1, float64(width)*scale)), int(math.Max(1, float64(height)*scale))
+}
+
+// receive sends a NALU to the decoder, and tells if a frame was received.
+func (d *ffmpegDecoder) receive(nalu []byte) bool {
+	nalu = append([]uint8{0x00, 0x00, 0x00, 0x01}, []uint8(nalu)...)
+
+	// send NALU to decoder
+	var avPacket C.AVPacket
+	avPacket.data = (*C.uint8_t)(C.CBytes(nalu))
+	defer C.free(unsafe.Pointer(avPacket.data))
+	avPacket.size = C.int(len(nalu))
+	res := C.avcodec_send_packet(d.codecCtx, &avPacket)
+	if res < 0 {
+		return false
+	}
+
+	// receive frame if available
+	res = C.avcodec_receive_frame(d.codecCtx, d.srcFrame)
+	return res >= 0
+}
+
+// skip sends a NALU to the decoder, without converting the frame, if any.
+func (d *ffmpegDecoder) skip(nalu []byte) {
+	d.receive(nalu)
+}
+
+// decode sends a NALU to the decoder, returning a frame once one is available.
+func (d *ffmpegDecoder) decode(nalu []byte) (image.Image, error) {
+	if !d.receive(nalu) {
+		return nil, nil
+	}
+
+	// if frame size has changed, allocate needed objects. The size is only
+	// recorded once they all are, so that a failed setup is tried again.
+	if d.swsCtx == nil || d.srcWidth != d.srcFrame.width || d.srcHeight != d.srcFrame.height {
+		if d.dstFrame != nil {
+			C.av_frame_free(&d.dstFrame)
+		}
+
+		if d.swsCtx != nil {
+			C.sws_freeContext(d.swsCtx)
+			d.swsCtx = nil
+		}
+		d.srcWidth = 0
+		d.srcHeight = 0
+
+		width, height := d.scaledSize((int)(d.srcFrame.width), (int)(d.srcFrame.height))
+
+		d.dstFrame = C.av_frame_alloc()
+		if d.dstFrame == nil {
+			return nil, fmt.Errorf("av_frame_alloc() failed")
+		}
+		d.dstFrame.format = C.AV_PIX_FMT_RGBA
+		d.dstFrame.width = C.int(width)
+		d.dstFrame.height = C.int(height)
+		d.dstFrame.color_range = C.AVCOL_RANGE_JPEG
+		res := C.av_frame_get_buffer(d.dstFrame, 1)
+		if res < 0 {
+			C.av_frame_free(&d.dstFrame)
+			return nil, fmt.Errorf("av_frame_get_buffer() failed")
+		}
+
+		// the source pixel format depends on the codec profile, for instance
+		// H265 Main 10 decodes into YUV420P10. The frames are also scaled by the conversion.
+		d.swsCtx = C.sws_getContext(d.srcFrame.width, d.srcFrame.height, (int32)(d.srcFrame.format),
+			d.dstFrame.width, d.dstFrame.height, (int32)(d.dstFrame.format), C.SWS_BILINEAR, nil, nil, nil)
+		if d.swsCtx == nil {
+			C.av_frame_free(&d.dstFrame)
+			return nil, fmt.Errorf("sws_getContext() failed")
+		}
+
+		dstFrameSize := C.av_image_get_buffer_size((int32)(d.dstFrame.format), d.dstFrame.width, d.dstFrame.height, 1)
+		d.dstFramePtr = (*[1 << 30]uint8)(unsafe.Pointer(d.dstFrame.data[0]))[:dstFrameSize:dstFrameSize]
+		d.srcWidth = d.srcFrame.width
+		d.srcHeight = d.srcFrame.height
+	}
+
+	// the frame is dropped without being converted while the consumers
+	// hold every image of the pool, as they can't keep up anyway
+	img := d.frames.Get((int)(d.dstFrame.width), (int)(d.dstFrame.height))
+	if img == nil {
+		return nil, nil
+	}
+
+	// convert color space from YUV420 to RGBA, scaling the frame down
+	res := C.sws_scale(d.swsCtx, frameData(d.srcFrame), frameLineSize(d.srcFrame),
+		0, d.srcFrame.height, frameData(d.dstFrame), frameLineSize(d.dstFrame))
+	if res < 0 {
+		camera.Frame{Image: img, Pool: d.frames}.Release()
+		return nil, fmt.Errorf("sws_scale() failed")
+	}
+
+	// copy frame into an image of the pool, as the next one is converted into the same buffer
+	copy(img.Pix, d.dstFramePtr)
+	return img, nil
+}
+
+func (d *ffmpegDecoder) framePool() *camera.FramePool {
+	return d.frames
+}
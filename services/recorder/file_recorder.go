@@ -0,0 +1,634 @@
+package recorder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/asticode/go-astits"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/helpers"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+)
+
+// errStopped is returned when a replay is interrupted because the recorder was stopped.
+var errStopped = errors.New("recorder stopped")
+
+// FileRecorder replays recorded footage through the pipeline, as if it was
+// received from a camera, so that it can be analyzed again or used to test
+// the pipeline offline.
+//
+// It reads a MPEG-TS (.ts) or MP4 (.mp4) file, fragmented or not, or all of them
+// inside a directory in name order, and sends their frames to FrameOut like the RTSP
+// recorder does. Each file is replayed as a recording session of its own, since
+// timestamps start over.
+//
+// The replay isn't supervised like a camera feed, as there is nothing to reconnect
+// to: the feed is reported down, with its error, when the replay fails.
+type FileRecorder struct {
+	feed
+	path string
+}
+
+// This code requires the FFmpeg libraries, like the RTSP recorder.
+func NewFileRecorder(camera conf.CameraConfig, eChans EventChannels) *FileRecorder {
+	path, _ := camera.FilePath()
+	r := &FileRecorder{
+		feed: newFeed(camera, eChans),
+		path: path,
+	}
+	r.setupLogger()
+
+	return r
+}
+
+func (r *FileRecorder) setupLogger() {
+	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", r.camera.ID)
+}
+
+func (r *FileRecorder) Start() error {
+	_, err := os.Stat(r.path)
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+
+	if r.camera.Replay.Record {
+		cfg, _ := conf.ReadConf()
+		err = helpers.EnsureDirectoryExists(r.camera.RecordingsPath(cfg.Recorder.RecordingsDir))
+		if err != nil {
+			r.logger.Errorf("%v", err)
+			return err
+		}
+	}
+
+	r.wg.Add(1)
+	go r.run()
+	return nil
+}
+
+func (r *FileRecorder) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
+
+// run replays the files once, or until the recorder is stopped when looping.
+// A failed replay is reported as the feed going down.
+func (r *FileRecorder) run() {
+	defer r.wg.Done()
+
+	for {
+		err := r.record()
+		if err == errStopped {
+			return
+		}
+		if err != nil {
+			r.setFeedErr(err)
+			r.logger.Errorf("replay failed: %v", err)
+			r.sendFeedEvent(FeedEvent{CameraID: r.camera.ID, Status: FeedDown, Reason: err.Error()})
+			return
+		}
+		if !r.camera.Replay.Loop {
+			r.logger.Info("replay finished")
+			return
+		}
+	}
+}
+
+// sendFrame sends a frame like the RTSP recorder when replaying in real time.
+// Replaying as fast as possible waits for the frame to be taken instead,
+// as the point is to analyze every frame.
+func (r *FileRecorder) sendFrame(frame camera.Frame) error {
+	if r.camera.Replay.Pace == conf.ReplayFast {
+		select {
+		case r.eChans.FrameOut <- frame:
+			return nil
+		case <-r.stopCh:
+			frame.Release()
+			return errStopped
+		}
+	}
+
+	select {
+	case r.eChans.FrameOut <- frame:
+		return nil
+	case <-r.stopCh:
+		frame.Release()
+		return errStopped
+	default:
+		frame.Release()
+		r.logger.Info("buffer is full")
+		return nil
+	}
+}
+
+// record replays every file once. A file that can't be replayed is skipped,
+// so that a damaged recording doesn't stop the others.
+func (r *FileRecorder) record() error {
+	files, err := r.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no .ts or .mp4 file found in %s", r.path)
+	}
+
+	for _, path := range files {
+		r.logger.Infof("replaying %s", path)
+		err := r.replayFile(path)
+		if err == errStopped {
+			return err
+		}
+		if err != nil {
+			r.logger.Errorf("%s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// files returns the files to replay: the path itself, or
+// the .ts and .mp4 files inside it, sorted by path.
+func (r *FileRecorder) files() ([]string, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{r.path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(r.path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".ts", ".mp4":
+			if !d.IsDir() {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (r *FileRecorder) replayFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ts":
+		return r.replayMPEGTS(path)
+	case ".mp4":
+		return r.replayMP4(path)
+	}
+	return fmt.Errorf("unsupported file type")
+}
+
+// replayMPEGTS replays a MPEG-TS file, as its access units are demuxed.
+func (r *FileRecorder) replayMPEGTS(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader, err := mpegts.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+
+	var videoTrack, audioTrack *mpegts.Track
+	var codec videoCodec
+	var audio audioCodec
+	for _, track := range reader.Tracks() {
+		switch c := track.Codec.(type) {
+		case *mpegts.CodecH264:
+			if codec == nil {
+				videoTrack, codec = track, &h264Codec{}
+			}
+		case *mpegts.CodecH265:
+			if codec == nil {
+				videoTrack, codec = track, &h265Codec{}
+			}
+		case *mpegts.CodecMPEG4Audio:
+			if audio == nil {
+				audioTrack, audio = track, &aacCodec{config: &c.Config}
+			}
+		case *mpegts.CodecOpus:
+			if audio == nil {
+				audioTrack, audio = track, &opusCodec{channelCount: c.ChannelCount}
+			}
+		}
+	}
+	if codec == nil {
+		return errNoVideo
+	}
+
+	s, err := r.newReplaySession(path, codec, audio)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	// all the tracks share the same time base
+	var td *mpegts.TimeDecoder
+	decodeTime := func(ts int64) time.Duration {
+		if td == nil {
+			td = mpegts.NewTimeDecoder(ts)
+		}
+		return td.Decode(ts)
+	}
+
+	reader.OnDecodeError(func(err error) {
+		r.logger.Warnf("%v", err)
+	})
+
+	reader.OnDataH26x(videoTrack, func(pts int64, dts int64, au [][]byte) error {
+		d := decodeTime(dts)
+		p := d + time.Duration((pts-dts)&0x1FFFFFFFF)*time.Second/90000
+		return s.video(au, p, d)
+	})
+
+	if audioTrack != nil {
+		switch audio.(type) {
+		case *aacCodec:
+			reader.OnDataMPEG4Audio(audioTrack, func(pts int64, aus [][]byte) error {
+				return s.audio(aus, decodeTime(pts))
+			})
+		case *opusCodec:
+			reader.OnDataOpus(audioTrack, func(pts int64, packets [][]byte) error {
+				return s.audio(packets, decodeTime(pts))
+			})
+		}
+	}
+
+	for {
+		err := reader.Read()
+		if err != nil {
+			if s.stopped {
+				return errStopped
+			}
+			// the end of the file is reported as an error too,
+			// and files being written can end with a partial packet
+			if errors.Is(err, astits.ErrNoMorePackets) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// fileUnit is an access unit read from a MP4 file.
+type fileUnit struct {
+	video bool
+	au    [][]byte
+	pts   time.Duration
+	dts   time.Duration
+}
+
+// replayMP4 replays a MP4 file, fragmented or not. The file is read at once and
+// its samples are sorted by decoding time, as the tracks are in separate fragments,
+// or chunks. The tracks of a file that is not fragmented are described by its
+// moov box, that is read like the initialization block of a fragmented one.
+func (r *FileRecorder) replayMP4(path string) error {
+	byts, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var init fmp4.Init
+	var samples map[uint32][]*mp4Sample
+	if initSize, err := fmp4InitSize(byts); err == nil {
+		err = init.Unmarshal(byts[:initSize])
+		if err != nil {
+			return err
+		}
+		samples, err = fmp4Samples(byts[initSize:])
+		if err != nil {
+			return err
+		}
+	} else {
+		moov, ok := mp4Box(byts, "moov")
+		if !ok {
+			return fmt.Errorf("no moov box found")
+		}
+		err = init.Unmarshal(moov)
+		if err != nil {
+			return err
+		}
+		samples, err = mp4Samples(byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var videoTrack, audioTrack *fmp4.InitTrack
+	var codec videoCodec
+	var audio audioCodec
+	for _, track := range init.Tracks {
+		switch c := track.Codec.(type) {
+		case *fmp4.CodecH264:
+			if codec == nil {
+				videoTrack, codec = track, &h264Codec{sps: c.SPS, pps: c.PPS}
+			}
+		case *fmp4.CodecH265:
+			if codec == nil {
+				videoTrack, codec = track, &h265Codec{vps: c.VPS, sps: c.SPS, pps: c.PPS}
+			}
+		case *fmp4.CodecMJPEG:
+			if codec == nil {
+				videoTrack, codec = track, &mjpegCodec{width: c.Width, height: c.Height}
+			}
+		case *fmp4.CodecMPEG4Audio:
+			if audio == nil {
+				audioTrack, audio = track, &aacCodec{config: &c.Config}
+			}
+		case *fmp4.CodecOpus:
+			if audio == nil {
+				audioTrack, audio = track, &opusCodec{channelCount: c.ChannelCount}
+			}
+		}
+	}
+	if codec == nil {
+		return errNoVideo
+	}
+
+	var units []*fileUnit
+	for _, track := range []*fmp4.InitTrack{videoTrack, audioTrack} {
+		if track == nil {
+			continue
+		}
+		for _, smp := range samples[uint32(track.ID)] {
+			u := &fileUnit{
+				video: track == videoTrack,
+				dts:   fmp4Duration(smp.dts, track.TimeScale),
+				pts:   fmp4Duration(smp.dts+int64(smp.ptsOffset), track.TimeScale),
+			}
+
+			if _, ok := codec.(*mjpegCodec); u.video && !ok {
+				u.au, err = h264.AVCCUnmarshal(smp.payload)
+				if err != nil {
+					return err
+				}
+			} else {
+				u.au = [][]byte{smp.payload}
+			}
+			units = append(units, u)
+		}
+	}
+	sort.SliceStable(units, func(i, j int) bool {
+		return units[i].dts < units[j].dts
+	})
+
+	s, err := r.newReplaySession(path, codec, audio)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+
+	for _, u := range units {
+		if u.video {
+			err = s.video(u.au, u.pts, u.dts)
+		} else {
+			err = s.audio(u.au, u.pts)
+		}
+		if err != nil {
+			if s.stopped {
+				return errStopped
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// fmp4InitSize returns the size of the initialization block of a fMP4
+// file, that is everything before its first moof box.
+func fmp4InitSize(byts []byte) (int, error) {
+	pos := 0
+	for pos+8 <= len(byts) {
+		size := int(uint32(byts[pos])<<24 | uint32(byts[pos+1])<<16 | uint32(byts[pos+2])<<8 | uint32(byts[pos+3]))
+		if string(byts[pos+4:pos+8]) == "moof" {
+			return pos, nil
+		}
+		if size < 8 {
+			break
+		}
+		pos += size
+	}
+	return 0, fmt.Errorf("not a fragmented MP4 file")
+}
+
+func fmp4Duration(v int64, timeScale uint32) time.Duration {
+	return time.Duration(v) * time.Second / time.Duration(timeScale)
+}
+
+// replaySession replays the access units of a file, decoding them into frames,
+// recording them when enabled, and pacing them when replaying in real time.
+type replaySession struct {
+	r        *FileRecorder
+	path     string // file being replayed
+	codec    videoCodec
+	audioC   audioCodec
+	mux      *muxer // nil when the replay is not recorded
+	frameDec frameDecoder
+	sampler  *frameSampler
+
+	// frames can't be decoded before a random access access unit is received
+	randomAccessReceived bool
+
+	// the wall clock time and the timestamp of the first access unit, to pace the others
+	started   bool
+	startTime time.Time
+	startDTS  time.Duration
+
+	// stopped tells if the replay was interrupted by the recorder being stopped
+	stopped bool
+}
+
+func (r *FileRecorder) newReplaySession(path string, codec videoCodec, audio audioCodec) (*replaySession, error) {
+	frameDec, err := codec.newDecoder(analyticsMaxSize(r.camera.Analytics))
+	if err != nil {
+		return nil, err
+	}
+
+	// if the parameter sets are present into the file header, send them to the decoder
+	for _, param := range codec.parameters() {
+		frameDec.decode(param)
+	}
+
+	s := &replaySession{
+		r:        r,
+		path:     path,
+		codec:    codec,
+		audioC:   audio,
+		frameDec: frameDec,
+		sampler:  newFrameSampler(r.camera.Analytics),
+	}
+	if r.camera.Replay.Record {
+		s.mux = newMuxer(r.camera, codec, audio, r.eChans.RecordOut, r.eChans.Stream)
+	}
+	return s, nil
+}
+
+func (s *replaySession) close() {
+	if s.mux != nil {
+		s.mux.close()
+	}
+	s.frameDec.close()
+}
+
+// wait waits until the access unit with the given DTS is due, when replaying in
+// real time, and handles the event triggers received in the meantime.
+func (s *replaySession) wait(dts time.Duration) error {
+	var due <-chan time.Time
+	if s.r.camera.Replay.Pace != conf.ReplayFast {
+		if !s.started {
+			s.started = true
+			s.startTime = time.Now()
+			s.startDTS = dts
+		}
+		if d := time.Until(s.startTime.Add(dts - s.startDTS)); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			due = timer.C
+		}
+	}
+
+	for {
+		// when the access unit is already due, only the pending triggers are handled
+		if due == nil {
+			select {
+			case <-s.r.stopCh:
+				s.stopped = true
+				return errStopped
+			case t := <-s.r.eChans.TriggerIn:
+				s.trigger(t)
+				continue
+			default:
+				return nil
+			}
+		}
+
+		select {
+		case <-s.r.stopCh:
+			s.stopped = true
+			return errStopped
+		case t := <-s.r.eChans.TriggerIn:
+			s.trigger(t)
+		case <-due:
+			return nil
+		}
+	}
+}
+
+func (s *replaySession) trigger(t Trigger) {
+	s.r.logger.Debugf("event triggered: %s", t.Reason)
+	if s.mux == nil {
+		return
+	}
+	err := s.mux.trigger()
+	if err != nil {
+		s.r.logger.Errorf("%v", err)
+	}
+}
+
+// video replays a video access unit.
+func (s *replaySession) video(au [][]byte, pts time.Duration, dts time.Duration) error {
+	err := s.wait(dts)
+	if err != nil {
+		return err
+	}
+
+	// wait for an I-frame
+	keyframe := s.codec.isRandomAccess(au)
+	if !s.randomAccessReceived {
+		s.randomAccessReceived = keyframe
+	}
+
+	// the access unit is recorded first, so that its frames can point to the segment it is in
+	if s.mux != nil {
+		err = s.mux.encode(au, pts)
+		if err != nil {
+			s.r.logger.Errorf("%v", err)
+		}
+	}
+
+	// only the sampled frames are converted and sent to the recognizers. When only
+	// the keyframes are analyzed, the other access units are not decoded at all
+	if !s.randomAccessReceived || !s.sampler.decode(keyframe) {
+		return nil
+	}
+	sampled := s.sampler.sample(pts)
+
+	for _, nalu := range au {
+		if !sampled {
+			s.frameDec.skip(nalu)
+			continue
+		}
+
+		img, err := s.frameDec.decode(nalu)
+		if err != nil {
+			s.r.logger.Errorf("Failed to decode NALU: %v", err)
+			continue
+		}
+		if img == nil {
+			continue
+		}
+
+		err = s.r.sendFrame(camera.Frame{
+			Image:    img,
+			Pool:     s.frameDec.framePool(),
+			CameraID: s.r.camera.ID,
+			PTS:      pts,
+			Time:     time.Now(),
+			Keyframe: keyframe,
+			Segment:  s.segment(pts),
+		})
+		if err != nil {
+			s.stopped = true
+			return err
+		}
+	}
+	return nil
+}
+
+// segment locates the access unit of the given PTS into the recording of the replay,
+// or into the replayed file when the replay is not recorded.
+func (s *replaySession) segment(pts time.Duration) camera.SegmentRef {
+	if s.mux != nil {
+		return s.mux.segment(pts)
+	}
+	return camera.SegmentRef{Path: s.path, Offset: pts - s.startDTS}
+}
+
+// audio replays audio frames, that are only needed by the recordings.
+func (s *replaySession) audio(frames [][]byte, pts time.Duration) error {
+	err := s.wait(pts)
+	if err != nil {
+		return err
+	}
+
+	if s.mux != nil {
+		err = s.mux.encodeAudio(frames, pts)
+		if err != nil {
+			s.r.logger.Errorf("%v", err)
+		}
+	}
+	return nil
+}
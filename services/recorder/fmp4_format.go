@@ -0,0 +1,252 @@
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+// fmp4PartDuration is the minimum duration of the fragments of a fMP4 segment.
+const fmp4PartDuration = time.Second
+
+// fmp4DefaultFrameDuration is the duration given to the last video sample of
+// a segment when it can't be computed from the previous ones.
+const fmp4DefaultFrameDuration = time.Second / 30
+
+// seekableBuffer is a bytes.Buffer that implements io.Seeker,
+// as needed by the fMP4 marshalers.
+type seekableBuffer struct {
+	bytes.Buffer
+	pos int64
+}
+
+func (b *seekableBuffer) Write(p []byte) (int, error) {
+	n := 0
+	if b.pos < int64(b.Len()) {
+		n = copy(b.Bytes()[b.pos:], p)
+		p = p[n:]
+	}
+	if len(p) > 0 {
+		nn, _ := b.Buffer.Write(p)
+		n += nn
+	}
+	b.pos += int64(n)
+	return n, nil
+}
+
+func (b *seekableBuffer) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = b.pos + offset
+	case io.SeekEnd:
+		pos = int64(b.Len()) + offset
+	}
+	if pos < 0 {
+		return 0, errors.New("negative position")
+	}
+	b.pos = pos
+	return pos, nil
+}
+
+func (b *seekableBuffer) Reset() {
+	b.Buffer.Reset()
+	b.pos = 0
+}
+
+// fmp4Track accumulates the samples of a track until they are written into a fragment.
+// The duration of a sample is known only when the next one is received, so the
+// last sample is kept aside until then.
+type fmp4Track struct {
+	id        int
+	timeScale uint32
+
+	samples  []*fmp4.PartSample
+	baseTime uint64 // decoding time of the first of samples
+
+	last     *fmp4.PartSample
+	lastDTS  time.Duration
+	duration uint32 // duration of the previous sample
+}
+
+func (t *fmp4Track) timeOf(d time.Duration) int64 {
+	return int64(d) * int64(t.timeScale) / int64(time.Second)
+}
+
+// push adds a sample, whose decoding time is relative to the start of the segment.
+func (t *fmp4Track) push(ps *fmp4.PartSample, dts time.Duration) {
+	if t.last != nil {
+		duration := t.timeOf(dts) - t.timeOf(t.lastDTS)
+		if duration < 0 {
+			duration = 0
+		}
+		t.last.Duration = uint32(duration)
+		t.duration = t.last.Duration
+		t.add(t.last, t.lastDTS)
+	}
+	t.last = ps
+	t.lastDTS = dts
+}
+
+// flushLast adds the last sample, guessing its duration.
+func (t *fmp4Track) flushLast(defaultDuration time.Duration) {
+	if t.last == nil {
+		return
+	}
+	t.last.Duration = t.duration
+	if t.last.Duration == 0 {
+		t.last.Duration = uint32(t.timeOf(defaultDuration))
+	}
+	t.add(t.last, t.lastDTS)
+	t.last = nil
+}
+
+func (t *fmp4Track) add(ps *fmp4.PartSample, dts time.Duration) {
+	if len(t.samples) == 0 {
+		t.baseTime = uint64(t.timeOf(dts))
+	}
+	t.samples = append(t.samples, ps)
+}
+
+// fmp4Format writes fragmented MP4 segments, made of an initialization
+// block followed by fragments of about fmp4PartDuration.
+type fmp4Format struct {
+	codec      videoCodec
+	audio      audioCodec
+	videoTrack *fmp4Track
+	audioTrack *fmp4Track
+
+	w              io.Writer
+	buf            seekableBuffer
+	sequenceNumber uint32
+	startDTS       time.Duration
+	partStart      time.Duration
+}
+
+func newFMP4Format(codec videoCodec, audio audioCodec) *fmp4Format {
+	return &fmp4Format{
+		codec: codec,
+		audio: audio,
+	}
+}
+
+func (f *fmp4Format) ext() string {
+	return ".mp4"
+}
+
+func (f *fmp4Format) begin(w io.Writer, first *sample) error {
+	f.w = w
+	f.startDTS = first.dts
+	f.partStart = 0
+
+	f.videoTrack = &fmp4Track{id: 1, timeScale: 90000}
+	init := fmp4.Init{
+		Tracks: []*fmp4.InitTrack{{
+			ID:        f.videoTrack.id,
+			TimeScale: f.videoTrack.timeScale,
+			Codec:     f.codec.fmp4Codec(),
+		}},
+	}
+
+	f.audioTrack = nil
+	if f.audio != nil {
+		f.audioTrack = &fmp4Track{id: 2, timeScale: uint32(f.audio.sampleRate())}
+		init.Tracks = append(init.Tracks, &fmp4.InitTrack{
+			ID:        f.audioTrack.id,
+			TimeScale: f.audioTrack.timeScale,
+			Codec:     f.audio.fmp4Codec(),
+		})
+	}
+
+	f.buf.Reset()
+	err := init.Marshal(&f.buf)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(f.buf.Bytes())
+	return err
+}
+
+func (f *fmp4Format) write(smp *sample) error {
+	if !smp.video {
+		if f.audioTrack == nil {
+			return nil
+		}
+		pts := smp.pts - f.startDTS
+		for _, au := range smp.au {
+			// skip the audio that precedes the video
+			if pts >= 0 {
+				f.audioTrack.push(&fmp4.PartSample{Payload: au}, pts)
+			}
+			pts += f.audio.duration(au)
+		}
+		return nil
+	}
+
+	dts := smp.dts - f.startDTS
+	if dts < 0 {
+		return nil
+	}
+
+	// fragments start with a video sample, once the previous one is long enough
+	if dts-f.partStart >= fmp4PartDuration {
+		err := f.writePart()
+		if err != nil {
+			return err
+		}
+		f.partStart = dts
+	}
+
+	ps, err := f.codec.fmp4Sample(
+		int32(f.videoTrack.timeOf(smp.pts)-f.videoTrack.timeOf(smp.dts)),
+		smp.randomAccess,
+		smp.au)
+	if err != nil {
+		return err
+	}
+	f.videoTrack.push(ps, dts)
+	return nil
+}
+
+func (f *fmp4Format) end() error {
+	f.videoTrack.flushLast(fmp4DefaultFrameDuration)
+	if f.audioTrack != nil && f.audioTrack.last != nil {
+		f.audioTrack.flushLast(f.audio.duration(f.audioTrack.last.Payload))
+	}
+	return f.writePart()
+}
+
+// writePart writes the samples that are ready into a fragment.
+func (f *fmp4Format) writePart() error {
+	part := fmp4.Part{SequenceNumber: f.sequenceNumber}
+
+	for _, t := range []*fmp4Track{f.videoTrack, f.audioTrack} {
+		if t == nil || len(t.samples) == 0 {
+			continue
+		}
+		part.Tracks = append(part.Tracks, &fmp4.PartTrack{
+			ID:       t.id,
+			BaseTime: t.baseTime,
+			Samples:  t.samples,
+		})
+		t.samples = nil
+	}
+
+	if len(part.Tracks) == 0 {
+		return nil
+	}
+	f.sequenceNumber++
+
+	f.buf.Reset()
+	err := part.Marshal(&f.buf)
+	if err != nil {
+		return err
+	}
+	_, err = f.w.Write(f.buf.Bytes())
+	return err
+}
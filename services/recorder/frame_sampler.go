@@ -0,0 +1,62 @@
+package recorder
+
+import (
+	"image"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+// frameSampler chooses, from the analytics settings of a camera, the access units
+// whose frames are sent to the recognizers. The others are still recorded.
+type frameSampler struct {
+	interval      time.Duration
+	keyframesOnly bool
+
+	// next is the PTS from which the next frame is sampled
+	started bool
+	next    time.Duration
+}
+
+func newFrameSampler(settings conf.AnalyticsConfig) *frameSampler {
+	s := &frameSampler{keyframesOnly: settings.KeyframesOnly}
+	if settings.FPS > 0 {
+		s.interval = time.Duration(float64(time.Second) / settings.FPS)
+	}
+	return s
+}
+
+// decode tells if an access unit must be decoded. When only the keyframes are
+// analyzed, the other access units are skipped without being decoded.
+func (s *frameSampler) decode(keyframe bool) bool {
+	return !s.keyframesOnly || keyframe
+}
+
+// sample tells if the frame of the access unit with the given PTS must be sent to
+// the recognizers. Frames that are not sampled are decoded, when the following
+// ones depend on them, but are not converted.
+func (s *frameSampler) sample(pts time.Duration) bool {
+	if s.interval == 0 {
+		return true
+	}
+
+	// frames are skipped until next, unless the PTS went back,
+	// as it does when a new file is replayed
+	if s.started && pts < s.next && pts >= s.next-s.interval {
+		return false
+	}
+
+	// the cadence is kept, unless no frame was received for longer than an interval
+	if s.started && pts >= s.next && pts < s.next+s.interval {
+		s.next += s.interval
+	} else {
+		s.next = pts + s.interval
+	}
+	s.started = true
+	return true
+}
+
+// analyticsMaxSize returns the size the analyzed frames are scaled down to fit into.
+func analyticsMaxSize(settings conf.AnalyticsConfig) image.Point {
+	return image.Pt(settings.MaxWidth, settings.MaxHeight)
+}
@@ -0,0 +1,93 @@
+package recorder
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+func TestFrameSamplerSample(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name string
+		fps  float64
+		pts  []time.Duration
+		want []bool
+	}{
+		{
+			name: "no limit",
+			fps:  0,
+			pts:  []time.Duration{0, 40 * ms, 80 * ms},
+			want: []bool{true, true, true},
+		},
+		{
+			name: "one in two",
+			fps:  5,
+			pts:  []time.Duration{0, 100 * ms, 200 * ms, 300 * ms, 400 * ms, 500 * ms, 600 * ms},
+			want: []bool{true, false, true, false, true, false, true},
+		},
+		{
+			name: "keeps the cadence with jitter",
+			fps:  5,
+			pts:  []time.Duration{0, 190 * ms, 210 * ms, 390 * ms, 410 * ms},
+			want: []bool{true, false, true, false, true},
+		},
+		{
+			name: "restarts the cadence after a gap",
+			fps:  5,
+			pts:  []time.Duration{0, time.Second, 1100 * ms, 1200 * ms},
+			want: []bool{true, true, false, true},
+		},
+		{
+			name: "restarts when the pts goes back",
+			fps:  5,
+			pts:  []time.Duration{0, 200 * ms, 400 * ms, 0, 100 * ms, 200 * ms},
+			want: []bool{true, true, true, true, false, true},
+		},
+		{
+			name: "slower feed than the limit",
+			fps:  10,
+			pts:  []time.Duration{0, 500 * ms, time.Second},
+			want: []bool{true, true, true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFrameSampler(conf.AnalyticsConfig{FPS: tt.fps})
+			var got []bool
+			for _, pts := range tt.pts {
+				got = append(got, s.sample(pts))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sampled %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameSamplerDecode(t *testing.T) {
+	tests := []struct {
+		name          string
+		keyframesOnly bool
+		keyframe      bool
+		want          bool
+	}{
+		{"every frame, keyframe", false, true, true},
+		{"every frame, other frame", false, false, true},
+		{"keyframes only, keyframe", true, true, true},
+		{"keyframes only, other frame", true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFrameSampler(conf.AnalyticsConfig{KeyframesOnly: tt.keyframesOnly})
+			if got := s.decode(tt.keyframe); got != tt.want {
+				t.Errorf("decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package recorder
+
+import "image"
+
+// h265Decoder is a wrapper around FFmpeg's H265 (HEVC) decoder.
+type h265Decoder struct {
+	*ffmpegDecoder
+}
+
+// newH265Decoder allocates a new h265Decoder.
+func newH265Decoder(maxSize image.Point) (*h265Decoder, error) {
+	d, err := newFFmpegDecoder("hevc", maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &h265Decoder{d}, nil
+}
@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pion/rtp"
+)
+
+// mjpegCodec is the videoCodec of MJPEG streams, whose access units are
+// made of a single JPEG image. Every image can be decoded on its own.
+//
+// MPEG-TS can't carry MJPEG, so MJPEG streams are always recorded into fMP4,
+// and they are not available to the live outputs.
+type mjpegCodec struct {
+	width  int
+	height int
+}
+
+func (c *mjpegCodec) name() string {
+	return "MJPEG"
+}
+
+func (c *mjpegCodec) decodeRTP(_ *rtp.Packet) ([][]byte, error) {
+	return nil, errors.New("MJPEG is only received over HTTP")
+}
+
+func (c *mjpegCodec) isRandomAccess(_ [][]byte) bool {
+	return true
+}
+
+func (c *mjpegCodec) prepare(au [][]byte) ([][]byte, bool) {
+	if len(au) != 1 {
+		return nil, false
+	}
+
+	// the size of the track is the one of the first image
+	if c.width == 0 {
+		config, err := jpeg.DecodeConfig(bytes.NewReader(au[0]))
+		if err != nil {
+			return nil, false
+		}
+		c.width = config.Width
+		c.height = config.Height
+	}
+
+	return au, true
+}
+
+func (c *mjpegCodec) extractDTS(_ [][]byte, pts time.Duration, _ bool) (time.Duration, bool, error) {
+	return pts, true, nil
+}
+
+func (c *mjpegCodec) parameters() [][]byte {
+	return nil
+}
+
+func (c *mjpegCodec) mpegtsCodec() mpegts.Codec {
+	return nil
+}
+
+func (c *mjpegCodec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecMJPEG{Width: c.width, Height: c.height}
+}
+
+func (c *mjpegCodec) fmp4Sample(_ int32, _ bool, au [][]byte) (*fmp4.PartSample, error) {
+	return &fmp4.PartSample{Payload: au[0]}, nil
+}
+
+// newDecoder allocates a jpegDecoder. The images are not scaled down, as
+// MJPEG cameras seldom stream at high resolutions.
+func (c *mjpegCodec) newDecoder(_ image.Point) (frameDecoder, error) {
+	return &jpegDecoder{}, nil
+}
+
+// jpegDecoder decodes the images of a MJPEG stream, without FFmpeg.
+type jpegDecoder struct{}
+
+func (d *jpegDecoder) decode(img []byte) (image.Image, error) {
+	return jpeg.Decode(bytes.NewReader(img))
+}
+
+// skip does nothing, as every image is decoded on its own.
+func (d *jpegDecoder) skip(_ []byte) {}
+
+// framePool returns nil, as every image is decoded into a new buffer.
+func (d *jpegDecoder) framePool() *camera.FramePool {
+	return nil
+}
+
+func (d *jpegDecoder) close() {}
@@ -0,0 +1,199 @@
+package recorder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/helpers"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+)
+
+// mjpegMaxImageSize is the maximum size of an image of a MJPEG stream,
+// so that a broken stream can't fill the memory.
+const mjpegMaxImageSize = 10 * 1024 * 1024
+
+// MJPEGRecorder records the MJPEG stream that a camera serves over HTTP, as
+// a multipart/x-mixed-replace response where every part is a JPEG image.
+// The images are timestamped as they are received, and recorded into fMP4.
+//
+// Credentials in the camera settings are sent with basic authentication.
+type MJPEGRecorder struct {
+	feed
+}
+
+// NewMJPEGRecorder allocates a MJPEGRecorder. It doesn't need FFmpeg.
+func NewMJPEGRecorder(camera conf.CameraConfig, eChans EventChannels) *MJPEGRecorder {
+	r := &MJPEGRecorder{
+		feed: newFeed(camera, eChans),
+	}
+	r.setupLogger()
+
+	return r
+}
+
+func (r *MJPEGRecorder) setupLogger() {
+	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", r.camera.ID)
+}
+
+func (r *MJPEGRecorder) Start() error {
+	_, err := r.camera.StreamURL()
+	if err != nil {
+		r.logger.Errorf("failed to parse url: %v", err)
+		return err
+	}
+
+	cfg, _ := conf.ReadConf()
+	err = helpers.EnsureDirectoryExists(r.camera.RecordingsPath(cfg.Recorder.RecordingsDir))
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.backoff = newBackoff(cfg.Recorder.Reconnect)
+
+	r.wg.Add(1)
+	go r.supervise(r.record)
+	return nil
+}
+
+func (r *MJPEGRecorder) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
+
+// record runs a single recording session, from requesting the stream until
+// the response ends or the recorder is stopped.
+func (r *MJPEGRecorder) record() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.camera.URL, nil)
+	if err != nil {
+		return err
+	}
+	if r.camera.Username != "" {
+		req.SetBasicAuth(r.camera.Username, r.camera.Password)
+	}
+
+	r.logger.Info("recording...")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return fmt.Errorf("unsupported content type %q: the camera must serve a MJPEG stream", mediaType)
+	}
+	// some cameras put the dashes of the delimiters into the boundary
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	if boundary == "" {
+		return fmt.Errorf("the MJPEG stream has no boundary")
+	}
+
+	codec := &mjpegCodec{}
+	r.logger.Infof("recording %s video", codec.name())
+
+	mux := newMuxer(r.camera, codec, nil, r.eChans.RecordOut, r.eChans.Stream)
+	defer mux.close()
+
+	frameDec, _ := codec.newDecoder(analyticsMaxSize(r.camera.Analytics))
+	defer frameDec.close()
+	sampler := newFrameSampler(r.camera.Analytics)
+
+	r.feedPlaying()
+
+	// the images are read by another goroutine, so that
+	// triggers are handled while waiting for them
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- r.readImages(multipart.NewReader(res.Body, boundary), func(img []byte, pts time.Duration) {
+			err := mux.encode([][]byte{img}, pts)
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
+
+			// the images that are not sampled are not decoded at all
+			if !sampler.sample(pts) {
+				return
+			}
+
+			frame, err := frameDec.decode(img)
+			if err != nil {
+				r.logger.Errorf("Failed to decode image: %v", err)
+				return
+			}
+
+			// every image of a MJPEG stream is a keyframe
+			err = r.sendFrame(r.newFrame(frame, frameDec.framePool(), pts, true, mux.segment(pts)))
+			if err != nil {
+				r.logger.Errorf("Failed to send frame: %v", err)
+			}
+		})
+	}()
+
+	for {
+		select {
+		case <-r.stopCh:
+			r.logger.Info("received stop signal")
+			cancel()
+			<-readErrCh
+			return nil
+		case err := <-readErrCh:
+			return err
+		case t := <-r.eChans.TriggerIn:
+			r.logger.Debugf("event triggered: %s", t.Reason)
+			err := mux.trigger()
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+// readImages reads the parts of a MJPEG stream until it ends, passing each
+// image to onImage along with its timestamp, relative to the first one.
+func (r *MJPEGRecorder) readImages(mr *multipart.Reader, onImage func(img []byte, pts time.Duration)) error {
+	var start time.Time
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return err
+		}
+
+		img, err := io.ReadAll(io.LimitReader(part, mjpegMaxImageSize+1))
+		part.Close()
+		if err != nil {
+			return err
+		}
+		if len(img) > mjpegMaxImageSize {
+			return fmt.Errorf("image is bigger than %d bytes", mjpegMaxImageSize)
+		}
+		if len(img) == 0 {
+			continue
+		}
+
+		now := time.Now()
+		if start.IsZero() {
+			start = now
+		}
+		onImage(img, now.Sub(start))
+	}
+}
@@ -0,0 +1,199 @@
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/abema/go-mp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+)
+
+// mp4Sample is a sample of a MP4 track, with its timestamps in the time scale of the track.
+type mp4Sample struct {
+	payload   []byte
+	dts       int64
+	ptsOffset int32
+}
+
+// mp4Box returns the top level box of the given type, with its header.
+func mp4Box(byts []byte, typ string) ([]byte, bool) {
+	pos := 0
+	for pos+8 <= len(byts) {
+		size := int(uint32(byts[pos])<<24 | uint32(byts[pos+1])<<16 | uint32(byts[pos+2])<<8 | uint32(byts[pos+3]))
+		if size < 8 || pos+size > len(byts) {
+			break
+		}
+		if string(byts[pos+4:pos+8]) == typ {
+			return byts[pos : pos+size], true
+		}
+		pos += size
+	}
+	return nil, false
+}
+
+// fmp4Samples returns the samples of the tracks of a fragmented MP4 file,
+// from its fragments, that start after the initialization block.
+func fmp4Samples(byts []byte) (map[uint32][]*mp4Sample, error) {
+	var parts fmp4.Parts
+	err := parts.Unmarshal(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(map[uint32][]*mp4Sample)
+	for _, part := range parts {
+		for _, pt := range part.Tracks {
+			dts := pt.BaseTime
+			for _, smp := range pt.Samples {
+				samples[uint32(pt.ID)] = append(samples[uint32(pt.ID)], &mp4Sample{
+					payload:   smp.Payload,
+					dts:       int64(dts),
+					ptsOffset: smp.PTSOffset,
+				})
+				dts += uint64(smp.Duration)
+			}
+		}
+	}
+	return samples, nil
+}
+
+// mp4Samples returns the samples of the tracks of a MP4 file that is not
+// fragmented, such as an export, from the sample tables of its moov box.
+func mp4Samples(byts []byte) (map[uint32][]*mp4Sample, error) {
+	r := bytes.NewReader(byts)
+	traks, err := mp4.ExtractBox(r, nil, mp4.BoxPath{mp4.BoxTypeMoov(), mp4.BoxTypeTrak()})
+	if err != nil {
+		return nil, err
+	}
+
+	stbl := func(typ mp4.BoxType) mp4.BoxPath {
+		return mp4.BoxPath{mp4.BoxTypeMdia(), mp4.BoxTypeMinf(), mp4.BoxTypeStbl(), typ}
+	}
+
+	samples := make(map[uint32][]*mp4Sample)
+	for _, trak := range traks {
+		boxes, err := mp4.ExtractBoxesWithPayload(r, trak, []mp4.BoxPath{
+			{mp4.BoxTypeTkhd()},
+			stbl(mp4.BoxTypeStts()),
+			stbl(mp4.BoxTypeCtts()),
+			stbl(mp4.BoxTypeStsc()),
+			stbl(mp4.BoxTypeStsz()),
+			stbl(mp4.BoxTypeStco()),
+			stbl(mp4.BoxTypeCo64()),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var t mp4SampleTables
+		for _, box := range boxes {
+			switch b := box.Payload.(type) {
+			case *mp4.Tkhd:
+				t.trackID = b.TrackID
+			case *mp4.Stts:
+				t.stts = b
+			case *mp4.Ctts:
+				t.ctts = b
+			case *mp4.Stsc:
+				t.stsc = b
+			case *mp4.Stsz:
+				t.stsz = b
+			case *mp4.Stco:
+				t.chunkOffsets = make([]uint64, len(b.ChunkOffset))
+				for i, offset := range b.ChunkOffset {
+					t.chunkOffsets[i] = uint64(offset)
+				}
+			case *mp4.Co64:
+				t.chunkOffsets = b.ChunkOffset
+			}
+		}
+
+		trackSamples, err := t.samples(byts)
+		if err != nil {
+			return nil, fmt.Errorf("track %d: %w", t.trackID, err)
+		}
+		samples[t.trackID] = trackSamples
+	}
+	return samples, nil
+}
+
+// mp4SampleTables are the tables that locate the samples of a track into
+// the file, and give their timestamps. Samples are grouped into chunks,
+// that are runs of samples stored one after the other.
+type mp4SampleTables struct {
+	trackID      uint32
+	stts         *mp4.Stts // durations
+	ctts         *mp4.Ctts // PTS offsets, if any
+	stsc         *mp4.Stsc // samples per chunk
+	stsz         *mp4.Stsz // sizes
+	chunkOffsets []uint64
+}
+
+func (t *mp4SampleTables) samples(byts []byte) ([]*mp4Sample, error) {
+	if t.stts == nil || t.stsc == nil || t.stsz == nil || t.chunkOffsets == nil {
+		return nil, fmt.Errorf("missing sample tables")
+	}
+
+	count := int(t.stsz.SampleCount)
+	samples := make([]*mp4Sample, 0, count)
+
+	// sizes and offsets
+	for chunk, offset := range t.chunkOffsets {
+		perChunk := 0
+		for _, e := range t.stsc.Entries {
+			if int(e.FirstChunk) > chunk+1 {
+				break
+			}
+			perChunk = int(e.SamplesPerChunk)
+		}
+
+		for i := 0; i < perChunk && len(samples) < count; i++ {
+			size := uint64(t.stsz.SampleSize)
+			if size == 0 {
+				if len(samples) >= len(t.stsz.EntrySize) {
+					return nil, fmt.Errorf("%d sample sizes, want %d", len(t.stsz.EntrySize), count)
+				}
+				size = uint64(t.stsz.EntrySize[len(samples)])
+			}
+			if offset+size > uint64(len(byts)) {
+				return nil, fmt.Errorf("sample %d is past the end of the file", len(samples))
+			}
+			samples = append(samples, &mp4Sample{payload: byts[offset : offset+size]})
+			offset += size
+		}
+	}
+	if len(samples) != count {
+		return nil, fmt.Errorf("the chunks hold %d samples, want %d", len(samples), count)
+	}
+
+	// timestamps
+	i := 0
+	var dts int64
+	for _, e := range t.stts.Entries {
+		for j := uint32(0); j < e.SampleCount && i < count; j++ {
+			samples[i].dts = dts
+			dts += int64(e.SampleDelta)
+			i++
+		}
+	}
+	if i != count {
+		return nil, fmt.Errorf("%d sample durations, want %d", i, count)
+	}
+
+	if t.ctts != nil {
+		i = 0
+		for _, e := range t.ctts.Entries {
+			offset := e.SampleOffsetV1
+			if t.ctts.GetVersion() == 0 {
+				// version 0 offsets are unsigned, but some muxers write negative ones anyway
+				offset = int32(e.SampleOffsetV0)
+			}
+			for j := uint32(0); j < e.SampleCount && i < count; j++ {
+				samples[i].ptsOffset = offset
+				i++
+			}
+		}
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,275 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testTrack describes a track of a MP4 file that is not fragmented. Its samples
+// are stored in chunks, one after the other, with the chunks of the tracks interleaved.
+type testTrack struct {
+	id          uint32
+	sizes       []uint32 // sample sizes, or a single one when they all have the same size
+	count       int      // sample count, when they all have the same size
+	chunks      []int    // samples per chunk
+	durations   []uint32
+	ptsOffsets  []int32 // none when the track has no ctts box
+	cttsVersion uint8
+	co64        bool
+}
+
+func (t testTrack) sampleCount() int {
+	if t.count != 0 {
+		return t.count
+	}
+	return len(t.sizes)
+}
+
+func (t testTrack) sampleSize(i int) uint32 {
+	if t.count != 0 {
+		return t.sizes[0]
+	}
+	return t.sizes[i]
+}
+
+func mp4TestBox(typ string, payload ...[]byte) []byte {
+	b := make([]byte, 8)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], typ)
+	return b
+}
+
+func mp4TestFullBox(typ string, version uint8, values ...uint64) []byte {
+	payload := []byte{version, 0, 0, 0}
+	for _, v := range values {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(v))
+	}
+	return mp4TestBox(typ, payload)
+}
+
+// mp4TestFile lays out a moov box describing the tracks, followed by a mdat box
+// with their samples. Every byte of a sample is its index, plus 16 times its track ID.
+// It returns the file and the samples that it holds.
+func mp4TestFile(tracks []testTrack) ([]byte, map[uint32][]*mp4Sample) {
+	moov := func(mdatStart int) ([]byte, []byte, map[uint32][]*mp4Sample) {
+		mdat := []byte{}
+		offsets := make([][]uint64, len(tracks))
+		samples := make(map[uint32][]*mp4Sample)
+		next := make([]int, len(tracks))
+		for chunk := 0; ; chunk++ {
+			done := true
+			for i, t := range tracks {
+				if chunk >= len(t.chunks) {
+					continue
+				}
+				done = false
+				// chunks are apart, as if something else was stored between them
+				mdat = append(mdat, 0xff, 0xff)
+				offsets[i] = append(offsets[i], uint64(mdatStart+8+len(mdat)))
+				for j := 0; j < t.chunks[chunk]; j++ {
+					payload := make([]byte, t.sampleSize(next[i]))
+					for k := range payload {
+						payload[k] = byte(next[i]) + byte(16*t.id)
+					}
+					mdat = append(mdat, payload...)
+					samples[t.id] = append(samples[t.id], &mp4Sample{payload: payload})
+					next[i]++
+				}
+			}
+			if done {
+				break
+			}
+		}
+
+		var traks [][]byte
+		for i, t := range tracks {
+			var dts int64
+			var stts []uint64
+			for j, d := range t.durations {
+				if j < len(samples[t.id]) {
+					samples[t.id][j].dts = dts
+				}
+				dts += int64(d)
+				stts = append(stts, 1, uint64(d))
+			}
+			var stbl [][]byte
+			stbl = append(stbl, mp4TestFullBox("stts", 0, append([]uint64{uint64(len(t.durations))}, stts...)...))
+
+			if t.ptsOffsets != nil {
+				var ctts []uint64
+				for j, o := range t.ptsOffsets {
+					samples[t.id][j].ptsOffset = o
+					ctts = append(ctts, 1, uint64(uint32(o)))
+				}
+				stbl = append(stbl, mp4TestFullBox("ctts", t.cttsVersion, append([]uint64{uint64(len(t.ptsOffsets))}, ctts...)...))
+			}
+
+			var stsc []uint64
+			for j, n := range t.chunks {
+				if j == 0 || n != t.chunks[j-1] {
+					stsc = append(stsc, uint64(j+1), uint64(n), 1)
+				}
+			}
+			stbl = append(stbl, mp4TestFullBox("stsc", 0, append([]uint64{uint64(len(stsc) / 3)}, stsc...)...))
+
+			if t.count != 0 {
+				stbl = append(stbl, mp4TestFullBox("stsz", 0, uint64(t.sizes[0]), uint64(t.count)))
+			} else {
+				stsz := []uint64{0, uint64(len(t.sizes))}
+				for _, size := range t.sizes {
+					stsz = append(stsz, uint64(size))
+				}
+				stbl = append(stbl, mp4TestFullBox("stsz", 0, stsz...))
+			}
+
+			if t.co64 {
+				co64 := []byte{0, 0, 0, 0}
+				co64 = binary.BigEndian.AppendUint32(co64, uint32(len(offsets[i])))
+				for _, offset := range offsets[i] {
+					co64 = binary.BigEndian.AppendUint64(co64, offset)
+				}
+				stbl = append(stbl, mp4TestBox("co64", co64))
+			} else {
+				stco := []uint64{uint64(len(offsets[i]))}
+				stco = append(stco, offsets[i]...)
+				stbl = append(stbl, mp4TestFullBox("stco", 0, stco...))
+			}
+
+			tkhd := append([]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, make([]byte, 72)...)
+			binary.BigEndian.PutUint32(tkhd[12:], t.id)
+			traks = append(traks, mp4TestBox("trak",
+				mp4TestBox("tkhd", tkhd),
+				mp4TestBox("mdia", mp4TestBox("minf", mp4TestBox("stbl", stbl...)))))
+		}
+		return mp4TestBox("moov", traks...), mp4TestBox("mdat", mdat), samples
+	}
+
+	// the offsets of the chunks depend on the size of the moov box, but not its size on them
+	m, _, _ := moov(0)
+	m, mdat, samples := moov(len(m))
+	return append(m, mdat...), samples
+}
+
+func TestMP4Samples(t *testing.T) {
+	tests := []struct {
+		name     string
+		tracks   []testTrack
+		truncate int // bytes cut from the end of the file
+		wantErr  string
+	}{
+		{
+			name:   "one chunk",
+			tracks: []testTrack{{id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{3}, durations: []uint32{10, 10, 10}}},
+		},
+		{
+			name: "chunks of different sample counts",
+			tracks: []testTrack{{
+				id: 1, sizes: []uint32{3, 4, 5, 6, 7}, chunks: []int{2, 2, 1}, durations: []uint32{10, 10, 10, 20, 20},
+			}},
+		},
+		{
+			name:   "samples of the same size",
+			tracks: []testTrack{{id: 1, sizes: []uint32{4}, count: 5, chunks: []int{3, 2}, durations: []uint32{10, 10, 10, 10, 10}}},
+		},
+		{
+			name: "64 bit chunk offsets",
+			tracks: []testTrack{{
+				id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{1, 1, 1}, durations: []uint32{10, 10, 10}, co64: true,
+			}},
+		},
+		{
+			name: "pts offsets",
+			tracks: []testTrack{{
+				id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{3}, durations: []uint32{10, 10, 10}, ptsOffsets: []int32{20, 0, 10},
+			}},
+		},
+		{
+			name: "negative pts offsets",
+			tracks: []testTrack{{
+				id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{3}, durations: []uint32{10, 10, 10},
+				ptsOffsets: []int32{10, -10, 0}, cttsVersion: 1,
+			}},
+		},
+		{
+			name: "interleaved tracks",
+			tracks: []testTrack{
+				{id: 1, sizes: []uint32{3, 4, 5, 6}, chunks: []int{2, 2}, durations: []uint32{10, 10, 10, 10}},
+				{id: 2, sizes: []uint32{2}, count: 6, chunks: []int{3, 3}, durations: []uint32{7, 7, 7, 7, 7, 7}},
+			},
+		},
+		{
+			name:     "truncated file",
+			tracks:   []testTrack{{id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{3}, durations: []uint32{10, 10, 10}}},
+			truncate: 2,
+			wantErr:  "past the end of the file",
+		},
+		{
+			name:    "missing samples",
+			tracks:  []testTrack{{id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{2}, durations: []uint32{10, 10, 10}}},
+			wantErr: "the chunks hold 2 samples, want 3",
+		},
+		{
+			name:    "missing durations",
+			tracks:  []testTrack{{id: 1, sizes: []uint32{3, 4, 5}, chunks: []int{3}, durations: []uint32{10, 10}}},
+			wantErr: "2 sample durations, want 3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			byts, want := mp4TestFile(tt.tracks)
+			byts = byts[:len(byts)-tt.truncate]
+
+			got, err := mp4Samples(byts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("mp4Samples() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("mp4Samples() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				for id, samples := range got {
+					for i, smp := range samples {
+						t.Logf("track %d sample %d: %+v", id, i, *smp)
+					}
+				}
+				t.Errorf("mp4Samples() differs from the samples written")
+			}
+		})
+	}
+}
+
+func TestMP4Box(t *testing.T) {
+	byts := append(append(mp4TestBox("ftyp", []byte("isom")), mp4TestBox("mdat", []byte{1, 2, 3})...),
+		mp4TestBox("moov", mp4TestBox("trak"))...)
+
+	tests := []struct {
+		name   string
+		byts   []byte
+		typ    string
+		want   []byte
+		wantOK bool
+	}{
+		{"first box", byts, "ftyp", mp4TestBox("ftyp", []byte("isom")), true},
+		{"after the media data", byts, "moov", mp4TestBox("moov", mp4TestBox("trak")), true},
+		{"nested box", byts, "trak", nil, false},
+		{"truncated box", byts[:len(byts)-1], "moov", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := mp4Box(tt.byts, tt.typ)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mp4Box() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
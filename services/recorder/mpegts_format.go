@@ -0,0 +1,67 @@
+package recorder
+
+import (
+	"io"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+func durationGoToMPEGTS(v time.Duration) int64 {
+	return int64(v.Seconds() * 90000)
+}
+
+// mpegtsFormat writes MPEG-TS segments.
+type mpegtsFormat struct {
+	audio      audioCodec
+	track      *mpegts.Track
+	audioTrack *mpegts.Track
+	tracks     []*mpegts.Track
+
+	w *mpegts.Writer
+}
+
+func newMPEGTSFormat(codec videoCodec, audio audioCodec) *mpegtsFormat {
+	track := &mpegts.Track{
+		Codec: codec.mpegtsCodec(),
+	}
+	tracks := []*mpegts.Track{track}
+
+	var audioTrack *mpegts.Track
+	if audio != nil {
+		audioTrack = &mpegts.Track{
+			Codec: audio.mpegtsCodec(),
+		}
+		tracks = append(tracks, audioTrack)
+	}
+
+	return &mpegtsFormat{
+		audio:      audio,
+		track:      track,
+		audioTrack: audioTrack,
+		tracks:     tracks,
+	}
+}
+
+func (f *mpegtsFormat) ext() string {
+	return ".ts"
+}
+
+func (f *mpegtsFormat) begin(w io.Writer, _ *sample) error {
+	f.w = mpegts.NewWriter(w, f.tracks)
+	return nil
+}
+
+func (f *mpegtsFormat) write(smp *sample) error {
+	if smp.video {
+		return f.w.WriteH26x(f.track, durationGoToMPEGTS(smp.pts), durationGoToMPEGTS(smp.dts), smp.randomAccess, smp.au)
+	}
+	if f.audio == nil {
+		return nil
+	}
+	return f.audio.writeMPEGTS(f.w, f.audioTrack, smp.pts, smp.au)
+}
+
+func (f *mpegtsFormat) end() error {
+	return nil
+}
@@ -0,0 +1,245 @@
+package recorder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// muxer allows to save a H264, H265 or MJPEG stream, along with an optional
+// audio stream, into MPEG-TS or fMP4 files, as chosen for the camera.
+//
+// Depending on the camera recording mode, the stream is recorded continuously,
+// only around events, or both, in which case the continuous recording
+// keeps only the keyframes.
+type muxer struct {
+	// mu protects the muxer, as the video and audio access units
+	// and the triggers can be received by different goroutines.
+	mu sync.Mutex
+
+	codec     videoCodec
+	audio     audioCodec
+	mode      string
+	stream    *Stream
+	recordOut chan<- RecordedEvent
+
+	// continuous records the whole stream, or only its keyframes in the hybrid mode.
+	// events records the events. Each one is nil when it is not needed by the mode.
+	continuous *segmenter
+	events     *segmenter
+
+	// preRoll holds the samples to be recorded when an event starts.
+	preRoll  *preRollBuffer
+	postRoll time.Duration
+
+	// recording tells if an event is being recorded, until eventEnd.
+	recording bool
+	eventEnd  time.Duration
+	lastPTS   time.Duration
+
+	logger *logrus.Entry
+}
+
+// newMuxer allocates a muxer for a stream of the given codec. The audio
+// codec is nil when the feed has no audio. Every recording it saves is stored in
+// the camera directory and tagged with the camera ID. Everything the muxer
+// receives is also published into stream, that can be nil, when the live
+// outputs can carry the codec.
+func newMuxer(camera conf.CameraConfig, codec videoCodec, audio audioCodec, recordOut chan<- RecordedEvent,
+	stream *Stream) *muxer {
+	mux := &muxer{
+		codec:     codec,
+		audio:     audio,
+		mode:      camera.Recording.Mode,
+		stream:    stream,
+		recordOut: recordOut,
+		preRoll:   &preRollBuffer{duration: time.Duration(camera.Recording.PreRoll) * time.Second},
+		postRoll:  time.Duration(camera.Recording.PostRoll) * time.Second,
+		logger:    BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", camera.ID),
+	}
+
+	switch mux.mode {
+	case conf.RecordEvent:
+		mux.events = newSegmenter(camera, RecordingEvent, codec, audio)
+
+	case conf.RecordHybrid:
+		mux.continuous = newSegmenter(camera, RecordingKeyframes, codec, nil)
+		mux.events = newSegmenter(camera, RecordingEvent, codec, audio)
+
+	default:
+		mux.continuous = newSegmenter(camera, RecordingContinuous, codec, audio)
+	}
+
+	if video := codec.mpegtsCodec(); video != nil {
+		tracks := &StreamTracks{Video: video}
+		if audio != nil {
+			tracks.Audio = audio.mpegtsCodec()
+		}
+		stream.start(tracks)
+	}
+
+	return mux
+}
+
+// close closes all the muxer resources, saving the current segments.
+func (mux *muxer) close() {
+	mux.mu.Lock()
+	defer mux.unlock()
+
+	mux.stream.end()
+
+	if mux.continuous != nil {
+		mux.continuous.close()
+	}
+	if mux.events != nil {
+		mux.events.close()
+	}
+}
+
+// unlock releases the muxer, then reports the segments saved while it was held,
+// so that the triggers and the lookups of segments aren't held up by a slow
+// reader of recordOut.
+func (mux *muxer) unlock() {
+	var saved []RecordedEvent
+	for _, s := range []*segmenter{mux.continuous, mux.events} {
+		if s != nil {
+			saved = append(saved, s.saved...)
+			s.saved = nil
+		}
+	}
+	mux.mu.Unlock()
+
+	for _, e := range saved {
+		mux.recordOut <- e
+	}
+}
+
+// encode encodes a video access unit.
+func (mux *muxer) encode(au [][]byte, pts time.Duration) error {
+	mux.mu.Lock()
+	defer mux.unlock()
+
+	au, randomAccess := mux.codec.prepare(au)
+	if au == nil {
+		return nil
+	}
+
+	dts, ok, err := mux.codec.extractDTS(au, pts, randomAccess)
+	if err != nil || !ok {
+		return err
+	}
+
+	mux.lastPTS = pts
+	return mux.write(&sample{
+		video:        true,
+		au:           au,
+		pts:          pts,
+		dts:          dts,
+		randomAccess: randomAccess,
+		time:         time.Now(),
+	})
+}
+
+// encodeAudio encodes audio frames. pts is the PTS of the first frame, and
+// shares the same time base as the video, so that the tracks are aligned.
+func (mux *muxer) encodeAudio(frames [][]byte, pts time.Duration) error {
+	mux.mu.Lock()
+	defer mux.unlock()
+
+	if mux.audio == nil {
+		return nil
+	}
+
+	aus, pts, err := mux.audio.prepare(frames, pts)
+	if err != nil || aus == nil {
+		return err
+	}
+
+	return mux.write(&sample{au: aus, pts: pts, time: time.Now()})
+}
+
+// segment locates the video access unit of the given PTS, once encoded, into the
+// recordings: into the event being recorded, or else into the continuous recording.
+// The reference is empty when the access unit was not recorded.
+func (mux *muxer) segment(pts time.Duration) camera.SegmentRef {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	return mux.segmentOf(pts)
+}
+
+// currentSegment locates the last video access unit encoded, like segment.
+func (mux *muxer) currentSegment() camera.SegmentRef {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+
+	return mux.segmentOf(mux.lastPTS)
+}
+
+func (mux *muxer) segmentOf(pts time.Duration) camera.SegmentRef {
+	if mux.recording {
+		return mux.events.ref(pts)
+	}
+	if mux.continuous != nil {
+		return mux.continuous.ref(pts)
+	}
+	return camera.SegmentRef{}
+}
+
+// trigger starts recording an event, along with its pre-roll, or extends the
+// one being recorded, so that it ends postRoll after the last trigger.
+func (mux *muxer) trigger() error {
+	mux.mu.Lock()
+	defer mux.unlock()
+
+	if mux.events == nil {
+		return nil
+	}
+
+	mux.eventEnd = mux.lastPTS + mux.postRoll
+	if mux.recording {
+		return nil
+	}
+
+	mux.logger.Info("event recording started")
+	mux.recording = true
+	for _, smp := range mux.preRoll.flush() {
+		err := mux.events.write(smp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mux *muxer) write(smp *sample) error {
+	mux.stream.publish(smp)
+
+	if mux.continuous != nil && (mux.mode != conf.RecordHybrid || (smp.video && smp.randomAccess)) {
+		err := mux.continuous.write(smp)
+		if err != nil {
+			return err
+		}
+	}
+
+	if mux.events == nil {
+		return nil
+	}
+
+	if mux.recording && smp.video && smp.pts > mux.eventEnd {
+		mux.logger.Info("event recording ended")
+		mux.events.close()
+		mux.recording = false
+	}
+
+	if !mux.recording {
+		mux.preRoll.push(smp)
+		return nil
+	}
+	return mux.events.write(smp)
+}
@@ -0,0 +1,42 @@
+package recorder
+
+import "time"
+
+// preRollBuffer keeps the samples received in the last duration, so that
+// they can be recorded when an event happens. The buffer always starts
+// at a random access, so that it can be decoded on its own.
+type preRollBuffer struct {
+	duration time.Duration
+	samples  []*sample
+}
+
+// push adds a copy of a sample to the buffer, dropping the
+// groups of pictures that are older than the buffer duration.
+func (b *preRollBuffer) push(smp *sample) {
+	if len(b.samples) == 0 && (!smp.video || !smp.randomAccess) {
+		return
+	}
+	b.samples = append(b.samples, smp.clone())
+
+	// find the last random access that is at least duration old.
+	// Everything before it is no longer needed.
+	cut := 0
+	for i, s := range b.samples {
+		if s.pts > smp.pts-b.duration {
+			break
+		}
+		if s.video && s.randomAccess {
+			cut = i
+		}
+	}
+	if cut > 0 {
+		b.samples = append([]*sample(nil), b.samples[cut:]...)
+	}
+}
+
+// flush empties the buffer, returning its samples.
+func (b *preRollBuffer) flush() []*sample {
+	samples := b.samples
+	b.samples = nil
+	return samples
+}
@@ -0,0 +1,79 @@
+package recorder
+
+import (
+	"testing"
+	"time"
+)
+
+// gop returns the video samples of the given PTS, every step, with
+// a random access every keyframeEvery samples, starting with one.
+func gop(start time.Duration, count int, step time.Duration, keyframeEvery int) []*sample {
+	samples := make([]*sample, count)
+	for i := range samples {
+		samples[i] = &sample{
+			video:        true,
+			au:           [][]byte{{byte(i)}},
+			pts:          start + time.Duration(i)*step,
+			randomAccess: i%keyframeEvery == 0,
+		}
+	}
+	return samples
+}
+
+func TestPreRollBuffer(t *testing.T) {
+	audio := &sample{au: [][]byte{{1}}, pts: 0}
+	inter := &sample{video: true, au: [][]byte{{2}}, pts: 0}
+
+	tests := []struct {
+		name      string
+		duration  time.Duration
+		samples   []*sample
+		wantFirst time.Duration
+		wantLen   int
+	}{
+		{"empty", time.Second, nil, 0, 0},
+		{"waits for a random access", time.Second, append([]*sample{audio, inter}, gop(0, 2, 500*time.Millisecond, 4)...), 0, 2},
+		{"keeps everything within the duration", 5 * time.Second, gop(0, 9, 500*time.Millisecond, 2), 0, 9},
+		{"drops the groups older than the duration", 1500 * time.Millisecond, gop(0, 9, 500*time.Millisecond, 2), 2 * time.Second, 5},
+		{"keeps a group longer than the duration", 500 * time.Millisecond, gop(0, 8, 500*time.Millisecond, 8), 0, 8},
+		{"no duration keeps the current group", 0, gop(0, 7, 500*time.Millisecond, 3), 3 * time.Second, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &preRollBuffer{duration: tt.duration}
+			for _, s := range tt.samples {
+				b.push(s)
+			}
+
+			samples := b.flush()
+			if len(samples) != tt.wantLen {
+				t.Fatalf("buffer has %d samples, want %d", len(samples), tt.wantLen)
+			}
+			if len(samples) == 0 {
+				return
+			}
+			if !samples[0].video || !samples[0].randomAccess {
+				t.Errorf("buffer doesn't start at a random access")
+			}
+			if samples[0].pts != tt.wantFirst {
+				t.Errorf("buffer starts at %v, want %v", samples[0].pts, tt.wantFirst)
+			}
+			if len(b.flush()) != 0 {
+				t.Errorf("buffer isn't empty after a flush")
+			}
+		})
+	}
+}
+
+func TestPreRollBufferCopies(t *testing.T) {
+	b := &preRollBuffer{duration: time.Second}
+	s := gop(0, 1, 0, 1)[0]
+	b.push(s)
+
+	// the decoder reuses the buffers of the samples it receives
+	s.au[0][0] = 42
+	if got := b.flush()[0].au[0][0]; got == 42 {
+		t.Errorf("buffer shares the data of the pushed sample")
+	}
+}
@@ -1,12 +1,18 @@
 // Package recorder contains all the implementations
 // for receiving and recording media streams.
 //
-// It's implementations support RTSP and H.264 encoding.
+// It's implementations support RTSP with H.264 and H.265 encoding, MJPEG
+// over HTTP, RTMP publishers with H.264, SRT callers pushing MPEG-TS,
+// and the replay of recorded files.
 package recorder
 
 import (
-	"image"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
 )
 
 // Recorder is an interface for a recorder component.
@@ -18,23 +24,87 @@ type Recorder interface {
 	Stop() error
 	setupLogger()
 	record() error
-	sendFrame(image.Image) error
+	sendFrame(camera.Frame) error
+}
+
+// NewRecorder allocates the recorder of a camera, from the scheme of its URL:
+// a MJPEGRecorder for http:// and https:// URLs, a RTMPRecorder for rtmp:// URLs,
+// a SRTRecorder for srt:// URLs, a FileRecorder for file:// URLs, or a RTSP recorder otherwise.
+func NewRecorder(camera conf.CameraConfig, eChans EventChannels) Recorder {
+	u, err := url.Parse(camera.URL)
+	if err == nil {
+		switch strings.ToLower(u.Scheme) {
+		case "http", "https":
+			return NewMJPEGRecorder(camera, eChans)
+		case "rtmp":
+			return NewRTMPRecorder(camera, eChans)
+		case "srt":
+			return NewSRTRecorder(camera, eChans)
+		case "file":
+			return NewFileRecorder(camera, eChans)
+		}
+	}
+	return NewRTSP_H264Recorder(camera, eChans)
 }
 
 // EventChannels are the available channels for
 // communicating the data generated by the recorder component
-// notice that all the channels for the recorder are just
+// notice that almost all the channels for the recorder are just
 // for inserting events. The recorder simply generates
-// data for the other components to work over.
+// data for the other components to work over. The only
+// exception is TriggerIn, that starts event recordings.
+// Stream, that can be nil, shares the received access units with the
+// live outputs of the camera.
 type EventChannels struct {
 	RecordOut chan<- RecordedEvent
-	FrameOut  chan<- image.Image
+	FrameOut  chan<- camera.Frame
+	FeedOut   chan<- FeedEvent
+	TriggerIn <-chan Trigger
+	Stream    *Stream
 }
 
+// Trigger starts an event recording, or extends the one being
+// recorded, on cameras that are recorded on events.
+type Trigger struct {
+	CameraID string
+	Reason   string
+	Time     time.Time
+}
+
+// RecordingKind tells what a recording contains.
+type RecordingKind string
+
+const (
+	RecordingContinuous RecordingKind = "continuous" // The whole feed
+	RecordingKeyframes  RecordingKind = "keyframes"  // Only the keyframes of the feed, without audio
+	RecordingEvent      RecordingKind = "event"      // An event, along with its pre-roll and post-roll
+)
+
 // RecordedEvent is used to communicate via channels
 // when a recording is saved.
 type RecordedEvent struct {
 	Path      string    `gorm:"type:text"` 
+	CameraID  string    `gorm:"type:text;index"` // Camera the recording came from
+	Camera    *camera.Camera `json:",omitempty"`
+	Kind      RecordingKind `gorm:"type:text;index"`
     StartTime  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
     EndTime  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
 }
+
+// FeedStatus tells if the feed of a camera is being received.
+type FeedStatus string
+
+const (
+	FeedDown FeedStatus = "down" // The feed dropped or couldn't be reached
+	FeedUp   FeedStatus = "up"   // The feed is being recorded again
+)
+
+// FeedEvent is used to communicate via channels
+// when the feed of a camera goes down or comes back up.
+type FeedEvent struct {
+	CameraID  string     `gorm:"type:text;index"`
+	Camera    *camera.Camera `json:",omitempty"`
+	Status    FeedStatus `gorm:"type:text"`
+	Reason    string     `gorm:"type:text"` // Error that brought the feed down
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+}
@@ -0,0 +1,563 @@
+package recorder
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+)
+
+// RTMP message types.
+const (
+	rtmpTypeSetChunkSize     = 1
+	rtmpTypeAbort            = 2
+	rtmpTypeAcknowledgement  = 3
+	rtmpTypeUserControl      = 4
+	rtmpTypeWindowAckSize    = 5
+	rtmpTypeSetPeerBandwidth = 6
+	rtmpTypeAudio            = 8
+	rtmpTypeVideo            = 9
+	rtmpTypeDataAMF3         = 15
+	rtmpTypeCommandAMF3      = 17
+	rtmpTypeDataAMF0         = 18
+	rtmpTypeCommandAMF0      = 20
+)
+
+const (
+	rtmpHandshakeSize = 1536
+	rtmpWindowAckSize = 2500000
+
+	// rtmpMaxMessageSize is the maximum size of a message, so that
+	// a broken publisher can't fill the memory.
+	rtmpMaxMessageSize = 10 * 1024 * 1024
+
+	// rtmpChunkStreamControl and rtmpChunkStreamCommand are the chunk
+	// streams the server sends its control messages and commands on.
+	rtmpChunkStreamControl = 2
+	rtmpChunkStreamCommand = 3
+)
+
+// rtmpMessage is a message received from, or sent to, a RTMP peer.
+type rtmpMessage struct {
+	typ       uint8
+	streamID  uint32
+	timestamp uint32 // in milliseconds
+	payload   []byte
+}
+
+// rtmpChunkStream is the state of a chunk stream that messages are received on.
+// Chunk headers can omit what is the same as in the previous chunk of the stream.
+type rtmpChunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte // the message being received
+}
+
+// rtmpConn reads and writes the messages of a RTMP connection. It only
+// implements what is needed to receive a published stream: the simple
+// handshake, the chunk stream and the AMF0 commands.
+type rtmpConn struct {
+	nc net.Conn
+	br *bufio.Reader
+
+	readChunkSize  uint32
+	writeChunkSize uint32
+	chunkStreams   map[uint32]*rtmpChunkStream
+
+	// the peer must be acknowledged every windowAckSize bytes received
+	windowAckSize uint32
+	received      uint32
+	lastAck       uint32
+}
+
+func newRTMPConn(nc net.Conn) *rtmpConn {
+	return &rtmpConn{
+		nc:             nc,
+		br:             bufio.NewReader(nc),
+		readChunkSize:  128,
+		writeChunkSize: 128,
+		chunkStreams:   make(map[uint32]*rtmpChunkStream),
+	}
+}
+
+// Read implements io.Reader, counting the bytes received.
+func (c *rtmpConn) Read(p []byte) (int, error) {
+	n, err := c.br.Read(p)
+	c.received += uint32(n)
+	return n, err
+}
+
+// handshake performs the server side of the simple handshake,
+// that is accepted by the publishers that offer the complex one too.
+func (c *rtmpConn) handshake() error {
+	c0c1 := make([]byte, 1+rtmpHandshakeSize)
+	_, err := io.ReadFull(c, c0c1)
+	if err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported RTMP version %d", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+2*rtmpHandshakeSize)
+	s0s1s2[0] = 3
+	_, err = rand.Read(s0s1s2[1+8 : 1+rtmpHandshakeSize])
+	if err != nil {
+		return err
+	}
+	copy(s0s1s2[1+rtmpHandshakeSize:], c0c1[1:])
+	_, err = c.nc.Write(s0s1s2)
+	if err != nil {
+		return err
+	}
+
+	c2 := make([]byte, rtmpHandshakeSize)
+	_, err = io.ReadFull(c, c2)
+	return err
+}
+
+// readMessage reads chunks until a whole message is received. The protocol
+// control messages are handled here, and are returned too.
+func (c *rtmpConn) readMessage() (*rtmpMessage, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+
+		if c.windowAckSize != 0 && c.received-c.lastAck >= c.windowAckSize {
+			c.lastAck = c.received
+			err = c.writeControl(rtmpTypeAcknowledgement, uint32Bytes(c.received))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if msg == nil {
+			continue
+		}
+
+		switch msg.typ {
+		case rtmpTypeSetChunkSize:
+			if len(msg.payload) < 4 {
+				return nil, errors.New("invalid set chunk size message")
+			}
+			c.readChunkSize = binary.BigEndian.Uint32(msg.payload) & 0x7FFFFFFF
+			if c.readChunkSize == 0 {
+				return nil, errors.New("invalid chunk size")
+			}
+
+		case rtmpTypeAbort:
+			if len(msg.payload) >= 4 {
+				if cs, ok := c.chunkStreams[binary.BigEndian.Uint32(msg.payload)]; ok {
+					cs.payload = nil
+				}
+			}
+
+		case rtmpTypeWindowAckSize:
+			if len(msg.payload) >= 4 {
+				c.windowAckSize = binary.BigEndian.Uint32(msg.payload)
+			}
+		}
+
+		return msg, nil
+	}
+}
+
+// readChunk reads a chunk, and returns the message it completes, if any.
+func (c *rtmpConn) readChunk() (*rtmpMessage, error) {
+	b, err := c.readByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b >> 6
+	csID := uint32(b & 0x3F)
+	switch csID {
+	case 0:
+		b, err := c.readBytes(1)
+		if err != nil {
+			return nil, err
+		}
+		csID = 64 + uint32(b[0])
+	case 1:
+		b, err := c.readBytes(2)
+		if err != nil {
+			return nil, err
+		}
+		csID = 64 + uint32(b[0]) + uint32(b[1])*256
+	}
+
+	cs, ok := c.chunkStreams[csID]
+	if !ok {
+		if format != 0 {
+			return nil, fmt.Errorf("chunk stream %d doesn't start with a full header", csID)
+		}
+		cs = &rtmpChunkStream{}
+		c.chunkStreams[csID] = cs
+	}
+
+	var timestamp uint32
+	switch format {
+	case 0, 1, 2:
+		size := [3]int{11, 7, 3}[format]
+		h, err := c.readBytes(size)
+		if err != nil {
+			return nil, err
+		}
+		timestamp = uint24(h[0:3])
+		if format <= 1 {
+			cs.length = uint24(h[3:6])
+			cs.typ = h[6]
+		}
+		if format == 0 {
+			cs.streamID = binary.LittleEndian.Uint32(h[7:11])
+		}
+		cs.extended = timestamp == 0xFFFFFF
+	}
+
+	if cs.extended {
+		h, err := c.readBytes(4)
+		if err != nil {
+			return nil, err
+		}
+		timestamp = binary.BigEndian.Uint32(h)
+	}
+
+	// the timestamp is set by the first chunk of a message
+	if cs.payload == nil {
+		switch format {
+		case 0:
+			cs.timestamp = timestamp
+			cs.delta = 0
+		case 1, 2:
+			cs.delta = timestamp
+			cs.timestamp += timestamp
+		case 3:
+			cs.timestamp += cs.delta
+		}
+
+		if cs.length > rtmpMaxMessageSize {
+			return nil, fmt.Errorf("message is bigger than %d bytes", rtmpMaxMessageSize)
+		}
+		cs.payload = make([]byte, 0, cs.length)
+	}
+
+	n := cs.length - uint32(len(cs.payload))
+	if n > c.readChunkSize {
+		n = c.readChunkSize
+	}
+	data, err := c.readBytes(int(n))
+	if err != nil {
+		return nil, err
+	}
+	cs.payload = append(cs.payload, data...)
+
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+
+	msg := &rtmpMessage{
+		typ:       cs.typ,
+		streamID:  cs.streamID,
+		timestamp: cs.timestamp,
+		payload:   cs.payload,
+	}
+	cs.payload = nil
+	return msg, nil
+}
+
+func (c *rtmpConn) readByte() (byte, error) {
+	b, err := c.readBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (c *rtmpConn) readBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(c, b)
+	return b, err
+}
+
+// writeMessage writes a message on a chunk stream, splitting it into chunks.
+func (c *rtmpConn) writeMessage(csID uint8, msg *rtmpMessage) error {
+	buf := make([]byte, 0, 12+len(msg.payload)+len(msg.payload)/int(c.writeChunkSize))
+
+	extended := msg.timestamp >= 0xFFFFFF
+
+	buf = append(buf, csID)
+	if extended {
+		buf = appendUint24(buf, 0xFFFFFF)
+	} else {
+		buf = appendUint24(buf, msg.timestamp)
+	}
+	buf = appendUint24(buf, uint32(len(msg.payload)))
+	buf = append(buf, msg.typ)
+	buf = binary.LittleEndian.AppendUint32(buf, msg.streamID)
+	if extended {
+		buf = binary.BigEndian.AppendUint32(buf, msg.timestamp)
+	}
+
+	payload := msg.payload
+	for {
+		n := len(payload)
+		if n > int(c.writeChunkSize) {
+			n = int(c.writeChunkSize)
+		}
+		buf = append(buf, payload[:n]...)
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		}
+		// the next chunks only have a basic header, and the extended timestamp
+		buf = append(buf, 0xC0|csID)
+		if extended {
+			buf = binary.BigEndian.AppendUint32(buf, msg.timestamp)
+		}
+	}
+
+	c.nc.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := c.nc.Write(buf)
+	return err
+}
+
+// writeControl writes a protocol control message.
+func (c *rtmpConn) writeControl(typ uint8, payload []byte) error {
+	return c.writeMessage(rtmpChunkStreamControl, &rtmpMessage{typ: typ, payload: payload})
+}
+
+// writeCommand writes an AMF0 command on the given message stream.
+func (c *rtmpConn) writeCommand(streamID uint32, values ...interface{}) error {
+	payload, err := amf0Marshal(values...)
+	if err != nil {
+		return err
+	}
+	return c.writeMessage(rtmpChunkStreamCommand, &rtmpMessage{
+		typ:      rtmpTypeCommandAMF0,
+		streamID: streamID,
+		payload:  payload,
+	})
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func uint32Bytes(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+// AMF0 markers.
+const (
+	amf0Number      = 0x00
+	amf0Boolean     = 0x01
+	amf0String      = 0x02
+	amf0Object      = 0x03
+	amf0Null        = 0x05
+	amf0Undefined   = 0x06
+	amf0ECMAArray   = 0x08
+	amf0ObjectEnd   = 0x09
+	amf0StrictArray = 0x0A
+	amf0Date        = 0x0B
+	amf0LongString  = 0x0C
+)
+
+// amf0Property is a property of an AMF0 object. Objects are kept
+// as lists of properties, as the peers can depend on their order.
+type amf0Property struct {
+	key   string
+	value interface{}
+}
+
+type amf0Obj []amf0Property
+
+// get returns the value of a property, or nil.
+func (o amf0Obj) get(key string) interface{} {
+	for _, p := range o {
+		if p.key == key {
+			return p.value
+		}
+	}
+	return nil
+}
+
+// amf0Unmarshal decodes all the AMF0 values of a payload. Numbers are
+// float64, strings are string, objects and ECMA arrays are amf0Obj, and
+// null and undefined are nil.
+func amf0Unmarshal(b []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(b) > 0 {
+		v, n, err := amf0UnmarshalValue(b)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		b = b[n:]
+	}
+	return values, nil
+}
+
+func amf0UnmarshalValue(b []byte) (interface{}, int, error) {
+	errShort := errors.New("AMF0 value is too short")
+	if len(b) < 1 {
+		return nil, 0, errShort
+	}
+
+	switch b[0] {
+	case amf0Number:
+		if len(b) < 9 {
+			return nil, 0, errShort
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[1:9])), 9, nil
+
+	case amf0Boolean:
+		if len(b) < 2 {
+			return nil, 0, errShort
+		}
+		return b[1] != 0, 2, nil
+
+	case amf0String:
+		s, n, err := amf0UnmarshalString(b[1:])
+		return s, 1 + n, err
+
+	case amf0LongString:
+		if len(b) < 5 {
+			return nil, 0, errShort
+		}
+		l := int(binary.BigEndian.Uint32(b[1:5]))
+		if len(b) < 5+l {
+			return nil, 0, errShort
+		}
+		return string(b[5 : 5+l]), 5 + l, nil
+
+	case amf0Null, amf0Undefined:
+		return nil, 1, nil
+
+	case amf0Object, amf0ECMAArray:
+		pos := 1
+		if b[0] == amf0ECMAArray {
+			pos += 4 // the count, that is not reliable
+		}
+		var obj amf0Obj
+		for {
+			if len(b) < pos+3 {
+				return nil, 0, errShort
+			}
+			// the end marker is an empty key followed by the end marker
+			if b[pos] == 0 && b[pos+1] == 0 && b[pos+2] == amf0ObjectEnd {
+				return obj, pos + 3, nil
+			}
+			key, n, err := amf0UnmarshalString(b[pos:])
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += n
+			v, n, err := amf0UnmarshalValue(b[pos:])
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += n
+			obj = append(obj, amf0Property{key: key, value: v})
+		}
+
+	case amf0StrictArray:
+		if len(b) < 5 {
+			return nil, 0, errShort
+		}
+		count := int(binary.BigEndian.Uint32(b[1:5]))
+		pos := 5
+		arr := make([]interface{}, 0)
+		for i := 0; i < count; i++ {
+			v, n, err := amf0UnmarshalValue(b[pos:])
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += n
+			arr = append(arr, v)
+		}
+		return arr, pos, nil
+
+	case amf0Date:
+		if len(b) < 11 {
+			return nil, 0, errShort
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b[1:9])), 11, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported AMF0 marker %d", b[0])
+}
+
+func amf0UnmarshalString(b []byte) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, errors.New("AMF0 string is too short")
+	}
+	l := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+l {
+		return "", 0, errors.New("AMF0 string is too short")
+	}
+	return string(b[2 : 2+l]), 2 + l, nil
+}
+
+// amf0Marshal encodes values of the types returned by amf0Unmarshal.
+func amf0Marshal(values ...interface{}) ([]byte, error) {
+	var b []byte
+	for _, v := range values {
+		var err error
+		b, err = amf0AppendValue(b, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func amf0AppendValue(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case float64:
+		b = append(b, amf0Number)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v)), nil
+
+	case bool:
+		if v {
+			return append(b, amf0Boolean, 1), nil
+		}
+		return append(b, amf0Boolean, 0), nil
+
+	case string:
+		b = append(b, amf0String)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		return append(b, v...), nil
+
+	case nil:
+		return append(b, amf0Null), nil
+
+	case amf0Obj:
+		b = append(b, amf0Object)
+		for _, p := range v {
+			b = binary.BigEndian.AppendUint16(b, uint16(len(p.key)))
+			b = append(b, p.key...)
+			var err error
+			b, err = amf0AppendValue(b, p.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(b, 0, 0, amf0ObjectEnd), nil
+	}
+
+	return nil, fmt.Errorf("unsupported AMF0 value %T", v)
+}
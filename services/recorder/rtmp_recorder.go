@@ -0,0 +1,487 @@
+package recorder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/mpeg4audio"
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/helpers"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+)
+
+// rtmpReadTimeout is how long the publisher can stay silent before it is dropped.
+const rtmpReadTimeout = 10 * time.Second
+
+// errRTMPUnpublished is returned when the publisher stops publishing.
+var errRTMPUnpublished = errors.New("the publisher stopped publishing")
+
+// RTMPRecorder records the stream that an encoder publishes through RTMP. It
+// listens on the host and port of the camera URL, such as rtmp://:1935/live/mystream,
+// and accepts a single publisher, whose application and stream name must match
+// the URL path. Each camera needs its own port.
+//
+// H264 video is recorded, along with AAC audio when the publisher sends it
+// before the first keyframe.
+type RTMPRecorder struct {
+	feed
+	listener net.Listener
+	address  string
+	path     string
+}
+
+// This code requires the FFmpeg libraries, like the RTSP recorder.
+func NewRTMPRecorder(camera conf.CameraConfig, eChans EventChannels) *RTMPRecorder {
+	r := &RTMPRecorder{
+		feed: newFeed(camera, eChans),
+	}
+	r.setupLogger()
+
+	return r
+}
+
+func (r *RTMPRecorder) setupLogger() {
+	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", r.camera.ID)
+}
+
+func (r *RTMPRecorder) Start() error {
+	u, err := url.Parse(r.camera.URL)
+	if err != nil {
+		r.logger.Errorf("failed to parse url: %v", err)
+		return err
+	}
+	r.address = u.Host
+	if u.Port() == "" {
+		r.address = net.JoinHostPort(u.Hostname(), "1935")
+	}
+	r.path = strings.Trim(u.Path, "/")
+
+	cfg, _ := conf.ReadConf()
+	err = helpers.EnsureDirectoryExists(r.camera.RecordingsPath(cfg.Recorder.RecordingsDir))
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.backoff = newBackoff(cfg.Recorder.Reconnect)
+
+	r.listener, err = net.Listen("tcp", r.address)
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.logger.Infof("waiting for a RTMP publisher on %s", r.address)
+
+	r.wg.Add(1)
+	go r.supervise(r.record)
+	return nil
+}
+
+func (r *RTMPRecorder) Stop() error {
+	close(r.stopCh)
+	r.listener.Close()
+	r.wg.Wait()
+	return nil
+}
+
+// record runs a single recording session, from the publisher
+// connecting until it goes away or the recorder is stopped.
+func (r *RTMPRecorder) record() error {
+	nc, err := r.listener.Accept()
+	if err != nil {
+		select {
+		case <-r.stopCh:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	s := &rtmpSession{
+		r:     r,
+		conn:  newRTMPConn(nc),
+		muxCh: make(chan *muxer, 1),
+	}
+
+	// the connection is read by another goroutine, so
+	// that triggers are handled while waiting for it
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- s.run()
+	}()
+
+	defer s.close()
+
+	var mux *muxer
+	for {
+		select {
+		case <-r.stopCh:
+			r.logger.Info("received stop signal")
+			nc.Close()
+			<-readErrCh
+			return nil
+		case err := <-readErrCh:
+			return err
+		case t := <-r.eChans.TriggerIn:
+			r.logger.Debugf("event triggered: %s", t.Reason)
+			if mux == nil {
+				continue
+			}
+			err := mux.trigger()
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
+		case mux = <-s.muxCh:
+		}
+	}
+}
+
+// rtmpSession is the connection of a publisher.
+type rtmpSession struct {
+	r    *RTMPRecorder
+	conn *rtmpConn
+
+	publishing bool
+
+	codec    *h264Codec
+	audio    *aacCodec
+	frameDec frameDecoder
+	sampler  *frameSampler
+
+	// mux is created at the first keyframe, once the tracks are known.
+	// muxCh hands it over to the recorder, for the triggers.
+	mux   *muxer
+	muxCh chan *muxer
+
+	// frames can't be decoded before a random access access unit is received
+	randomAccessReceived bool
+}
+
+// close closes the connection, the muxer and the decoder. It is called once run returned.
+func (s *rtmpSession) close() {
+	s.conn.nc.Close()
+	if s.mux != nil {
+		s.mux.close()
+	}
+	if s.frameDec != nil {
+		s.frameDec.close()
+	}
+}
+
+func (s *rtmpSession) run() error {
+	s.conn.nc.SetReadDeadline(time.Now().Add(rtmpReadTimeout))
+	err := s.conn.handshake()
+	if err != nil {
+		return fmt.Errorf("handshake failed: %w", err)
+	}
+
+	var app string
+	for {
+		s.conn.nc.SetReadDeadline(time.Now().Add(rtmpReadTimeout))
+		msg, err := s.conn.readMessage()
+		if err != nil {
+			return err
+		}
+
+		switch msg.typ {
+		case rtmpTypeCommandAMF3, rtmpTypeCommandAMF0:
+			payload := msg.payload
+			if msg.typ == rtmpTypeCommandAMF3 && len(payload) > 0 {
+				payload = payload[1:]
+			}
+			err = s.onCommand(msg.streamID, payload, &app)
+
+		case rtmpTypeVideo:
+			if s.publishing {
+				err = s.onVideo(msg)
+			}
+
+		case rtmpTypeAudio:
+			if s.publishing {
+				err = s.onAudio(msg)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// onCommand answers the commands that lead to publishing a stream.
+func (s *rtmpSession) onCommand(streamID uint32, payload []byte, app *string) error {
+	values, err := amf0Unmarshal(payload)
+	if err != nil {
+		return err
+	}
+	if len(values) < 2 {
+		return nil
+	}
+	name, _ := values[0].(string)
+	txID, _ := values[1].(float64)
+
+	switch name {
+	case "connect":
+		if len(values) >= 3 {
+			if obj, ok := values[2].(amf0Obj); ok {
+				*app, _ = obj.get("app").(string)
+			}
+		}
+
+		err = s.conn.writeControl(rtmpTypeWindowAckSize, uint32Bytes(rtmpWindowAckSize))
+		if err != nil {
+			return err
+		}
+		err = s.conn.writeControl(rtmpTypeSetPeerBandwidth, append(uint32Bytes(rtmpWindowAckSize), 2))
+		if err != nil {
+			return err
+		}
+		err = s.conn.writeControl(rtmpTypeSetChunkSize, uint32Bytes(4096))
+		if err != nil {
+			return err
+		}
+		s.conn.writeChunkSize = 4096
+
+		return s.conn.writeCommand(0, "_result", txID,
+			amf0Obj{
+				{key: "fmsVer", value: "FMS/3,0,1,123"},
+				{key: "capabilities", value: float64(31)},
+			},
+			amf0Obj{
+				{key: "level", value: "status"},
+				{key: "code", value: "NetConnection.Connect.Success"},
+				{key: "description", value: "Connection succeeded."},
+				{key: "objectEncoding", value: float64(0)},
+			})
+
+	case "createStream":
+		return s.conn.writeCommand(0, "_result", txID, nil, float64(1))
+
+	case "publish":
+		var streamName string
+		if len(values) >= 4 {
+			streamName, _ = values[3].(string)
+		}
+		path := strings.Trim(stripQuery(*app)+"/"+stripQuery(streamName), "/")
+
+		if path != s.r.path {
+			s.r.logger.Warnf("rejected a publisher of %q", path)
+			s.conn.writeCommand(streamID, "onStatus", float64(0), nil, amf0Obj{
+				{key: "level", value: "error"},
+				{key: "code", value: "NetStream.Publish.BadName"},
+				{key: "description", value: "unknown stream " + path},
+			})
+			return fmt.Errorf("a publisher tried to publish %q", path)
+		}
+
+		s.r.logger.Info("recording...")
+		s.publishing = true
+		return s.conn.writeCommand(streamID, "onStatus", float64(0), nil, amf0Obj{
+			{key: "level", value: "status"},
+			{key: "code", value: "NetStream.Publish.Start"},
+			{key: "description", value: "Publishing " + path},
+		})
+
+	case "play":
+		return fmt.Errorf("reading streams is not supported")
+
+	case "FCUnpublish", "deleteStream", "closeStream":
+		if s.publishing {
+			return errRTMPUnpublished
+		}
+	}
+
+	return nil
+}
+
+func stripQuery(s string) string {
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// onVideo handles a FLV video tag.
+func (s *rtmpSession) onVideo(msg *rtmpMessage) error {
+	if len(msg.payload) < 5 {
+		return nil
+	}
+	if msg.payload[0]&0x0F != 7 {
+		return fmt.Errorf("unsupported video codec: only H264 can be published")
+	}
+	packetType := msg.payload[1]
+	cts := int32(uint24(msg.payload[2:5])<<8) >> 8 // signed
+	data := msg.payload[5:]
+
+	switch packetType {
+	case 0: // AVCDecoderConfigurationRecord
+		sps, pps, err := parseAVCDecoderConfig(data)
+		if err != nil {
+			return err
+		}
+		if s.codec == nil {
+			s.codec = &h264Codec{sps: sps, pps: pps}
+			s.r.logger.Infof("recording %s video", s.codec.name())
+		}
+		return nil
+
+	case 1: // NALUs
+		if s.codec == nil {
+			return nil
+		}
+		au, err := h264.AVCCUnmarshal(data)
+		if err != nil {
+			return err
+		}
+		pts := time.Duration(int64(msg.timestamp)+int64(cts)) * time.Millisecond
+		return s.onAccessUnit(au, pts)
+	}
+	return nil
+}
+
+// onAccessUnit decodes a video access unit into frames and records it. The
+// session is set up at the first keyframe, when the audio is known too.
+func (s *rtmpSession) onAccessUnit(au [][]byte, pts time.Duration) error {
+	if s.mux == nil {
+		if !s.codec.isRandomAccess(au) {
+			return nil
+		}
+
+		var audio audioCodec
+		if s.audio != nil {
+			audio = s.audio
+			s.r.logger.Infof("recording %s audio", s.audio.name())
+		} else {
+			s.r.logger.Info("no audio to record")
+		}
+
+		frameDec, err := s.codec.newDecoder(analyticsMaxSize(s.r.camera.Analytics))
+		if err != nil {
+			return err
+		}
+		s.frameDec = frameDec
+		s.sampler = newFrameSampler(s.r.camera.Analytics)
+		for _, param := range s.codec.parameters() {
+			s.frameDec.decode(param)
+		}
+
+		s.mux = newMuxer(s.r.camera, s.codec, audio, s.r.eChans.RecordOut, s.r.eChans.Stream)
+		s.muxCh <- s.mux
+		s.r.feedPlaying()
+	}
+
+	keyframe := s.codec.isRandomAccess(au)
+	if !s.randomAccessReceived {
+		s.randomAccessReceived = keyframe
+	}
+
+	// the access unit is recorded first, so that its frames can point to the segment it is in
+	err := s.mux.encode(au, pts)
+	if err != nil {
+		s.r.logger.Errorf("%v", err)
+	}
+
+	// only the sampled frames are converted and sent to the recognizers. When only
+	// the keyframes are analyzed, the other access units are not decoded at all
+	if !s.randomAccessReceived || !s.sampler.decode(keyframe) {
+		return nil
+	}
+	sampled := s.sampler.sample(pts)
+
+	for _, nalu := range au {
+		if !sampled {
+			s.frameDec.skip(nalu)
+			continue
+		}
+
+		img, err := s.frameDec.decode(nalu)
+		if err != nil {
+			s.r.logger.Errorf("Failed to decode NALU: %v", err)
+			continue
+		}
+		if img == nil {
+			continue
+		}
+
+		err = s.r.sendFrame(s.r.newFrame(img, s.frameDec.framePool(), pts, keyframe, s.mux.segment(pts)))
+		if err != nil {
+			s.r.logger.Errorf("Failed to send frame: %v", err)
+		}
+	}
+	return nil
+}
+
+// onAudio handles a FLV audio tag. Only AAC is recorded.
+func (s *rtmpSession) onAudio(msg *rtmpMessage) error {
+	if len(msg.payload) < 2 || msg.payload[0]>>4 != 10 {
+		return nil
+	}
+
+	switch msg.payload[1] {
+	case 0: // AudioSpecificConfig
+		if s.audio != nil {
+			return nil
+		}
+		var config mpeg4audio.Config
+		err := config.Unmarshal(msg.payload[2:])
+		if err != nil {
+			return err
+		}
+		s.audio = &aacCodec{config: &config}
+
+	case 1: // raw access unit
+		if s.mux == nil {
+			return nil
+		}
+		pts := time.Duration(msg.timestamp) * time.Millisecond
+		err := s.mux.encodeAudio([][]byte{msg.payload[2:]}, pts)
+		if err != nil {
+			s.r.logger.Errorf("%v", err)
+		}
+	}
+	return nil
+}
+
+// parseAVCDecoderConfig returns the first SPS and PPS of an AVCDecoderConfigurationRecord.
+func parseAVCDecoderConfig(b []byte) ([]byte, []byte, error) {
+	errInvalid := errors.New("invalid AVC decoder configuration")
+	if len(b) < 6 {
+		return nil, nil, errInvalid
+	}
+
+	readSets := func(b []byte, count int) ([][]byte, []byte, error) {
+		var sets [][]byte
+		for i := 0; i < count; i++ {
+			if len(b) < 2 {
+				return nil, nil, errInvalid
+			}
+			l := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+l {
+				return nil, nil, errInvalid
+			}
+			sets = append(sets, b[2:2+l])
+			b = b[2+l:]
+		}
+		return sets, b, nil
+	}
+
+	spss, rest, err := readSets(b[6:], int(b[5]&0x1F))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) < 1 {
+		return nil, nil, errInvalid
+	}
+	ppss, _, err := readSets(rest[1:], int(rest[0]))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(spss) == 0 || len(ppss) == 0 {
+		return nil, nil, errInvalid
+	}
+	return spss[0], ppss[0], nil
+}
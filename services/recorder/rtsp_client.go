@@ -0,0 +1,98 @@
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+
+	"github.com/bluenviron/gortsplib/v4"
+)
+
+// defaultRTSPTimeout is used when the camera doesn't set the RTSP timeouts.
+const defaultRTSPTimeout = 10 * time.Second
+
+// newRTSPClient allocates a RTSP client with the transport, the timeouts
+// and the TLS settings of the camera.
+func newRTSPClient(settings conf.RTSPClientConfig) (*gortsplib.Client, error) {
+	client := &gortsplib.Client{
+		ReadTimeout:  defaultRTSPTimeout,
+		WriteTimeout: defaultRTSPTimeout,
+	}
+	if settings.ReadTimeout > 0 {
+		client.ReadTimeout = time.Duration(settings.ReadTimeout) * time.Second
+	}
+	if settings.WriteTimeout > 0 {
+		client.WriteTimeout = time.Duration(settings.WriteTimeout) * time.Second
+	}
+
+	var transport gortsplib.Transport
+	switch settings.Transport {
+	case conf.TransportUDP:
+		transport = gortsplib.TransportUDP
+		client.Transport = &transport
+	case conf.TransportTCP:
+		transport = gortsplib.TransportTCP
+		client.Transport = &transport
+	case conf.TransportMulticast:
+		transport = gortsplib.TransportUDPMulticast
+		client.Transport = &transport
+	}
+
+	tlsConfig, err := newTLSConfig(settings.TLS)
+	if err != nil {
+		return nil, err
+	}
+	client.TLSConfig = tlsConfig
+
+	return client, nil
+}
+
+// newTLSConfig returns the TLS configuration used to connect to a RTSPS camera.
+func newTLSConfig(settings conf.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+	}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.Fingerprint != "" {
+		fingerprint, err := hex.DecodeString(strings.ReplaceAll(settings.Fingerprint, ":", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS fingerprint: %w", err)
+		}
+
+		// the chain is not verified, the certificate is pinned instead
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("the server sent no certificate")
+			}
+			hash := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !bytes.Equal(hash[:], fingerprint) {
+				return fmt.Errorf("the server certificate fingerprint %x doesn't match", hash)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
@@ -1,40 +1,30 @@
 package recorder
 
 import (
-	"image"
-	"sync"
+	"errors"
+	"fmt"
 
 	"github.com/pedrohba1/SSCS/services/conf"
 	"github.com/pedrohba1/SSCS/services/helpers"
 	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
 
-	"github.com/aler9/gortsplib/pkg/h264"
-	"github.com/bluenviron/gortsplib/v4"
 	"github.com/bluenviron/gortsplib/v4/pkg/base"
-	"github.com/bluenviron/gortsplib/v4/pkg/format"
-	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
 	"github.com/pion/rtp"
-	"github.com/sirupsen/logrus"
 )
 
+// RTSP_H264Recorder records a RTSP camera. When the camera has a substream,
+// the main stream is only recorded, and the substream is decoded for the recognizers.
 type RTSP_H264Recorder struct {
-	rtspURL string
-	client  *gortsplib.Client
-	logger  *logrus.Entry
-
-	wg sync.WaitGroup
-
-	eChans EventChannels
-	stopCh chan struct{}
+	feed
+	rtspURL      string
+	substreamURL string // empty when the camera has no substream
 }
 
 // This code requires the FFmpeg libraries, that can be installed with this command:
 // apt install -y libavformat-dev libswscale-dev gcc pkg-config
-func NewRTSP_H264Recorder(rtspURL string, eChans EventChannels) *RTSP_H264Recorder {
+func NewRTSP_H264Recorder(camera conf.CameraConfig, eChans EventChannels) *RTSP_H264Recorder {
 	r := &RTSP_H264Recorder{
-		rtspURL: rtspURL,
-		eChans:  eChans,
-		stopCh:  make(chan struct{}),
+		feed: newFeed(camera, eChans),
 	}
 	r.setupLogger()
 
@@ -42,201 +32,306 @@ func NewRTSP_H264Recorder(rtspURL string, eChans EventChannels) *RTSP_H264Record
 }
 
 func (r *RTSP_H264Recorder) setupLogger() {
-	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder")
+	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", r.camera.ID)
 }
 
 func (r *RTSP_H264Recorder) Start() error {
-	u, err := base.ParseURL(r.rtspURL)
+	rtspURL, err := r.camera.StreamURL()
 	if err != nil {
-		r.logger.Error("failed to parse url: %w", err)
+		r.logger.Errorf("failed to parse url: %v", err)
 		return err
 	}
-	r.client = &gortsplib.Client{}
+	r.rtspURL = rtspURL
 
-	// connect to the server
-	err = r.client.Start(u.Scheme, u.Host)
+	_, err = base.ParseURL(r.rtspURL)
+	if err != nil {
+		r.logger.Errorf("failed to parse url: %v", r.camera.Redact(err.Error()))
+		return errors.New(r.camera.Redact(err.Error()))
+	}
+
+	if r.camera.Substream != "" {
+		substreamURL, err := r.camera.SubstreamURL()
+		if err != nil {
+			r.logger.Errorf("failed to parse substream url: %v", err)
+			return err
+		}
+		_, err = base.ParseURL(substreamURL)
+		if err != nil {
+			r.logger.Errorf("failed to parse substream url: %v", r.camera.Redact(err.Error()))
+			return errors.New(r.camera.Redact(err.Error()))
+		}
+		r.substreamURL = substreamURL
+	}
+
+	// the TLS settings are checked before recording, as a wrong file won't fix itself
+	_, err = newTLSConfig(r.camera.RTSP.TLS)
 	if err != nil {
-		r.logger.Error("failed to start RTSP client: %w", err)
+		r.logger.Errorf("%v", err)
 		return err
 	}
 
 	// Ensure the recordings directory exists
 	cfg, _ := conf.ReadConf()
-	err = helpers.EnsureDirectoryExists(cfg.Recorder.RecordingsDir)
+	err = helpers.EnsureDirectoryExists(r.camera.RecordingsPath(cfg.Recorder.RecordingsDir))
 	if err != nil {
 		r.logger.Errorf("%v", err)
 		return err
 	}
+	r.backoff = newBackoff(cfg.Recorder.Reconnect)
 
 	r.wg.Add(1)
-	go r.record()
+	go r.supervise(r.record)
 	return nil
 }
 
 func (r *RTSP_H264Recorder) Stop() error {
-	close(r.stopCh)  // Signal the recording goroutine to stop
-	r.wg.Wait()      // Wait for the recording goroutine to finish
-	r.client.Close() // Close the RTSP connection
+	close(r.stopCh) // Signal the recording goroutine to stop
+	r.wg.Wait()     // Wait for the recording goroutine to finish
 	return nil
 }
 
-func (r *RTSP_H264Recorder) sendFrame(frame image.Image) error {
-	select {
-	case r.eChans.FrameOut <- frame:
-		return nil
-	case <-r.stopCh:
-		r.logger.Info("received stop signal")
-		return nil
-	default:
-		r.logger.Info("buffer is full")
-		return nil
-	}
-}
-
+// record runs a single recording session, from connecting to the camera until the
+// connection drops or the recorder is stopped. The current segment is always closed
+// before returning, so the next session starts recording into a new one.
 func (r *RTSP_H264Recorder) record() error {
-	defer r.wg.Done()
-
 	u, err := base.ParseURL(r.rtspURL)
-
 	if err != nil {
-		r.logger.Error("failed to parse url: %w", err)
 		return err
 	}
-	r.logger.Info("recording...")
 
-	// find published medias
-	desc, _, err := r.client.Describe(u)
+	client, err := newRTSPClient(r.camera.RTSP)
 	if err != nil {
 		return err
 	}
 
-	// find the H264 media and format
-	var forma *format.H264
-	medi := desc.FindFormat(&forma)
-	if medi == nil {
-		r.logger.Error("media not found")
-		return nil
+	// connect to the server
+	err = client.Start(u.Scheme, u.Host)
+	if err != nil {
+		return fmt.Errorf("failed to start RTSP client: %w", err)
 	}
 
-	// setup RTP/H264 -> H264 decoder
-	rtpDec, err := forma.CreateDecoder()
+	var mux *muxer
+	var frameDec frameDecoder
+	var audio audioCodec
+	var sub *substream
+
+	// the clients are closed first, so that no packet arrives
+	// while the muxer and the decoders are being closed
+	defer func() {
+		client.Close()
+		if sub != nil {
+			sub.close()
+		}
+		if mux != nil {
+			mux.close()
+		}
+		if frameDec != nil {
+			frameDec.close()
+		}
+		if audio != nil {
+			audio.close()
+		}
+	}()
+
+	r.logger.Info("recording...")
+
+	// find published medias
+	desc, _, err := client.Describe(u)
 	if err != nil {
-		r.logger.Errorf("%v", err)
 		return err
 	}
 
-	// setup H264 -> MPEG-TS muxer
-	mpegtsMuxer, err := newMPEGTSMuxer(forma.SPS, forma.PPS)
+	// find the video media and format, and the codec to handle them
+	medi, forma, codec, err := findVideoCodec(desc)
 	if err != nil {
 		return err
 	}
+	r.logger.Infof("recording %s video", codec.name())
+
+	// find the audio media and format, if any. The video is recorded anyway
+	// when the audio can't be.
+	audioMedi, audioForma, audio, err := findAudioCodec(desc)
+	switch {
+	case err == errNoAudio:
+		r.logger.Info("no audio to record")
+	case err != nil:
+		r.logger.Warnf("audio won't be recorded: %v", err)
+	default:
+		r.logger.Infof("recording %s audio", audio.name())
+	}
+
+	// setup H26x (and audio) -> MPEG-TS or fMP4 muxer
+	mux = newMuxer(r.camera, codec, audio, r.eChans.RecordOut, r.eChans.Stream)
 
-	// setup H264 -> jpeg muxer
-	frameDec, err := newH264Decoder()
+	// setup H26x -> frame decoder, and choose the frames to analyze. When the substream
+	// is analyzed, only the keyframes of the main stream are decoded, at full resolution,
+	// for the thumbnails
+	analytics := r.camera.Analytics
+	if r.substreamURL != "" {
+		analytics = conf.AnalyticsConfig{KeyframesOnly: true}
+	}
+	frameDec, err = codec.newDecoder(analyticsMaxSize(analytics))
 	if err != nil {
-		r.logger.Errorf("%v", err)
 		return err
 	}
+	sampler := newFrameSampler(analytics)
 
-	// if SPS and PPS are present into the SDP, send them to the decoder
-	if forma.SPS != nil {
-		frameDec.decode(forma.SPS)
-	}
-	if forma.PPS != nil {
-		frameDec.decode(forma.PPS)
+	// if the parameter sets are present into the SDP, send them to the decoder
+	for _, param := range codec.parameters() {
+		frameDec.decode(param)
 	}
 
-	defer frameDec.close()
-
-	// setup a single media
-	_, err = r.client.Setup(desc.BaseURL, medi, 0, 0)
+	// setup the video media, and the audio one if any
+	_, err = client.Setup(desc.BaseURL, medi, 0, 0)
 	if err != nil {
 		return err
 	}
+	if audio != nil && audioMedi != medi {
+		_, err = client.Setup(desc.BaseURL, audioMedi, 0, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	// frames can't be decoded before a random access access unit is received
+	randomAccessReceived := false
 
 	// called when a RTP packet arrives
-	r.client.OnPacketRTP(medi, forma, func(pkt *rtp.Packet) {
+	client.OnPacketRTP(medi, forma, func(pkt *rtp.Packet) {
 		// decode timestamp
-		pts, ok := r.client.PacketPTS(medi, pkt)
+		pts, ok := client.PacketPTS(medi, pkt)
 		if !ok {
 			return
 		}
 
 		// extract access unit from RTP packets
-		au, err := rtpDec.Decode(pkt)
+		au, err := codec.decodeRTP(pkt)
 		if err != nil {
-			if err != rtph264.ErrNonStartingPacketAndNoPrevious && err != rtph264.ErrMorePacketsNeeded {
-				r.logger.Errorf("%v", err)
-			}
+			r.logger.Errorf("%v", err)
+			return
+		}
+		if au == nil {
 			return
 		}
 
 		// wait for an I-frame
-		iFrameReceived := false
-		if !iFrameReceived {
-			if !h264.IDRPresent(au) {
-				iFrameReceived = true
-			}
+		keyframe := codec.isRandomAccess(au)
+		if !randomAccessReceived {
+			randomAccessReceived = keyframe
+		}
+
+		// encode the access unit into the recordings first,
+		// so that its frames can point to the segment it is in
+		err = mux.encode(au, pts)
+		if err != nil {
+			r.logger.Errorf("%v", err)
+		}
+
+		// only the sampled frames are converted and sent to the recognizers. When only
+		// the keyframes are analyzed, the other access units are not decoded at all
+		if !randomAccessReceived || !sampler.decode(keyframe) {
+			return
 		}
+		sampled := sampler.sample(pts)
 
 		// Loop over the NALUs and decode to frames.
 		for _, nalu := range au {
+			if !sampled {
+				frameDec.skip(nalu)
+				continue
+			}
+
+			img, err := frameDec.decode(nalu) // Decode NALU to an image.
 			if err != nil {
 				r.logger.Errorf("Failed to decode NALU: %v", err)
 				continue // Skip this NALU if there's an error.
 			}
 
-			img, err := frameDec.decode(nalu) // Decode NALU to an image.
-
 			// wait for a frame
 			if img == nil {
 				continue
 			}
 
-			// At this point, 'img' is an image.Image containing your frame.
-			// You can now process, save, or stream this image as needed.
-			// For example, to save as a JPEG:
-			if iFrameReceived {
-				err = r.sendFrame(img)
+			frame := r.newFrame(img, frameDec.framePool(), pts, keyframe, mux.segment(pts))
+			if sub != nil {
+				sub.setMain(frame)
+				continue
 			}
+
+			err = r.sendFrame(frame)
 			if err != nil {
 				r.logger.Errorf("Failed to send frame: %v", err)
-				// Handle error as needed.
 			}
 		}
+	})
+
+	// called when an audio RTP packet arrives
+	if audio != nil {
+		client.OnPacketRTP(audioMedi, audioForma, func(pkt *rtp.Packet) {
+			// decode timestamp. It shares the same time base of the video one
+			pts, ok := client.PacketPTS(audioMedi, pkt)
+			if !ok {
+				return
+			}
 
-		// encode the access unit into MPEG-TS
-		err = mpegtsMuxer.encode(au, pts, r.eChans.RecordOut)
+			frames, err := audio.decodeRTP(pkt)
+			if err != nil {
+				r.logger.Errorf("%v", err)
+				return
+			}
+			if frames == nil {
+				return
+			}
+
+			// encode the audio frames into the recordings
+			err = mux.encodeAudio(frames, pts)
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
+		})
+	}
+
+	// start playing the substream, if any, before the main stream, that sets its keyframes
+	if r.substreamURL != "" {
+		sub, err = r.startSubstream(mux)
 		if err != nil {
-			r.logger.Errorf("%v", err)
-			return
+			return err
 		}
-
-	})
+	}
 
 	// start playing
-	_, err = r.client.Play(nil)
+	_, err = client.Play(nil)
 	if err != nil {
 		return err
 	}
+	r.feedPlaying()
 
-	// Use a channel to receive an error from the client
-	clientErrCh := make(chan error, 1)
+	// Use a channel to receive an error from the clients
+	clientErrCh := make(chan error, 2)
 
-	// Run the client's Wait in a goroutine
+	// Run the clients' Wait in goroutines
 	go func() {
-		clientErrCh <- r.client.Wait()
+		clientErrCh <- client.Wait()
 	}()
+	if sub != nil {
+		go func() {
+			clientErrCh <- sub.client.Wait()
+		}()
+	}
 
-	select {
-	case <-r.stopCh:
-		r.logger.Info("received stop signal")
-		return nil
-	case err := <-clientErrCh:
-		if err != nil {
+	for {
+		select {
+		case <-r.stopCh:
+			r.logger.Info("received stop signal")
+			return nil
+		case err := <-clientErrCh:
 			return err
+		case t := <-r.eChans.TriggerIn:
+			r.logger.Debugf("event triggered: %s", t.Reason)
+			err := mux.trigger()
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
 		}
 	}
-
-	return nil
 }
@@ -0,0 +1,84 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+// defaultSegmentFileName is used when recorder.segments.fileName is not set.
+const defaultSegmentFileName = "%camera_%kind_%Y-%m-%d_%H-%M-%S-%f"
+
+// maxNameCollisions is how many suffixes are tried when a segment name is taken.
+const maxNameCollisions = 100
+
+// segmentSettings are the segment settings of the recorder,
+// with defaults filled in for the values that are not set.
+type segmentSettings struct {
+	duration time.Duration
+	maxSize  int64
+	fileName string
+}
+
+func newSegmentSettings(cfg conf.SegmentConfig) segmentSettings {
+	s := segmentSettings{
+		duration: time.Duration(cfg.Duration) * time.Second,
+		maxSize:  int64(cfg.MaxSize),
+		fileName: cfg.FileName,
+	}
+	if s.duration <= 0 {
+		s.duration = 8 * time.Second
+	}
+	if s.fileName == "" {
+		s.fileName = defaultSegmentFileName
+	}
+	return s
+}
+
+// segmentName expands the file name template of a segment
+// of the given camera and kind, that starts at the given time.
+func segmentName(template string, cameraID string, kind RecordingKind, start time.Time) string {
+	return strings.NewReplacer(
+		"%camera", cameraID,
+		"%kind", string(kind),
+		"%Y", start.Format("2006"),
+		"%m", start.Format("01"),
+		"%d", start.Format("02"),
+		"%H", start.Format("15"),
+		"%M", start.Format("04"),
+		"%S", start.Format("05"),
+		"%f", fmt.Sprintf("%06d", start.Nanosecond()/1000),
+	).Replace(template)
+}
+
+// createSegmentFile creates the file of a new segment in dir, with the given name and
+// extension. The file is never overwritten: when the name is taken, for instance by two
+// segments starting at the same time, a numeric suffix is added to it.
+func createSegmentFile(dir string, name string, ext string) (*os.File, error) {
+	path := filepath.Join(dir, name)
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < maxNameCollisions; i++ {
+		candidate := path + ext
+		if i > 0 {
+			candidate = fmt.Sprintf("%s_%d%s", path, i, ext)
+		}
+
+		f, err := os.OpenFile(candidate, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return f, err
+	}
+
+	return nil, fmt.Errorf("segment name %s is taken", path+ext)
+}
@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/conf"
+)
+
+func TestSegmentName(t *testing.T) {
+	start := time.Date(2024, 4, 8, 6, 5, 4, 3021000, time.UTC)
+
+	tests := []struct {
+		name     string
+		template string
+		cameraID string
+		kind     RecordingKind
+		want     string
+	}{
+		{"default", defaultSegmentFileName, "door", RecordingContinuous, "door_continuous_2024-04-08_06-05-04-003021"},
+		{"directories", "%camera/%Y/%m/%d/%H-%M-%S", "door", RecordingEvent, "door/2024/04/08/06-05-04"},
+		{"kind only", "%kind", "door", RecordingKeyframes, "keyframes"},
+		{"no placeholders", "recording", "door", RecordingContinuous, "recording"},
+		{"repeated", "%camera-%camera", "yard", RecordingContinuous, "yard-yard"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := segmentName(tt.template, tt.cameraID, tt.kind, start); got != tt.want {
+				t.Errorf("segmentName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSegmentSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  conf.SegmentConfig
+		want segmentSettings
+	}{
+		{"defaults", conf.SegmentConfig{}, segmentSettings{8 * time.Second, 0, defaultSegmentFileName}},
+		{"set", conf.SegmentConfig{Duration: 60, MaxSize: 1 << 20, FileName: "%camera"}, segmentSettings{time.Minute, 1 << 20, "%camera"}},
+		{"negative duration", conf.SegmentConfig{Duration: -1}, segmentSettings{8 * time.Second, 0, defaultSegmentFileName}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newSegmentSettings(tt.cfg); got != tt.want {
+				t.Errorf("newSegmentSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateSegmentFile(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{"door/seg.mp4", "door/seg_1.mp4", "door/seg_2.mp4"}
+
+	// segments starting at the same time get a suffix instead of overwriting each other
+	for _, name := range want {
+		f, err := createSegmentFile(dir, "door/seg", ".mp4")
+		if err != nil {
+			t.Fatalf("createSegmentFile() error = %v", err)
+		}
+		f.Close()
+		if got, _ := filepath.Rel(dir, f.Name()); got != filepath.FromSlash(name) {
+			t.Errorf("createSegmentFile() = %q, want %q", got, name)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(dir, "door"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Errorf("%d files created, want %d", len(entries), len(want))
+	}
+}
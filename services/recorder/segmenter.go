@@ -0,0 +1,193 @@
+package recorder
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+	"github.com/pedrohba1/SSCS/services/conf"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// sample is a video access unit, ready to be muxed, or a group of audio access units.
+type sample struct {
+	video        bool
+	au           [][]byte
+	pts          time.Duration
+	dts          time.Duration
+	randomAccess bool
+	time         time.Time // when the sample was received
+}
+
+// clone returns a copy of the sample that doesn't share its data.
+func (s *sample) clone() *sample {
+	c := *s
+	c.au = make([][]byte, len(s.au))
+	for i, nalu := range s.au {
+		c.au[i] = append([]byte(nil), nalu...)
+	}
+	return &c
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// segmentFormat writes samples into segments of a container format.
+type segmentFormat interface {
+	// ext returns the extension of the segment files.
+	ext() string
+
+	// begin starts a segment that is written into w, whose first sample is a random access.
+	begin(w io.Writer, first *sample) error
+
+	// write writes a sample into the current segment.
+	write(smp *sample) error
+
+	// end writes whatever the current segment still holds.
+	end() error
+}
+
+// newSegmentFormat returns the segment format of the given container, or fMP4
+// when MPEG-TS can't carry the codec. The audio codec is nil when there is no audio to record.
+func newSegmentFormat(container string, codec videoCodec, audio audioCodec) segmentFormat {
+	if container == conf.ContainerFMP4 || codec.mpegtsCodec() == nil {
+		return newFMP4Format(codec, audio)
+	}
+	return newMPEGTSFormat(codec, audio)
+}
+
+// segmenter writes samples into back to back segments. A segment is started by
+// the first random access sample written, and is split at the first random access
+// after the segment duration, or after it reaches the maximum size. Every segment
+// saved is added to saved, for the muxer to report it.
+type segmenter struct {
+	kind          RecordingKind
+	settings      segmentSettings
+	format        segmentFormat
+	recordingsDir string
+	cameraID      string
+	location      *time.Location
+	logger        *logrus.Entry
+
+	f         *os.File
+	c         *countingWriter
+	b         *bufio.Writer
+	startPTS  time.Duration
+	startTime time.Time
+	lastTime  time.Time
+
+	saved []RecordedEvent
+}
+
+// newSegmenter allocates a segmenter for the recordings of the given kind, in the
+// container chosen for the camera. The audio codec is nil when there is no audio to record.
+func newSegmenter(camera conf.CameraConfig, kind RecordingKind, codec videoCodec,
+	audio audioCodec) *segmenter {
+	cfg, _ := conf.ReadConf()
+
+	return &segmenter{
+		kind:          kind,
+		settings:      newSegmentSettings(cfg.Recorder.Segments),
+		format:        newSegmentFormat(camera.Recording.Container, codec, audio),
+		recordingsDir: camera.RecordingsPath(cfg.Recorder.RecordingsDir),
+		cameraID:      camera.ID,
+		location:      camera.Location(),
+		logger:        BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", camera.ID),
+	}
+}
+
+// write writes a sample into the current segment, starting a new one when needed.
+// Samples are skipped until a random access one is received.
+func (s *segmenter) write(smp *sample) error {
+	if s.f == nil {
+		if !smp.video || !smp.randomAccess {
+			return nil
+		}
+		err := s.open(smp)
+		if err != nil {
+			return err
+		}
+	} else if smp.video && smp.randomAccess && s.full(smp) {
+		s.close()
+		err := s.open(smp)
+		if err != nil {
+			return err
+		}
+	}
+	s.lastTime = smp.time
+
+	return s.format.write(smp)
+}
+
+// full tells if the current segment must be split before the given sample.
+func (s *segmenter) full(smp *sample) bool {
+	if smp.pts-s.startPTS >= s.settings.duration {
+		return true
+	}
+	return s.settings.maxSize > 0 && s.c.n+int64(s.b.Buffered()) >= s.settings.maxSize
+}
+
+// open starts a new segment with the given sample.
+func (s *segmenter) open(smp *sample) error {
+	name := segmentName(s.settings.fileName, s.cameraID, s.kind, smp.time.In(s.location))
+	f, err := createSegmentFile(s.recordingsDir, name, s.format.ext())
+	if err != nil {
+		return err
+	}
+	s.f = f
+	s.c = &countingWriter{w: f}
+	s.b = bufio.NewWriter(s.c)
+	s.startPTS = smp.pts
+	s.startTime = smp.time
+
+	err = s.format.begin(s.b, smp)
+	if err != nil {
+		s.f.Close()
+		s.f = nil
+		return err
+	}
+	return nil
+}
+
+// ref locates the sample of the given PTS into the current segment.
+func (s *segmenter) ref(pts time.Duration) camera.SegmentRef {
+	if s.f == nil {
+		return camera.SegmentRef{}
+	}
+	return camera.SegmentRef{Path: s.f.Name(), Offset: pts - s.startPTS}
+}
+
+// close saves the current segment, if any.
+func (s *segmenter) close() {
+	if s.f == nil {
+		return
+	}
+	err := s.format.end()
+	if err != nil {
+		s.logger.Errorf("%v", err)
+	}
+	s.b.Flush()
+	s.f.Close()
+	s.logger.Info("saving content: " + s.f.Name())
+	s.saved = append(s.saved, RecordedEvent{
+		Path:      s.f.Name(),
+		CameraID:  s.cameraID,
+		Kind:      s.kind,
+		StartTime: s.startTime,
+		EndTime:   s.lastTime,
+	})
+	s.f = nil
+}
@@ -0,0 +1,628 @@
+package recorder
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"time"
+)
+
+// SRT control packet types.
+const (
+	srtTypeHandshake = 0x0000
+	srtTypeKeepalive = 0x0001
+	srtTypeACK       = 0x0002
+	srtTypeNAK       = 0x0003
+	srtTypeShutdown  = 0x0005
+)
+
+// SRT handshake types, and the rejection reasons that are sent
+// instead, offset by srtRejectionBase.
+const (
+	srtHandshakeInduction  = 0x00000001
+	srtHandshakeConclusion = 0xFFFFFFFF
+
+	srtRejectionBase    = 1000
+	srtRejectPeer       = 2
+	srtRejectBacklog    = 5
+	srtRejectVersion    = 8
+	srtRejectUnsecure   = 11
+	srtRejectMessageAPI = 12
+)
+
+// SRT handshake extensions, and the flags of the extension field that tell they are present.
+const (
+	srtExtHSReq = 1
+	srtExtHSRsp = 2
+	srtExtKMReq = 3
+	srtExtSID   = 5
+
+	srtExtFlagHSReq = 0x1
+
+	srtMagic = 0x4A17 // the extension field of the induction response, for HSv5 callers
+)
+
+// SRT flags of the HSREQ and HSRSP extensions.
+const (
+	srtFlagTSBPDSnd  = 0x01
+	srtFlagTSBPDRcv  = 0x02
+	srtFlagTLPktDrop = 0x08
+	srtFlagNAKReport = 0x10
+	srtFlagRexmit    = 0x20
+	srtFlagStream    = 0x40
+)
+
+const (
+	srtVersion = 0x010502 // the version answered to the callers, 1.5.2
+
+	srtHeaderSize    = 16
+	srtHandshakeSize = 48
+	srtMaxPacketSize = 1500
+
+	// srtDefaultLatency is the time a lost packet is waited for before it is skipped,
+	// unless the caller asks for more.
+	srtDefaultLatency = 120 * time.Millisecond
+
+	// srtTick is how often the packets received are acknowledged.
+	srtTick = 10 * time.Millisecond
+
+	// srtNAKInterval is how often the packets still missing are reported again.
+	srtNAKInterval = 50 * time.Millisecond
+
+	// srtKeepaliveInterval is how often the caller is told the connection is
+	// alive when nothing else is sent, and srtPeerTimeout how long it can stay silent.
+	srtKeepaliveInterval = time.Second
+	srtPeerTimeout       = 5 * time.Second
+
+	// srtMaxPending is how many packets are kept while a lost one is waited for.
+	srtMaxPending = 8192
+)
+
+var (
+	errSRTShutdown = errors.New("the caller closed the connection")
+	errSRTClosed   = errors.New("the connection is closed")
+)
+
+// srtListener accepts a SRT caller on a UDP socket. It only implements what is needed
+// to receive a live stream from a caller: the HSv5 handshake, without encryption.
+type srtListener struct {
+	pc       net.PacketConn
+	latency  time.Duration
+	streamID string // the stream ID the caller must ask for, if any
+
+	secret [16]byte // for the handshake cookies
+}
+
+func newSRTListener(pc net.PacketConn, streamID string) (*srtListener, error) {
+	l := &srtListener{
+		pc:       pc,
+		latency:  srtDefaultLatency,
+		streamID: streamID,
+	}
+	_, err := rand.Read(l.secret[:])
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// cookie returns the cookie of a caller, that it must send back
+// in its conclusion handshake, to prove that it owns its address.
+func (l *srtListener) cookie(addr net.Addr) uint32 {
+	h := fnv.New32a()
+	h.Write(l.secret[:])
+	h.Write([]byte(addr.String()))
+	return h.Sum32()
+}
+
+// socketID returns the socket ID of the connection with a caller.
+func (l *srtListener) socketID(addr net.Addr) uint32 {
+	return l.cookie(addr)&0x7FFFFFFF | 1
+}
+
+// srtHandshake is the handshake control information of a SRT packet.
+type srtHandshake struct {
+	version       uint32
+	encryption    uint16
+	extensionFlag uint16
+	initialSeq    uint32
+	mtu           uint32
+	flowWindow    uint32
+	typ           uint32
+	socketID      uint32
+	cookie        uint32
+	peerIP        [16]byte
+
+	// the HSREQ or HSRSP extension
+	srtVersion  uint32
+	srtFlags    uint32
+	recvLatency uint16 // in milliseconds
+	sendLatency uint16
+
+	streamID  string
+	encrypted bool // the caller sent a KMREQ extension
+}
+
+func (h *srtHandshake) unmarshal(b []byte) error {
+	if len(b) < srtHandshakeSize {
+		return fmt.Errorf("invalid handshake")
+	}
+	h.version = binary.BigEndian.Uint32(b)
+	h.encryption = binary.BigEndian.Uint16(b[4:])
+	h.extensionFlag = binary.BigEndian.Uint16(b[6:])
+	h.initialSeq = binary.BigEndian.Uint32(b[8:]) & 0x7FFFFFFF
+	h.mtu = binary.BigEndian.Uint32(b[12:])
+	h.flowWindow = binary.BigEndian.Uint32(b[16:])
+	h.typ = binary.BigEndian.Uint32(b[20:])
+	h.socketID = binary.BigEndian.Uint32(b[24:])
+	h.cookie = binary.BigEndian.Uint32(b[28:])
+	copy(h.peerIP[:], b[32:48])
+
+	if h.version < 5 || h.typ != srtHandshakeConclusion {
+		return nil
+	}
+
+	b = b[srtHandshakeSize:]
+	for len(b) >= 4 {
+		typ := binary.BigEndian.Uint16(b)
+		size := 4 * int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if size > len(b) {
+			return fmt.Errorf("invalid handshake extension")
+		}
+		ext := b[:size]
+		b = b[size:]
+
+		switch typ {
+		case srtExtHSReq:
+			if len(ext) < 12 {
+				return fmt.Errorf("invalid handshake extension")
+			}
+			h.srtVersion = binary.BigEndian.Uint32(ext)
+			h.srtFlags = binary.BigEndian.Uint32(ext[4:])
+			h.recvLatency = binary.BigEndian.Uint16(ext[8:])
+			h.sendLatency = binary.BigEndian.Uint16(ext[10:])
+
+		case srtExtKMReq:
+			h.encrypted = true
+
+		case srtExtSID:
+			// the stream ID is stored as little endian words
+			sid := make([]byte, 0, len(ext))
+			for i := 0; i+4 <= len(ext); i += 4 {
+				sid = append(sid, ext[i+3], ext[i+2], ext[i+1], ext[i])
+			}
+			for len(sid) > 0 && sid[len(sid)-1] == 0 {
+				sid = sid[:len(sid)-1]
+			}
+			h.streamID = string(sid)
+		}
+	}
+	return nil
+}
+
+func (h *srtHandshake) marshal() []byte {
+	b := make([]byte, srtHandshakeSize, srtHandshakeSize+16)
+	binary.BigEndian.PutUint32(b, h.version)
+	binary.BigEndian.PutUint16(b[4:], h.encryption)
+	binary.BigEndian.PutUint16(b[6:], h.extensionFlag)
+	binary.BigEndian.PutUint32(b[8:], h.initialSeq)
+	binary.BigEndian.PutUint32(b[12:], h.mtu)
+	binary.BigEndian.PutUint32(b[16:], h.flowWindow)
+	binary.BigEndian.PutUint32(b[20:], h.typ)
+	binary.BigEndian.PutUint32(b[24:], h.socketID)
+	binary.BigEndian.PutUint32(b[28:], h.cookie)
+	copy(b[32:], h.peerIP[:])
+
+	if h.extensionFlag&srtExtFlagHSReq != 0 && h.typ == srtHandshakeConclusion {
+		b = binary.BigEndian.AppendUint16(b, srtExtHSRsp)
+		b = binary.BigEndian.AppendUint16(b, 3)
+		b = binary.BigEndian.AppendUint32(b, h.srtVersion)
+		b = binary.BigEndian.AppendUint32(b, h.srtFlags)
+		b = binary.BigEndian.AppendUint16(b, h.recvLatency)
+		b = binary.BigEndian.AppendUint16(b, h.sendLatency)
+	}
+	return b
+}
+
+// srtControl returns a control packet.
+func srtControl(typ uint16, info uint32, timestamp uint32, socketID uint32, cif []byte) []byte {
+	b := make([]byte, srtHeaderSize, srtHeaderSize+len(cif))
+	binary.BigEndian.PutUint16(b, 0x8000|typ)
+	binary.BigEndian.PutUint32(b[4:], info)
+	binary.BigEndian.PutUint32(b[8:], timestamp)
+	binary.BigEndian.PutUint32(b[12:], socketID)
+	return append(b, cif...)
+}
+
+// accept waits for a caller to complete its handshake. It answers the handshakes
+// of the callers, and rejects the ones that ask for another stream or for encryption.
+func (l *srtListener) accept() (*srtConn, error) {
+	// the deadlines of the previous connection are cleared
+	l.pc.SetReadDeadline(time.Time{})
+
+	buf := make([]byte, srtMaxPacketSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		pkt := buf[:n]
+		if n < srtHeaderSize || pkt[0]&0x80 == 0 || binary.BigEndian.Uint16(pkt)&0x7FFF != srtTypeHandshake {
+			continue
+		}
+
+		var req srtHandshake
+		if req.unmarshal(pkt[srtHeaderSize:]) != nil {
+			continue
+		}
+
+		res := req
+		res.cookie = l.cookie(addr)
+		res.peerIP = [16]byte{}
+		copy(res.peerIP[:], addrIP(addr))
+
+		switch req.typ {
+		case srtHandshakeInduction:
+			res.version = 5
+			res.extensionFlag = srtMagic
+			res.socketID = l.socketID(addr)
+			l.pc.WriteTo(srtControl(srtTypeHandshake, 0, 0, req.socketID, res.marshal()), addr)
+
+		case srtHandshakeConclusion:
+			if req.cookie != res.cookie {
+				continue
+			}
+
+			reject := 0
+			switch {
+			case req.version < 5 || req.extensionFlag&srtExtFlagHSReq == 0:
+				reject = srtRejectVersion
+			case req.encryption != 0 || req.encrypted:
+				reject = srtRejectUnsecure
+			case req.srtFlags&srtFlagStream != 0:
+				reject = srtRejectMessageAPI
+			case l.streamID != "" && req.streamID != l.streamID:
+				reject = srtRejectPeer
+			}
+			if reject != 0 {
+				res.typ = srtRejectionBase + uint32(reject)
+				res.extensionFlag = 0
+				l.pc.WriteTo(srtControl(srtTypeHandshake, 0, 0, req.socketID, res.marshal()), addr)
+				return nil, fmt.Errorf("rejected a caller from %v asking for stream %q: reason %d", addr, req.streamID, reject)
+			}
+
+			c := newSRTConn(l, addr, req)
+			res.socketID = c.socketID
+			res.extensionFlag = srtExtFlagHSReq
+			res.encryption = 0
+			res.srtVersion = srtVersion
+			res.srtFlags = srtFlagTSBPDSnd | srtFlagTSBPDRcv | srtFlagTLPktDrop | srtFlagNAKReport | srtFlagRexmit
+			res.recvLatency = uint16(c.latency / time.Millisecond)
+			res.sendLatency = req.recvLatency
+			c.conclusion = srtControl(srtTypeHandshake, 0, 0, req.socketID, res.marshal())
+			_, err = l.pc.WriteTo(c.conclusion, addr)
+			if err != nil {
+				return nil, err
+			}
+			return c, nil
+		}
+	}
+}
+
+// addrIP returns the IP of an address, as it is written into the
+// handshakes: IPv4 addresses are little endian.
+func addrIP(addr net.Addr) []byte {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	if ip4 := udp.IP.To4(); ip4 != nil {
+		return []byte{ip4[3], ip4[2], ip4[1], ip4[0]}
+	}
+	return udp.IP.To16()
+}
+
+// srtConn receives the data packets of a SRT caller, and delivers their
+// payloads in order. Lost packets are reported to the caller, that sends
+// them again, and are skipped once they are later than the latency.
+type srtConn struct {
+	l          *srtListener
+	addr       net.Addr
+	socketID   uint32
+	peerID     uint32
+	latency    time.Duration
+	conclusion []byte // the conclusion handshake sent back, that is sent again when asked
+	start      time.Time
+
+	buf []byte
+	out []byte // the payload being read
+
+	next    uint32            // the sequence number of the next packet to deliver
+	pending map[uint32][]byte // packets received after a lost one
+	lost    map[uint32]time.Time
+
+	ackNumber    uint32
+	lastACKed    uint32
+	lastNAK      time.Time
+	lastSent     time.Time
+	lastReceived time.Time
+	lastTick     time.Time
+
+	closed chan struct{}
+}
+
+func newSRTConn(l *srtListener, addr net.Addr, hs srtHandshake) *srtConn {
+	c := &srtConn{
+		l:        l,
+		addr:     addr,
+		socketID: l.socketID(addr),
+		peerID:   hs.socketID,
+		latency:  l.latency,
+		start:    time.Now(),
+		buf:      make([]byte, srtMaxPacketSize),
+		next:     hs.initialSeq,
+		pending:  make(map[uint32][]byte),
+		lost:     make(map[uint32]time.Time),
+		closed:   make(chan struct{}),
+	}
+	if d := time.Duration(hs.sendLatency) * time.Millisecond; d > c.latency {
+		c.latency = d
+	}
+	c.lastACKed = c.next
+	c.lastReceived = c.start
+	return c
+}
+
+// srtSeqDiff returns how far the sequence number a is after b, as they wrap at 31 bits.
+func srtSeqDiff(a, b uint32) int32 {
+	return int32((a-b)<<1) >> 1
+}
+
+func (c *srtConn) timestamp() uint32 {
+	return uint32(time.Since(c.start) / time.Microsecond)
+}
+
+func (c *srtConn) send(typ uint16, info uint32, cif []byte) error {
+	c.lastSent = time.Now()
+	_, err := c.l.pc.WriteTo(srtControl(typ, info, c.timestamp(), c.peerID, cif), c.addr)
+	return err
+}
+
+// close stops Read, that tells the caller that the connection is closed.
+// It must be called once.
+func (c *srtConn) close() {
+	close(c.closed)
+}
+
+// Read implements io.Reader, returning the payloads of the data packets in order.
+func (c *srtConn) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		err := c.receive()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// receive handles a packet, or the timers when nothing is received in time.
+func (c *srtConn) receive() error {
+	select {
+	case <-c.closed:
+		c.send(srtTypeShutdown, 0, make([]byte, 4))
+		return errSRTClosed
+	default:
+	}
+
+	now := time.Now()
+	if now.Sub(c.lastTick) >= srtTick {
+		c.lastTick = now
+		err := c.tick(now)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.l.pc.SetReadDeadline(now.Add(srtTick))
+	n, addr, err := c.l.pc.ReadFrom(c.buf)
+	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return nil
+		}
+		return err
+	}
+	pkt := c.buf[:n]
+	if n < srtHeaderSize {
+		return nil
+	}
+
+	if addr.String() != c.addr.String() {
+		// other callers are rejected while the stream is received
+		if pkt[0]&0x80 != 0 && binary.BigEndian.Uint16(pkt)&0x7FFF == srtTypeHandshake {
+			var req srtHandshake
+			if req.unmarshal(pkt[srtHeaderSize:]) == nil && req.typ == srtHandshakeConclusion {
+				req.typ = srtRejectionBase + srtRejectBacklog
+				req.extensionFlag = 0
+				c.l.pc.WriteTo(srtControl(srtTypeHandshake, 0, 0, req.socketID, req.marshal()), addr)
+			}
+		}
+		return nil
+	}
+	if binary.BigEndian.Uint32(pkt[12:]) != c.socketID {
+		// the caller sends its conclusion again when the answer was lost
+		if pkt[0]&0x80 != 0 && binary.BigEndian.Uint16(pkt)&0x7FFF == srtTypeHandshake {
+			c.l.pc.WriteTo(c.conclusion, c.addr)
+		}
+		return nil
+	}
+	c.lastReceived = now
+
+	if pkt[0]&0x80 == 0 {
+		c.onData(binary.BigEndian.Uint32(pkt)&0x7FFFFFFF, pkt[srtHeaderSize:], now)
+		return nil
+	}
+
+	switch binary.BigEndian.Uint16(pkt) & 0x7FFF {
+	case srtTypeHandshake:
+		c.l.pc.WriteTo(c.conclusion, c.addr)
+	case srtTypeShutdown:
+		return errSRTShutdown
+	}
+	return nil
+}
+
+// onData stores the payload of a data packet, delivering it
+// along with the ones that were waiting for it, if any.
+func (c *srtConn) onData(seq uint32, payload []byte, now time.Time) {
+	diff := srtSeqDiff(seq, c.next)
+	if diff < 0 {
+		return
+	}
+	if diff > srtMaxPending {
+		// the caller started over, or too much was lost to wait for it
+		c.pending = make(map[uint32][]byte)
+		c.lost = make(map[uint32]time.Time)
+		c.next = seq
+		diff = 0
+	}
+	if _, ok := c.pending[seq]; ok {
+		return
+	}
+	c.pending[seq] = append([]byte(nil), payload...)
+	delete(c.lost, seq)
+
+	if diff > 0 {
+		// the packets between the last one received and this one are lost
+		var losses []uint32
+		for s := (seq - 1) & 0x7FFFFFFF; srtSeqDiff(s, c.next) >= 0; s = (s - 1) & 0x7FFFFFFF {
+			if _, ok := c.pending[s]; ok {
+				break
+			}
+			if _, ok := c.lost[s]; ok {
+				break
+			}
+			c.lost[s] = now
+			losses = append(losses, s)
+		}
+		if len(losses) != 0 {
+			c.send(srtTypeNAK, 0, srtLossList(losses))
+		}
+	}
+
+	if len(c.pending) > srtMaxPending {
+		c.skipLost()
+	}
+	c.deliver()
+}
+
+// deliver moves the packets that are in order to the payload being read.
+func (c *srtConn) deliver() {
+	for {
+		payload, ok := c.pending[c.next]
+		if !ok {
+			return
+		}
+		delete(c.pending, c.next)
+		c.out = append(c.out, payload...)
+		c.next = (c.next + 1) & 0x7FFFFFFF
+	}
+}
+
+// skipLost gives up on the lost packets up to the first packet received after them.
+func (c *srtConn) skipLost() {
+	first := c.next
+	found := false
+	for seq := range c.pending {
+		if !found || srtSeqDiff(seq, first) < 0 {
+			first = seq
+			found = true
+		}
+	}
+	if !found {
+		return
+	}
+	for seq := range c.lost {
+		if srtSeqDiff(seq, first) < 0 {
+			delete(c.lost, seq)
+		}
+	}
+	c.next = first
+}
+
+// tick acknowledges the packets delivered, reports again the packets still
+// lost and skips the ones that are too late, and keeps the connection alive.
+func (c *srtConn) tick(now time.Time) error {
+	if now.Sub(c.lastReceived) > srtPeerTimeout {
+		return fmt.Errorf("no packet received for %v", srtPeerTimeout)
+	}
+
+	late := false
+	for _, t := range c.lost {
+		if now.Sub(t) > c.latency {
+			late = true
+			break
+		}
+	}
+	if late {
+		c.skipLost()
+		c.deliver()
+	}
+
+	if c.next != c.lastACKed {
+		c.lastACKed = c.next
+		c.ackNumber++
+		cif := make([]byte, 28)
+		binary.BigEndian.PutUint32(cif, c.next)
+		binary.BigEndian.PutUint32(cif[4:], 100000) // RTT, in microseconds
+		binary.BigEndian.PutUint32(cif[8:], 50000)  // RTT variance
+		binary.BigEndian.PutUint32(cif[12:], srtMaxPending-uint32(len(c.pending)))
+		return c.send(srtTypeACK, c.ackNumber, cif)
+	}
+
+	if len(c.lost) != 0 && now.Sub(c.lastNAK) >= srtNAKInterval {
+		c.lastNAK = now
+		losses := make([]uint32, 0, len(c.lost))
+		for seq := range c.lost {
+			losses = append(losses, seq)
+		}
+		return c.send(srtTypeNAK, 0, srtLossList(losses))
+	}
+
+	if now.Sub(c.lastSent) >= srtKeepaliveInterval {
+		return c.send(srtTypeKeepalive, 0, make([]byte, 4))
+	}
+	return nil
+}
+
+// srtLossList encodes sequence numbers into the loss list of a NAK, where
+// consecutive numbers are sent as a range, that is its first number with
+// the highest bit set, followed by its last number.
+func srtLossList(seqs []uint32) []byte {
+	sort.Slice(seqs, func(i, j int) bool {
+		return srtSeqDiff(seqs[i], seqs[j]) < 0
+	})
+
+	var b []byte
+	for i := 0; i < len(seqs); {
+		j := i
+		for j+1 < len(seqs) && srtSeqDiff(seqs[j+1], seqs[j]) == 1 {
+			j++
+		}
+		if j == i {
+			b = binary.BigEndian.AppendUint32(b, seqs[i])
+		} else {
+			b = binary.BigEndian.AppendUint32(b, seqs[i]|0x80000000)
+			b = binary.BigEndian.AppendUint32(b, seqs[j])
+		}
+		i = j + 1
+	}
+	return b
+}
@@ -0,0 +1,306 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSRTCaller is the caller side of a SRT connection, sending raw packets.
+type testSRTCaller struct {
+	t        *testing.T
+	conn     net.Conn
+	socketID uint32
+	peerID   uint32 // the socket ID of the listener, once connected
+}
+
+func dialTestSRT(t *testing.T, addr net.Addr) *testSRTCaller {
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testSRTCaller{t: t, conn: conn, socketID: 0x1234}
+}
+
+// read returns the next packet, or nil when nothing is received in time.
+func (c *testSRTCaller) read(timeout time.Duration) []byte {
+	buf := make([]byte, srtMaxPacketSize)
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	return buf[:n]
+}
+
+// readControl returns the next control packet of the given type, or nil.
+func (c *testSRTCaller) readControl(typ uint16, timeout time.Duration) []byte {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		pkt := c.read(time.Until(deadline))
+		if pkt != nil && len(pkt) >= srtHeaderSize && binary.BigEndian.Uint16(pkt) == 0x8000|typ {
+			return pkt
+		}
+	}
+	return nil
+}
+
+// handshake sends the induction and the conclusion handshakes, and returns
+// the handshake type of the answer to the conclusion.
+func (c *testSRTCaller) handshake(streamID string, encrypted bool) uint32 {
+	induction := srtHandshake{version: 4, extensionFlag: 2, initialSeq: 100, mtu: 1500, flowWindow: 8192,
+		typ: srtHandshakeInduction, socketID: c.socketID}
+	c.conn.Write(srtControl(srtTypeHandshake, 0, 0, 0, induction.marshal()))
+	pkt := c.readControl(srtTypeHandshake, time.Second)
+	if pkt == nil {
+		c.t.Fatal("no induction answer")
+	}
+	var res srtHandshake
+	res.unmarshal(pkt[srtHeaderSize:])
+	if res.version != 5 || res.extensionFlag != srtMagic {
+		c.t.Fatalf("induction answer = %+v", res)
+	}
+
+	conclusion := induction
+	conclusion.version = 5
+	conclusion.typ = srtHandshakeConclusion
+	conclusion.cookie = res.cookie
+	b := conclusion.marshal()
+	b = binary.BigEndian.AppendUint16(b, srtExtHSReq)
+	b = binary.BigEndian.AppendUint16(b, 3)
+	b = binary.BigEndian.AppendUint32(b, srtVersion)
+	b = binary.BigEndian.AppendUint32(b, srtFlagTSBPDSnd|srtFlagTSBPDRcv|srtFlagTLPktDrop)
+	b = binary.BigEndian.AppendUint16(b, 120)
+	b = binary.BigEndian.AppendUint16(b, 120)
+	flags := uint16(srtExtFlagHSReq)
+	if encrypted {
+		b = binary.BigEndian.AppendUint16(b, srtExtKMReq)
+		b = binary.BigEndian.AppendUint16(b, 1)
+		b = append(b, 0, 0, 0, 0)
+		flags |= 0x2
+	}
+	if streamID != "" {
+		sid := []byte(streamID)
+		for len(sid)%4 != 0 {
+			sid = append(sid, 0)
+		}
+		b = binary.BigEndian.AppendUint16(b, srtExtSID)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(sid)/4))
+		for i := 0; i < len(sid); i += 4 {
+			b = append(b, sid[i+3], sid[i+2], sid[i+1], sid[i])
+		}
+		flags |= 0x4
+	}
+	binary.BigEndian.PutUint16(b[6:], flags)
+	c.conn.Write(srtControl(srtTypeHandshake, 0, 0, 0, b))
+
+	pkt = c.readControl(srtTypeHandshake, time.Second)
+	if pkt == nil {
+		c.t.Fatal("no conclusion answer")
+	}
+	res = srtHandshake{}
+	res.unmarshal(pkt[srtHeaderSize:])
+	c.peerID = res.socketID
+	return res.typ
+}
+
+func (c *testSRTCaller) sendData(seq uint32, payload []byte) {
+	b := make([]byte, srtHeaderSize)
+	binary.BigEndian.PutUint32(b, seq)
+	binary.BigEndian.PutUint32(b[4:], 0xC0000000) // a solo packet
+	binary.BigEndian.PutUint32(b[12:], c.peerID)
+	c.conn.Write(append(b, payload...))
+}
+
+func newTestSRTListener(t *testing.T, streamID string) *srtListener {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	l, err := newSRTListener(pc, streamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestSRTHandshake(t *testing.T) {
+	tests := []struct {
+		name      string
+		listen    string // the stream ID of the listener
+		streamID  string // the stream ID asked by the caller
+		encrypted bool
+		want      uint32
+	}{
+		{"any stream", "", "whatever", false, srtHandshakeConclusion},
+		{"stream ID", "door", "door", false, srtHandshakeConclusion},
+		{"no stream ID", "door", "", false, srtRejectionBase + srtRejectPeer},
+		{"other stream ID", "door", "yard", false, srtRejectionBase + srtRejectPeer},
+		{"long stream ID", "front-door-camera", "front-door-camera", false, srtHandshakeConclusion},
+		{"encrypted", "", "", true, srtRejectionBase + srtRejectUnsecure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestSRTListener(t, tt.listen)
+			errCh := make(chan error, 1)
+			go func() {
+				conn, err := l.accept()
+				if err == nil {
+					conn.close()
+				}
+				errCh <- err
+			}()
+
+			caller := dialTestSRT(t, l.pc.LocalAddr())
+			if got := caller.handshake(tt.streamID, tt.encrypted); got != tt.want {
+				t.Errorf("handshake type = %d, want %d", got, tt.want)
+			}
+			if err := <-errCh; (err == nil) != (tt.want == srtHandshakeConclusion) {
+				t.Errorf("accept() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSRTConnReceive(t *testing.T) {
+	tests := []struct {
+		name     string
+		seqs     []uint32 // sent, offset from the initial sequence number
+		want     []byte   // the payloads read, a byte each
+		wantLost []uint32 // reported by the first NAK
+	}{
+		{name: "in order", seqs: []uint32{0, 1, 2, 3}, want: []byte{0, 1, 2, 3}},
+		{name: "reordered", seqs: []uint32{0, 2, 1, 3}, want: []byte{0, 1, 2, 3}, wantLost: []uint32{1}},
+		{name: "duplicates", seqs: []uint32{0, 1, 1, 0, 2}, want: []byte{0, 1, 2}},
+		{name: "range lost then received", seqs: []uint32{0, 4, 1, 2, 3}, want: []byte{0, 1, 2, 3, 4}, wantLost: []uint32{1, 2, 3}},
+		{name: "lost for good", seqs: []uint32{0, 1, 3, 4}, want: []byte{0, 1, 3, 4}, wantLost: []uint32{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestSRTListener(t, "")
+			connCh := make(chan *srtConn, 1)
+			go func() {
+				conn, _ := l.accept()
+				connCh <- conn
+			}()
+			caller := dialTestSRT(t, l.pc.LocalAddr())
+			if typ := caller.handshake("", false); typ != srtHandshakeConclusion {
+				t.Fatalf("handshake type = %d", typ)
+			}
+			conn := <-connCh
+
+			for _, seq := range tt.seqs {
+				caller.sendData(100+seq, []byte{byte(seq)})
+			}
+
+			got := make([]byte, len(tt.want))
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.ReadFull(conn, got)
+				done <- err
+			}()
+
+			if tt.wantLost != nil {
+				nak := caller.readControl(srtTypeNAK, time.Second)
+				if nak == nil {
+					t.Fatal("no NAK received")
+				}
+				want := make([]uint32, len(tt.wantLost))
+				for i, seq := range tt.wantLost {
+					want[i] = 100 + seq
+				}
+				if lost := srtLossList(want); !bytes.Equal(nak[srtHeaderSize:], lost) {
+					t.Errorf("NAK loss list = %x, want %x", nak[srtHeaderSize:], lost)
+				}
+			}
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("payloads not read")
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("read %v, want %v", got, tt.want)
+			}
+
+			// the caller closes the connection
+			caller.conn.Write(srtControl(srtTypeShutdown, 0, 0, caller.peerID, make([]byte, 4)))
+			_, err := conn.Read(make([]byte, 1))
+			if !errors.Is(err, errSRTShutdown) {
+				t.Errorf("Read() after shutdown error = %v", err)
+			}
+		})
+	}
+}
+
+func TestSRTConnACK(t *testing.T) {
+	l := newTestSRTListener(t, "")
+	connCh := make(chan *srtConn, 1)
+	go func() {
+		conn, _ := l.accept()
+		connCh <- conn
+	}()
+	caller := dialTestSRT(t, l.pc.LocalAddr())
+	caller.handshake("", false)
+	conn := <-connCh
+
+	caller.sendData(100, []byte{1})
+	caller.sendData(101, []byte{2})
+	// the connection is read on, as packets are acknowledged while it is read
+	go io.ReadFull(conn, make([]byte, 3))
+
+	ack := caller.readControl(srtTypeACK, time.Second)
+	if ack == nil {
+		t.Fatal("no ACK received")
+	}
+	if next := binary.BigEndian.Uint32(ack[srtHeaderSize:]); next != 102 {
+		t.Errorf("ACK of %d, want 102", next)
+	}
+	if id := binary.BigEndian.Uint32(ack[12:]); id != caller.socketID {
+		t.Errorf("ACK sent to socket %x, want %x", id, caller.socketID)
+	}
+
+	// the connection is closed by the recorder
+	conn.close()
+	if caller.readControl(srtTypeShutdown, time.Second) == nil {
+		t.Error("no shutdown received")
+	}
+}
+
+func TestSRTLossList(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint32
+		want []uint32
+	}{
+		{"single", []uint32{5}, []uint32{5}},
+		{"range", []uint32{5, 6, 7}, []uint32{5 | 0x80000000, 7}},
+		{"unsorted", []uint32{9, 5, 6}, []uint32{5 | 0x80000000, 6, 9}},
+		{"wrapping", []uint32{0, 0x7FFFFFFF}, []uint32{0x7FFFFFFF | 0x80000000, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := srtLossList(tt.seqs)
+			var got []uint32
+			for i := 0; i+4 <= len(b); i += 4 {
+				got = append(got, binary.BigEndian.Uint32(b[i:]))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("srtLossList() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package recorder
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/asticode/go-astits"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pedrohba1/SSCS/services/conf"
+	"github.com/pedrohba1/SSCS/services/helpers"
+	BaseLogger "github.com/pedrohba1/SSCS/services/logger"
+)
+
+// SRTRecorder records the MPEG-TS stream that an encoder pushes through SRT. It
+// listens on the host and port of the camera URL, such as srt://:8890?streamid=mystream,
+// and accepts a single caller, that must ask for the stream ID of the URL, if any.
+// Each camera needs its own port. Encrypted streams are not supported.
+//
+// H264 or H265 video is recorded, along with AAC or Opus audio.
+type SRTRecorder struct {
+	feed
+	pc       net.PacketConn
+	listener *srtListener
+	address  string
+	streamID string
+}
+
+// This code requires the FFmpeg libraries, like the RTSP recorder.
+func NewSRTRecorder(camera conf.CameraConfig, eChans EventChannels) *SRTRecorder {
+	r := &SRTRecorder{
+		feed: newFeed(camera, eChans),
+	}
+	r.setupLogger()
+
+	return r
+}
+
+func (r *SRTRecorder) setupLogger() {
+	r.logger = BaseLogger.BaseLogger.WithField("package", "recorder").WithField("camera", r.camera.ID)
+}
+
+func (r *SRTRecorder) Start() error {
+	u, err := url.Parse(r.camera.URL)
+	if err != nil {
+		r.logger.Errorf("failed to parse url: %v", err)
+		return err
+	}
+	r.address = u.Host
+	if u.Port() == "" {
+		r.address = net.JoinHostPort(u.Hostname(), "8890")
+	}
+	r.streamID = u.Query().Get("streamid")
+
+	cfg, _ := conf.ReadConf()
+	err = helpers.EnsureDirectoryExists(r.camera.RecordingsPath(cfg.Recorder.RecordingsDir))
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.backoff = newBackoff(cfg.Recorder.Reconnect)
+
+	r.pc, err = net.ListenPacket("udp", r.address)
+	if err != nil {
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.listener, err = newSRTListener(r.pc, r.streamID)
+	if err != nil {
+		r.pc.Close()
+		r.logger.Errorf("%v", err)
+		return err
+	}
+	r.logger.Infof("waiting for a SRT caller on %s", r.address)
+
+	r.wg.Add(1)
+	go r.supervise(r.record)
+	return nil
+}
+
+func (r *SRTRecorder) Stop() error {
+	close(r.stopCh)
+	r.pc.Close()
+	r.wg.Wait()
+	return nil
+}
+
+// record runs a single recording session, from the caller
+// connecting until it goes away or the recorder is stopped.
+func (r *SRTRecorder) record() error {
+	conn, err := r.listener.accept()
+	if err != nil {
+		select {
+		case <-r.stopCh:
+			return nil
+		default:
+			return err
+		}
+	}
+
+	s := &srtSession{
+		r:     r,
+		conn:  conn,
+		muxCh: make(chan *muxer, 1),
+	}
+
+	// the connection is read by another goroutine, so
+	// that triggers are handled while waiting for it
+	readErrCh := make(chan error, 1)
+	go func() {
+		readErrCh <- s.run()
+	}()
+
+	defer s.close()
+
+	var mux *muxer
+	for {
+		select {
+		case <-r.stopCh:
+			r.logger.Info("received stop signal")
+			conn.close()
+			<-readErrCh
+			return nil
+		case err := <-readErrCh:
+			return err
+		case t := <-r.eChans.TriggerIn:
+			r.logger.Debugf("event triggered: %s", t.Reason)
+			if mux == nil {
+				continue
+			}
+			err := mux.trigger()
+			if err != nil {
+				r.logger.Errorf("%v", err)
+			}
+		case mux = <-s.muxCh:
+		}
+	}
+}
+
+// srtSession is the connection of a caller.
+type srtSession struct {
+	r    *SRTRecorder
+	conn *srtConn
+
+	codec    videoCodec
+	frameDec frameDecoder
+	sampler  *frameSampler
+
+	// mux is created once the tracks are known.
+	// muxCh hands it over to the recorder, for the triggers.
+	mux   *muxer
+	muxCh chan *muxer
+
+	// frames can't be decoded before a random access access unit is received
+	randomAccessReceived bool
+}
+
+// close closes the muxer and the decoder. It is called once run returned.
+func (s *srtSession) close() {
+	if s.mux != nil {
+		s.mux.close()
+	}
+	if s.frameDec != nil {
+		s.frameDec.close()
+	}
+}
+
+// run demuxes the MPEG-TS stream of the caller.
+func (s *srtSession) run() error {
+	reader, err := mpegts.NewReader(bufio.NewReader(s.conn))
+	if err != nil {
+		return err
+	}
+
+	var videoTrack, audioTrack *mpegts.Track
+	var audio audioCodec
+	for _, track := range reader.Tracks() {
+		switch c := track.Codec.(type) {
+		case *mpegts.CodecH264:
+			if s.codec == nil {
+				videoTrack, s.codec = track, &h264Codec{}
+			}
+		case *mpegts.CodecH265:
+			if s.codec == nil {
+				videoTrack, s.codec = track, &h265Codec{}
+			}
+		case *mpegts.CodecMPEG4Audio:
+			if audio == nil {
+				audioTrack, audio = track, &aacCodec{config: &c.Config}
+			}
+		case *mpegts.CodecOpus:
+			if audio == nil {
+				audioTrack, audio = track, &opusCodec{channelCount: c.ChannelCount}
+			}
+		}
+	}
+	if s.codec == nil {
+		return errNoVideo
+	}
+	s.r.logger.Infof("recording %s video", s.codec.name())
+	if audio != nil {
+		s.r.logger.Infof("recording %s audio", audio.name())
+	} else {
+		s.r.logger.Info("no audio to record")
+	}
+
+	s.frameDec, err = s.codec.newDecoder(analyticsMaxSize(s.r.camera.Analytics))
+	if err != nil {
+		return err
+	}
+	s.sampler = newFrameSampler(s.r.camera.Analytics)
+	s.mux = newMuxer(s.r.camera, s.codec, audio, s.r.eChans.RecordOut, s.r.eChans.Stream)
+	s.muxCh <- s.mux
+	s.r.feedPlaying()
+
+	// all the tracks share the same time base
+	var td *mpegts.TimeDecoder
+	decodeTime := func(ts int64) time.Duration {
+		if td == nil {
+			td = mpegts.NewTimeDecoder(ts)
+		}
+		return td.Decode(ts)
+	}
+
+	reader.OnDecodeError(func(err error) {
+		s.r.logger.Warnf("%v", err)
+	})
+
+	reader.OnDataH26x(videoTrack, func(pts int64, dts int64, au [][]byte) error {
+		d := decodeTime(dts)
+		p := d + time.Duration((pts-dts)&0x1FFFFFFFF)*time.Second/90000
+		return s.onAccessUnit(au, p)
+	})
+
+	if audioTrack != nil {
+		switch audio.(type) {
+		case *aacCodec:
+			reader.OnDataMPEG4Audio(audioTrack, func(pts int64, aus [][]byte) error {
+				return s.onAudio(aus, decodeTime(pts))
+			})
+		case *opusCodec:
+			reader.OnDataOpus(audioTrack, func(pts int64, packets [][]byte) error {
+				return s.onAudio(packets, decodeTime(pts))
+			})
+		}
+	}
+
+	s.r.logger.Info("recording...")
+	for {
+		err := reader.Read()
+		if err != nil {
+			if errors.Is(err, astits.ErrNoMorePackets) {
+				return fmt.Errorf("stream ended")
+			}
+			return err
+		}
+	}
+}
+
+// onAccessUnit decodes a video access unit into frames and records it.
+func (s *srtSession) onAccessUnit(au [][]byte, pts time.Duration) error {
+	keyframe := s.codec.isRandomAccess(au)
+	if !s.randomAccessReceived {
+		s.randomAccessReceived = keyframe
+	}
+
+	// the access unit is recorded first, so that its frames can point to the segment it is in
+	err := s.mux.encode(au, pts)
+	if err != nil {
+		s.r.logger.Errorf("%v", err)
+	}
+
+	// only the sampled frames are converted and sent to the recognizers. When only
+	// the keyframes are analyzed, the other access units are not decoded at all
+	if !s.randomAccessReceived || !s.sampler.decode(keyframe) {
+		return nil
+	}
+	sampled := s.sampler.sample(pts)
+
+	for _, nalu := range au {
+		if !sampled {
+			s.frameDec.skip(nalu)
+			continue
+		}
+
+		img, err := s.frameDec.decode(nalu)
+		if err != nil {
+			s.r.logger.Errorf("Failed to decode NALU: %v", err)
+			continue
+		}
+		if img == nil {
+			continue
+		}
+
+		err = s.r.sendFrame(s.r.newFrame(img, s.frameDec.framePool(), pts, keyframe, s.mux.segment(pts)))
+		if err != nil {
+			s.r.logger.Errorf("Failed to send frame: %v", err)
+		}
+	}
+	return nil
+}
+
+// onAudio records audio frames, that are only needed by the recordings.
+func (s *srtSession) onAudio(frames [][]byte, pts time.Duration) error {
+	err := s.mux.encodeAudio(frames, pts)
+	if err != nil {
+		s.r.logger.Errorf("%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package recorder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+)
+
+// StreamTracks describes the tracks of a recording session.
+// Audio is nil when the feed has no audio that can be recorded.
+type StreamTracks struct {
+	Video mpegts.Codec
+	Audio mpegts.Codec
+}
+
+// Unit is a video access unit, or a group of audio access units, of a camera feed,
+// as they are recorded. Units of the same recording session share their Tracks,
+// so a change of Tracks means that the timestamps start over.
+// Units are shared by all the readers, that must not modify them.
+type Unit struct {
+	Tracks       *StreamTracks
+	Video        bool
+	AU           [][]byte
+	PTS          time.Duration
+	DTS          time.Duration
+	RandomAccess bool
+	Time         time.Time // when the unit was received
+}
+
+// Stream shares the units received by the recorder of a camera with its live
+// outputs, so that the camera is connected to only once. Units are dropped for
+// the readers that don't keep up, so they never slow down the recording.
+//
+// A nil Stream can be used by the recorder, and discards everything.
+type Stream struct {
+	mu      sync.RWMutex
+	tracks  *StreamTracks
+	readers map[*StreamReader]struct{}
+}
+
+// StreamReader receives the units of a Stream from C, until it is closed.
+type StreamReader struct {
+	C <-chan *Unit
+
+	c      chan *Unit
+	stream *Stream
+}
+
+// NewStream allocates a Stream.
+func NewStream() *Stream {
+	return &Stream{
+		readers: make(map[*StreamReader]struct{}),
+	}
+}
+
+// NewReader returns a reader of the units published from now on,
+// that buffers up to size of them.
+func (s *Stream) NewReader(size int) *StreamReader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := make(chan *Unit, size)
+	r := &StreamReader{C: c, c: c, stream: s}
+	s.readers[r] = struct{}{}
+	return r
+}
+
+// Close stops the reader from receiving units.
+func (r *StreamReader) Close() {
+	r.stream.mu.Lock()
+	defer r.stream.mu.Unlock()
+	delete(r.stream.readers, r)
+}
+
+// Tracks returns the tracks of the current recording session,
+// or nil while the camera is not being recorded.
+func (s *Stream) Tracks() *StreamTracks {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tracks
+}
+
+// start begins a recording session with the given tracks.
+func (s *Stream) start(tracks *StreamTracks) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracks = tracks
+}
+
+// end ends the current recording session.
+func (s *Stream) end() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tracks = nil
+}
+
+// publish sends a copy of a sample to the readers.
+func (s *Stream) publish(smp *sample) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tracks == nil || len(s.readers) == 0 {
+		return
+	}
+
+	c := smp.clone()
+	u := &Unit{
+		Tracks:       s.tracks,
+		Video:        c.video,
+		AU:           c.au,
+		PTS:          c.pts,
+		DTS:          c.dts,
+		RandomAccess: c.randomAccess,
+		Time:         c.time,
+	}
+
+	for r := range s.readers {
+		select {
+		case r.c <- u:
+		default:
+		}
+	}
+}
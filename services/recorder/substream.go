@@ -0,0 +1,182 @@
+package recorder
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pedrohba1/SSCS/services/camera"
+
+	"github.com/bluenviron/gortsplib/v4"
+	"github.com/bluenviron/gortsplib/v4/pkg/base"
+	"github.com/pion/rtp"
+)
+
+// substream reads the substream of a camera, whose frames are sent to the recognizers
+// instead of the ones of the main stream, that is only recorded. The frames carry the
+// last keyframe of the main stream, so that thumbnails are taken at full resolution.
+type substream struct {
+	r        *RTSP_H264Recorder
+	client   *gortsplib.Client
+	frameDec frameDecoder
+	mux      *muxer
+
+	// main is the last keyframe of the main stream, nil until one is decoded
+	mainMu sync.Mutex
+	main   *camera.Frame
+}
+
+// startSubstream connects to the substream of the camera, and starts sending its frames.
+// Frames point to the segment mux is recording the main stream into.
+func (r *RTSP_H264Recorder) startSubstream(mux *muxer) (*substream, error) {
+	u, err := base.ParseURL(r.substreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newRTSPClient(r.camera.RTSP)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.Start(u.Scheme, u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start RTSP client of the substream: %w", err)
+	}
+
+	s := &substream{
+		r:      r,
+		client: client,
+		mux:    mux,
+	}
+
+	err = s.play(u)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("substream: %w", err)
+	}
+	return s, nil
+}
+
+func (s *substream) play(u *base.URL) error {
+	desc, _, err := s.client.Describe(u)
+	if err != nil {
+		return err
+	}
+
+	medi, forma, codec, err := findVideoCodec(desc)
+	if err != nil {
+		return err
+	}
+	s.r.logger.Infof("analyzing the %s substream", codec.name())
+
+	s.frameDec, err = codec.newDecoder(analyticsMaxSize(s.r.camera.Analytics))
+	if err != nil {
+		return err
+	}
+	for _, param := range codec.parameters() {
+		s.frameDec.decode(param)
+	}
+	sampler := newFrameSampler(s.r.camera.Analytics)
+
+	_, err = s.client.Setup(desc.BaseURL, medi, 0, 0)
+	if err != nil {
+		return err
+	}
+
+	// frames can't be decoded before a random access access unit is received
+	randomAccessReceived := false
+
+	s.client.OnPacketRTP(medi, forma, func(pkt *rtp.Packet) {
+		pts, ok := s.client.PacketPTS(medi, pkt)
+		if !ok {
+			return
+		}
+
+		au, err := codec.decodeRTP(pkt)
+		if err != nil {
+			s.r.logger.Errorf("%v", err)
+			return
+		}
+		if au == nil {
+			return
+		}
+
+		keyframe := codec.isRandomAccess(au)
+		if !randomAccessReceived {
+			randomAccessReceived = keyframe
+		}
+		if !randomAccessReceived || !sampler.decode(keyframe) {
+			return
+		}
+		sampled := sampler.sample(pts)
+
+		for _, nalu := range au {
+			if !sampled {
+				s.frameDec.skip(nalu)
+				continue
+			}
+
+			img, err := s.frameDec.decode(nalu)
+			if err != nil {
+				s.r.logger.Errorf("Failed to decode NALU: %v", err)
+				continue
+			}
+			if img == nil {
+				continue
+			}
+
+			// the substream has its own timestamps, so frames point to
+			// the moment of the recordings they are received at
+			frame := s.r.newFrame(img, s.frameDec.framePool(), pts, keyframe, s.mux.currentSegment())
+			frame.Main = s.mainFrame()
+
+			err = s.r.sendFrame(frame)
+			if err != nil {
+				s.r.logger.Errorf("Failed to send frame: %v", err)
+			}
+		}
+	})
+
+	_, err = s.client.Play(nil)
+	return err
+}
+
+// setMain replaces the last keyframe of the main stream, releasing the previous one.
+func (s *substream) setMain(frame camera.Frame) {
+	s.mainMu.Lock()
+	defer s.mainMu.Unlock()
+
+	if s.main != nil {
+		s.main.Release()
+	}
+	s.main = &frame
+}
+
+// mainFrame returns the last keyframe of the main stream, retained
+// for the frame it is attached to, or nil if there is none yet.
+func (s *substream) mainFrame() *camera.Frame {
+	s.mainMu.Lock()
+	defer s.mainMu.Unlock()
+
+	if s.main == nil {
+		return nil
+	}
+	s.main.Retain()
+	main := *s.main
+	return &main
+}
+
+// close closes the client first, so that no packet arrives while the decoder is being closed.
+func (s *substream) close() {
+	s.client.Close()
+	if s.frameDec != nil {
+		s.frameDec.close()
+	}
+
+	s.mainMu.Lock()
+	defer s.mainMu.Unlock()
+	if s.main != nil {
+		s.main.Release()
+		s.main = nil
+	}
+}
@@ -0,0 +1,319 @@
+package recorder
+
+import (
+	"errors"
+	"image"
+	"time"
+
+	"github.com/bluenviron/gortsplib/v4/pkg/description"
+	"github.com/bluenviron/gortsplib/v4/pkg/format"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph264"
+	"github.com/bluenviron/gortsplib/v4/pkg/format/rtph265"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h264"
+	"github.com/bluenviron/mediacommon/pkg/codecs/h265"
+	"github.com/bluenviron/mediacommon/pkg/formats/fmp4"
+	"github.com/bluenviron/mediacommon/pkg/formats/mpegts"
+	"github.com/pion/rtp"
+)
+
+// errNoVideo is returned when a feed doesn't offer any supported video codec.
+var errNoVideo = errors.New("media not found: the feed offers neither H264 nor H265")
+
+// videoCodec handles what is specific to each of the video codecs the
+// recorder supports, so that the rest of the recorder doesn't depend on it.
+//
+// A videoCodec is stateful: it keeps the latest parameter sets of the stream
+// and the state needed to extract decoding timestamps. Each recording session
+// must use its own.
+type videoCodec interface {
+	// name returns a human readable name of the codec.
+	name() string
+
+	// decodeRTP extracts an access unit from RTP packets.
+	// It returns nil, without error, while more packets are needed.
+	decodeRTP(pkt *rtp.Packet) ([][]byte, error)
+
+	// isRandomAccess tells if an access unit can be decoded on its own.
+	isRandomAccess(au [][]byte) bool
+
+	// prepare removes the parameter sets and delimiters of an access unit, storing
+	// the parameter sets, and returns the access unit ready to be muxed: with an
+	// AUD in front and, when it is a random access, with the parameter sets.
+	// It returns nil when the access unit has nothing to be muxed.
+	prepare(au [][]byte) (prepared [][]byte, randomAccess bool)
+
+	// extractDTS returns the decoding timestamp of a prepared access unit. ok is
+	// false while no random access has been received, as nothing can be muxed.
+	extractDTS(au [][]byte, pts time.Duration, randomAccess bool) (dts time.Duration, ok bool, err error)
+
+	// parameters returns the parameter sets known so far.
+	parameters() [][]byte
+
+	// mpegtsCodec returns the codec of the MPEG-TS track,
+	// or nil when MPEG-TS can't carry the codec.
+	mpegtsCodec() mpegts.Codec
+
+	// fmp4Codec returns the codec of the fMP4 track,
+	// with the parameter sets known so far.
+	fmp4Codec() fmp4.Codec
+
+	// fmp4Sample returns the fMP4 sample of a prepared access unit.
+	fmp4Sample(ptsOffset int32, randomAccess bool, au [][]byte) (*fmp4.PartSample, error)
+
+	// newDecoder allocates a decoder that converts the stream into frames,
+	// scaled down to fit into maxSize. A zero coordinate doesn't limit that dimension.
+	newDecoder(maxSize image.Point) (frameDecoder, error)
+}
+
+// findVideoCodec looks for the first H264 or H265 format offered by the feed,
+// so that the codec is chosen automatically from the SDP.
+func findVideoCodec(desc *description.Session) (*description.Media, format.Format, videoCodec, error) {
+	for _, medi := range desc.Medias {
+		for _, forma := range medi.Formats {
+			switch forma := forma.(type) {
+			case *format.H264:
+				rtpDec, err := forma.CreateDecoder()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				return medi, forma, &h264Codec{rtpDec: rtpDec, sps: forma.SPS, pps: forma.PPS}, nil
+
+			case *format.H265:
+				rtpDec, err := forma.CreateDecoder()
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				return medi, forma, &h265Codec{rtpDec: rtpDec, vps: forma.VPS, sps: forma.SPS, pps: forma.PPS}, nil
+			}
+		}
+	}
+	return nil, nil, nil, errNoVideo
+}
+
+// nonNil returns the given parameter sets that are not nil.
+func nonNil(params ...[]byte) [][]byte {
+	var ret [][]byte
+	for _, p := range params {
+		if p != nil {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// h264Codec is the videoCodec of H264 streams.
+type h264Codec struct {
+	rtpDec       *rtph264.Decoder
+	sps          []byte
+	pps          []byte
+	dtsExtractor *h264.DTSExtractor
+}
+
+func (c *h264Codec) name() string {
+	return "H264"
+}
+
+func (c *h264Codec) decodeRTP(pkt *rtp.Packet) ([][]byte, error) {
+	au, err := c.rtpDec.Decode(pkt)
+	if err == rtph264.ErrNonStartingPacketAndNoPrevious || err == rtph264.ErrMorePacketsNeeded {
+		return nil, nil
+	}
+	return au, err
+}
+
+func (c *h264Codec) isRandomAccess(au [][]byte) bool {
+	return h264.IDRPresent(au)
+}
+
+func (c *h264Codec) prepare(au [][]byte) ([][]byte, bool) {
+	// prepend an AUD. This is required by some players
+	filteredAU := [][]byte{
+		{byte(h264.NALUTypeAccessUnitDelimiter), 240},
+	}
+
+	nonIDRPresent := false
+	idrPresent := false
+
+	for _, nalu := range au {
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		switch typ {
+		case h264.NALUTypeSPS:
+			c.sps = nalu
+			continue
+
+		case h264.NALUTypePPS:
+			c.pps = nalu
+			continue
+
+		case h264.NALUTypeAccessUnitDelimiter:
+			continue
+
+		case h264.NALUTypeIDR:
+			idrPresent = true
+
+		case h264.NALUTypeNonIDR:
+			nonIDRPresent = true
+		}
+
+		filteredAU = append(filteredAU, nalu)
+	}
+
+	au = filteredAU
+
+	if len(au) <= 1 || (!nonIDRPresent && !idrPresent) {
+		return nil, false
+	}
+
+	// add SPS and PPS before every access unit that contains an IDR
+	if idrPresent {
+		au = append([][]byte{c.sps, c.pps}, au...)
+	}
+
+	return au, idrPresent
+}
+
+func (c *h264Codec) extractDTS(au [][]byte, pts time.Duration, randomAccess bool) (time.Duration, bool, error) {
+	if c.dtsExtractor == nil {
+		// skip samples silently until we find one with a IDR
+		if !randomAccess {
+			return 0, false, nil
+		}
+		c.dtsExtractor = h264.NewDTSExtractor()
+	}
+
+	dts, err := c.dtsExtractor.Extract(au, pts)
+	return dts, err == nil, err
+}
+
+func (c *h264Codec) parameters() [][]byte {
+	return nonNil(c.sps, c.pps)
+}
+
+func (c *h264Codec) mpegtsCodec() mpegts.Codec {
+	return &mpegts.CodecH264{}
+}
+
+func (c *h264Codec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecH264{SPS: c.sps, PPS: c.pps}
+}
+
+func (c *h264Codec) fmp4Sample(ptsOffset int32, randomAccess bool, au [][]byte) (*fmp4.PartSample, error) {
+	return fmp4.NewPartSampleH26x(ptsOffset, randomAccess, au)
+}
+
+func (c *h264Codec) newDecoder(maxSize image.Point) (frameDecoder, error) {
+	d, err := newH264Decoder(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// h265Codec is the videoCodec of H265 (HEVC) streams.
+type h265Codec struct {
+	rtpDec       *rtph265.Decoder
+	vps          []byte
+	sps          []byte
+	pps          []byte
+	dtsExtractor *h265.DTSExtractor
+}
+
+func (c *h265Codec) name() string {
+	return "H265"
+}
+
+func (c *h265Codec) decodeRTP(pkt *rtp.Packet) ([][]byte, error) {
+	au, err := c.rtpDec.Decode(pkt)
+	if err == rtph265.ErrNonStartingPacketAndNoPrevious || err == rtph265.ErrMorePacketsNeeded {
+		return nil, nil
+	}
+	return au, err
+}
+
+func (c *h265Codec) isRandomAccess(au [][]byte) bool {
+	return h265.IsRandomAccess(au)
+}
+
+func (c *h265Codec) prepare(au [][]byte) ([][]byte, bool) {
+	// prepend an AUD. This is required by some players
+	filteredAU := [][]byte{
+		{byte(h265.NALUType_AUD_NUT) << 1, 1, 0x50},
+	}
+
+	isRandomAccess := false
+
+	for _, nalu := range au {
+		typ := h265.NALUType((nalu[0] >> 1) & 0b111111)
+		switch typ {
+		case h265.NALUType_VPS_NUT:
+			c.vps = nalu
+			continue
+
+		case h265.NALUType_SPS_NUT:
+			c.sps = nalu
+			continue
+
+		case h265.NALUType_PPS_NUT:
+			c.pps = nalu
+			continue
+
+		case h265.NALUType_AUD_NUT:
+			continue
+
+		case h265.NALUType_IDR_W_RADL, h265.NALUType_IDR_N_LP, h265.NALUType_CRA_NUT:
+			isRandomAccess = true
+		}
+
+		filteredAU = append(filteredAU, nalu)
+	}
+
+	au = filteredAU
+
+	if len(au) <= 1 {
+		return nil, false
+	}
+
+	// add VPS, SPS and PPS before every random access access unit
+	if isRandomAccess {
+		au = append([][]byte{c.vps, c.sps, c.pps}, au...)
+	}
+
+	return au, isRandomAccess
+}
+
+func (c *h265Codec) extractDTS(au [][]byte, pts time.Duration, randomAccess bool) (time.Duration, bool, error) {
+	if c.dtsExtractor == nil {
+		// skip samples silently until we find a random access one
+		if !randomAccess {
+			return 0, false, nil
+		}
+		c.dtsExtractor = h265.NewDTSExtractor()
+	}
+
+	dts, err := c.dtsExtractor.Extract(au, pts)
+	return dts, err == nil, err
+}
+
+func (c *h265Codec) parameters() [][]byte {
+	return nonNil(c.vps, c.sps, c.pps)
+}
+
+func (c *h265Codec) mpegtsCodec() mpegts.Codec {
+	return &mpegts.CodecH265{}
+}
+
+func (c *h265Codec) fmp4Codec() fmp4.Codec {
+	return &fmp4.CodecH265{VPS: c.vps, SPS: c.sps, PPS: c.pps}
+}
+
+func (c *h265Codec) fmp4Sample(ptsOffset int32, randomAccess bool, au [][]byte) (*fmp4.PartSample, error) {
+	return fmp4.NewPartSampleH26x(ptsOffset, randomAccess, au)
+}
+
+func (c *h265Codec) newDecoder(maxSize image.Point) (frameDecoder, error) {
+	d, err := newH265Decoder(maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
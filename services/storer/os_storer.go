@@ -30,12 +30,26 @@ func NewOSStorer(eChans EventChannels) *OSStorer {
 
 	cfg, _ := conf.ReadConf()
 
+	var quotas []cameraQuota
+	for _, cam := range cfg.CameraList() {
+		if cam.Retention.SizeLimit == 0 && cam.Retention.MaxAge == 0 {
+			continue
+		}
+		quotas = append(quotas, cameraQuota{
+			cameraID:   cam.ID,
+			folderPath: cam.RecordingsPath(cfg.Recorder.RecordingsDir),
+			sizeLimit:  cam.Retention.SizeLimit,
+			maxAge:     time.Duration(cam.Retention.MaxAge) * time.Hour,
+		})
+	}
+
 	s := &OSStorer{
 		cfg: Config{
-			sizeLimit:   cfg.Storer.SizeLimit,
-			checkPeriod: cfg.Storer.CheckPeriod,
-			folderPath:  cfg.Recorder.RecordingsDir,
-			backupPath:  cfg.Storer.BackupPath,
+			sizeLimit:    cfg.Storer.SizeLimit,
+			checkPeriod:  cfg.Storer.CheckPeriod,
+			folderPath:   cfg.Recorder.RecordingsDir,
+			backupPath:   cfg.Storer.BackupPath,
+			cameraQuotas: quotas,
 		},
 		eChans: eChans,
 		stopCh: make(chan struct{}),
@@ -102,24 +116,51 @@ func (s *OSStorer) monitor() error {
 }
 
 
-// checkAndCleanFolder checks for folder size and once it exceeds a limit, deletes the oldest files
+// checkAndCleanFolder applies the retention policy of each camera to its own folder,
+// and then checks the size of the whole recordings folder, deleting the oldest files
+// once it exceeds the global limit
 func (s *OSStorer) checkAndCleanFolder() error {
+	for _, quota := range s.cfg.cameraQuotas {
+		sizeLimit := int64(quota.sizeLimit)
+		if sizeLimit == 0 {
+			sizeLimit = -1
+		}
+		err := s.cleanFolder(quota.folderPath, sizeLimit, quota.maxAge)
+		if err != nil {
+			s.logger.Errorf("failed to clean recordings of camera %s: %v", quota.cameraID, err)
+		}
+	}
 
-	entries, err := os.ReadDir(s.cfg.folderPath)
-	if err != nil {
-		return err
+	return s.cleanFolder(s.cfg.folderPath, int64(s.cfg.sizeLimit), 0)
+}
 
-	}
-	infos := make([]fs.FileInfo, 0, len(entries))
-	for _, entry := range entries {
+// cleanFolder deletes, oldest first, the files of a folder and its subfolders
+// until their size is within sizeLimit, along with all the files older than maxAge.
+// A negative sizeLimit or a zero maxAge disables the respective check.
+func (s *OSStorer) cleanFolder(folderPath string, sizeLimit int64, maxAge time.Duration) error {
+	var paths []string
+	var infos []fs.FileInfo
+	err := filepath.WalkDir(folderPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
 		info, err := entry.Info()
 		if err != nil {
 			return err
 		}
+		paths = append(paths, path)
 		infos = append(infos, info)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	sort.Sort(helpers.ByModTime(infos))
+	// sort the files and their paths together, oldest first
+	sort.Sort(byModTimeWithPaths{helpers.ByModTime(infos), paths})
 
 	// Calculate the total size
 	var totalSize int64 = 0
@@ -127,22 +168,33 @@ func (s *OSStorer) checkAndCleanFolder() error {
 	for _, info := range infos {
 		totalSize += info.Size()
 	}
-	s.logger.Infof("Folder size before deletion: %.2f MB ", float64(totalSize)/1024/1024)
+	s.logger.Infof("%s size before deletion: %.2f MB ", folderPath, float64(totalSize)/1024/1024)
 
 	// Delete the oldest files if the total size exceeds the limit
 	var deletedSize int64 = 0
-	for _, info := range infos {
-		// when the limit is reached, break the loop
-		if totalSize <= int64(s.cfg.sizeLimit) {
+	for i, info := range infos {
+		expired := maxAge > 0 && time.Since(info.ModTime()) > maxAge
+		overLimit := sizeLimit >= 0 && totalSize > sizeLimit
+
+		// when the limits are reached, break the loop. Files are
+		// sorted oldest first, so the remaining ones are newer
+		if !expired && !overLimit {
 			break
 		}
 
-		oldestFilePath := filepath.Join(s.cfg.folderPath, info.Name())
+		oldestFilePath := paths[i]
+		relPath, err := filepath.Rel(s.cfg.folderPath, oldestFilePath)
+		if err != nil {
+			relPath = info.Name()
+		}
 
 		// If backupPath is defined, move the file there, otherwise remove the file.
 		if s.cfg.backupPath != "" {
-			backupFilePath := filepath.Join(s.cfg.backupPath, info.Name())
-			err := os.Rename(oldestFilePath, backupFilePath)
+			backupFilePath := filepath.Join(s.cfg.backupPath, relPath)
+			err := helpers.EnsureDirectoryExists(filepath.Dir(backupFilePath))
+			if err == nil {
+				err = os.Rename(oldestFilePath, backupFilePath)
+			}
 			if err != nil {
 				s.logger.Error("Error moving file to backup directory: ", err)
 				continue
@@ -160,7 +212,7 @@ func (s *OSStorer) checkAndCleanFolder() error {
 		deletedSize += info.Size()
 		totalSize -= info.Size()
 		s.eChans.CleanOut <- CleanedEvent{
-			filename:   info.Name(),
+			filename:   relPath,
 			fileSize:   int(info.Size()),
 			fileStatus: FileErased,
 		}
@@ -171,6 +223,18 @@ func (s *OSStorer) checkAndCleanFolder() error {
 	return nil
 }
 
+// byModTimeWithPaths sorts files by their modification time,
+// keeping each file path next to its info
+type byModTimeWithPaths struct {
+	helpers.ByModTime
+	paths []string
+}
+
+func (s byModTimeWithPaths) Swap(i, j int) {
+	s.ByModTime.Swap(i, j)
+	s.paths[i], s.paths[j] = s.paths[j], s.paths[i]
+}
+
 // OpenFiles takes a slice of filenames and attempts to open each one.
 // It returns a slice of *os.File and any error encountered.
 func (s *OSStorer) OpenFiles(filenames []string) ([]*os.File, error) {
@@ -5,7 +5,10 @@
 // up on some external storage.
 package storer
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // Storer is an interface for a Storer component
 //
@@ -26,10 +29,20 @@ type EventChannels struct {
 // Config contains all parameters that can be customized
 // via the sscs.yml file.
 type Config struct {
-	sizeLimit   int
-	checkPeriod int
-	folderPath  string
-	backupPath  string
+	sizeLimit    int
+	checkPeriod  int
+	folderPath   string
+	backupPath   string
+	cameraQuotas []cameraQuota
+}
+
+// cameraQuota is the retention policy applied to
+// the recordings folder of a single camera
+type cameraQuota struct {
+	cameraID   string
+	folderPath string
+	sizeLimit  int           // zero means no limit
+	maxAge     time.Duration // zero means no limit
 }
 
 // used to indicate if a file was moved or deleted